@@ -2,12 +2,12 @@ package collection
 
 import (
 	"container/list"
-
 	"log"
 	"sync"
 	"time"
 
 	"github.com/uc1024/f90/core/mathx"
+	"github.com/uc1024/f90/core/statx"
 	"github.com/uc1024/f90/core/syncx"
 )
 
@@ -15,6 +15,7 @@ var (
 	default_cache_expire   = time.Minute * 30
 	default_cache_slots    = 300 // * 默认一轮的槽位
 	default_cache_interval = time.Minute
+	default_cache_name     = "collection" // * 未命名的缓存共用一个统计
 )
 
 const (
@@ -34,6 +35,8 @@ type (
 		barrier     syncx.ShareResults
 		unstable    mathx.Unstable
 		execute     func(k, v interface{})
+		stat        *statx.CacheStat
+		closeOnce   sync.Once
 	}
 )
 
@@ -58,6 +61,9 @@ func NewCache(expire time.Duration, opt ...cacheOption) (cache *Cache, err error
 	for _, o := range opt {
 		o(cache)
 	}
+	if len(cache.name) == 0 {
+		cache.name = default_cache_name
+	}
 
 	wheel, err := NewTimingWheel(time.Second, default_cache_slots, cache.execute)
 
@@ -66,10 +72,19 @@ func NewCache(expire time.Duration, opt ...cacheOption) (cache *Cache, err error
 	}
 
 	cache.timingWheel = wheel
+	cache.stat = statx.NewCacheStat(cache.name)
 
 	return
 }
 
+// Close stops the expiration of the keys and unregisters the stat, the cache can't be used after closed.
+func (c *Cache) Close() {
+	c.closeOnce.Do(func() {
+		c.timingWheel.Stop()
+		c.stat.Release()
+	})
+}
+
 func (c *Cache) Del(key string) {
 	c.lock.Lock()
 	delete(c.data, key)    // * 删除数据
@@ -79,7 +94,9 @@ func (c *Cache) Del(key string) {
 }
 
 func (c *Cache) Get(key string) (value interface{}, b bool) {
-	return c.doGet(key)
+	value, b = c.doGet(key)
+	c.record(b)
+	return
 }
 
 func (c *Cache) Set(key string, value interface{}) {
@@ -115,6 +132,7 @@ func (c *Cache) Take(key string,
 	fetch func() (interface{}, error)) (value interface{}, err error) {
 
 	value, ok := c.doGet(key)
+	c.record(ok)
 
 	if ok {
 		return
@@ -125,8 +143,11 @@ func (c *Cache) Take(key string,
 		if val, ok := c.doGet(key); ok {
 			return val, nil
 		}
+		start := time.Now()
 		results, e := fetch()
+		c.stat.AddQuery(time.Since(start))
 		if e != nil {
+			c.stat.IncrementError()
 			return nil, e
 		}
 		c.Set(key, results)
@@ -162,6 +183,33 @@ func (c *Cache) onExile(key string) {
 	c.timingWheel.RemoveTimer(key)
 }
 
+// * 记录命中情况
+func (c *Cache) record(hit bool) {
+	if hit {
+		c.stat.IncrementHit()
+	} else {
+		c.stat.IncrementMiss()
+	}
+}
+
+// Stat returns the hit and miss counters of the cache.
+func (c *Cache) Stat() *statx.CacheStat {
+	return c.stat
+}
+
+// Keys returns the keys in the cache.
+func (c *Cache) Keys() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	keys := make([]string, 0, len(c.data))
+	for key := range c.data {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
 func (c *Cache) Size() int {
 	c.lock.Lock()
 	defer c.lock.Unlock()
@@ -179,6 +227,8 @@ func SetCacheLimit(i int) cacheOption {
 	}
 }
 
+// SetCacheName sets the name of the cache stat, caches with the same name share the counters,
+// the unnamed caches share the stat named "collection".
 func SetCacheName(name string) cacheOption {
 	return func(cache *Cache) {
 		cache.name = name
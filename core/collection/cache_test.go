@@ -4,6 +4,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/uc1024/f90/core/statx"
 	"github.com/uc1024/f90/core/syncx"
 )
 
@@ -18,3 +19,34 @@ func TestCache(t *testing.T) {
 	v1, _ := c.Get("test")
 	t.Log("11s value1", v1)
 }
+
+func TestCacheName(t *testing.T) {
+	a, err := NewCache(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewCache(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// * 未命名的缓存共用一个统计, 不会为每个缓存注册新的统计
+	if a.Stat() != b.Stat() || a.Stat().Name() != "collection" {
+		t.Fatalf("unnamed caches use different stats %s, %s", a.Stat().Name(), b.Stat().Name())
+	}
+
+	c, err := NewCache(time.Minute, SetCacheName("test:name"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Stat().Name() != "test:name" {
+		t.Fatalf("unexpected stat name %s", c.Stat().Name())
+	}
+
+	// * 关闭后注销统计
+	c.Close()
+	for _, snapshot := range statx.Caches() {
+		if snapshot.Name == "test:name" {
+			t.Fatal("stat is not unregistered after closed")
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package hashx
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+const (
+	// DefaultReplicas is the number of virtual nodes of a node with weight 1.
+	DefaultReplicas = 100
+)
+
+type (
+	// Func defines the hash method.
+	Func func(data []byte) uint64
+
+	// A ConsistentHash is a ring of nodes, each node is placed on the ring as replicas virtual nodes,
+	// so adding or removing a node only moves the keys of its neighbours.
+	ConsistentHash struct {
+		hashFunc Func
+		replicas int
+		keys     []uint64            // * 排序后的虚拟节点
+		ring     map[uint64][]string // * 虚拟节点对应的节点, 可能发生哈希冲突
+		nodes    map[string]int      // * 节点及其虚拟节点数量
+		lock     sync.RWMutex
+	}
+)
+
+// NewConsistentHash returns a ConsistentHash with DefaultReplicas and xxhash.
+func NewConsistentHash() *ConsistentHash {
+	return NewCustomConsistentHash(DefaultReplicas, nil)
+}
+
+// NewCustomConsistentHash returns a ConsistentHash with the given replicas and hash method.
+func NewCustomConsistentHash(replicas int, fn Func) *ConsistentHash {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+	if fn == nil {
+		fn = xxhash.Sum64
+	}
+
+	return &ConsistentHash{
+		hashFunc: fn,
+		replicas: replicas,
+		ring:     make(map[uint64][]string),
+		nodes:    make(map[string]int),
+	}
+}
+
+// Add adds the node with weight 1.
+func (h *ConsistentHash) Add(node string) {
+	h.AddWithWeight(node, 1)
+}
+
+// AddWithWeight adds the node with replicas*weight virtual nodes,
+// the node is replaced if it already exists.
+func (h *ConsistentHash) AddWithWeight(node string, weight int) {
+	if weight <= 0 {
+		weight = 1
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.remove(node)
+	replicas := h.replicas * weight
+	for i := 0; i < replicas; i++ {
+		hash := h.hashFunc([]byte(node + "#" + strconv.Itoa(i)))
+		if len(h.ring[hash]) == 0 {
+			h.keys = append(h.keys, hash)
+		}
+		h.ring[hash] = append(h.ring[hash], node)
+	}
+	h.nodes[node] = replicas
+
+	sort.Slice(h.keys, func(i, j int) bool {
+		return h.keys[i] < h.keys[j]
+	})
+}
+
+// Remove removes the node from the ring.
+func (h *ConsistentHash) Remove(node string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.remove(node)
+}
+
+// Get returns the node of key, false is returned if the ring is empty.
+func (h *ConsistentHash) Get(key string) (string, bool) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	if len(h.keys) == 0 {
+		return "", false
+	}
+
+	hash := h.hashFunc([]byte(key))
+	index := sort.Search(len(h.keys), func(i int) bool {
+		return h.keys[i] >= hash
+	}) % len(h.keys)
+
+	nodes := h.ring[h.keys[index]]
+	if len(nodes) == 1 {
+		return nodes[0], true
+	}
+
+	// * 虚拟节点冲突时再按 key 选择
+	return nodes[h.hashFunc([]byte(key+"#"))%uint64(len(nodes))], true
+}
+
+// Nodes returns the nodes on the ring.
+func (h *ConsistentHash) Nodes() []string {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	nodes := make([]string, 0, len(h.nodes))
+	for node := range h.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	return nodes
+}
+
+func (h *ConsistentHash) remove(node string) {
+	replicas, ok := h.nodes[node]
+	if !ok {
+		return
+	}
+
+	for i := 0; i < replicas; i++ {
+		hash := h.hashFunc([]byte(node + "#" + strconv.Itoa(i)))
+		nodes := h.ring[hash]
+		for j, n := range nodes {
+			if n == node {
+				nodes = append(nodes[:j], nodes[j+1:]...)
+				break
+			}
+		}
+		if len(nodes) > 0 {
+			h.ring[hash] = nodes
+			continue
+		}
+
+		delete(h.ring, hash)
+		index := sort.Search(len(h.keys), func(i int) bool {
+			return h.keys[i] >= hash
+		})
+		if index < len(h.keys) && h.keys[index] == hash {
+			h.keys = append(h.keys[:index], h.keys[index+1:]...)
+		}
+	}
+	delete(h.nodes, node)
+}
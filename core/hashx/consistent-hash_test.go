@@ -0,0 +1,44 @@
+package hashx
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsistentHash(t *testing.T) {
+	h := NewConsistentHash()
+	_, ok := h.Get("key")
+	assert.False(t, ok)
+
+	h.Add("a")
+	h.Add("b")
+	h.AddWithWeight("c", 2)
+	assert.Equal(t, []string{"a", "b", "c"}, h.Nodes())
+
+	const total = 10000
+	counts := make(map[string]int)
+	before := make(map[string]string)
+	for i := 0; i < total; i++ {
+		key := strconv.Itoa(i)
+		node, ok := h.Get(key)
+		assert.True(t, ok)
+		counts[node]++
+		before[key] = node
+	}
+	// * 权重为 2 的节点分到更多的 key
+	assert.Greater(t, counts["c"], counts["a"])
+	assert.Greater(t, counts["c"], counts["b"])
+
+	// * 移除节点只影响该节点上的 key
+	h.Remove("b")
+	for key, node := range before {
+		got, _ := h.Get(key)
+		if node != "b" {
+			assert.Equal(t, node, got)
+		} else {
+			assert.NotEqual(t, "b", got)
+		}
+	}
+}
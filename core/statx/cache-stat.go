@@ -0,0 +1,232 @@
+package statx
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/uc1024/f90/core/slogx"
+	"github.com/uc1024/f90/core/threadingx"
+)
+
+// DefaultLogInterval is the suggested interval of logging the cache stats.
+const DefaultLogInterval = time.Minute
+
+var (
+	registry = struct {
+		lock   sync.Mutex
+		caches map[string]*CacheStat
+	}{caches: make(map[string]*CacheStat)}
+
+	logInterval int64 // * 默认不输出日志, 由 SetLogInterval 开启
+	logOnce     sync.Once
+)
+
+type (
+	// A CacheStat counts the requests of the caches with the same name.
+	CacheStat struct {
+		name        string
+		refs        int // * 共用统计的缓存数, 由 registry.lock 保护
+		hit         uint64
+		miss        uint64
+		placeholder uint64 // * 命中 "不存在" 占位符的次数
+		errors      uint64
+		queries     uint64 // * 缓存未命中时查询数据源的次数
+		queryTime   int64  // * 查询数据源的总耗时(纳秒)
+		last        CacheSnapshot
+	}
+
+	// A CacheSnapshot is the cumulative counters of a CacheStat at a moment.
+	CacheSnapshot struct {
+		Name        string
+		Hit         uint64
+		Miss        uint64
+		Placeholder uint64
+		Errors      uint64
+		Queries     uint64
+		QueryTime   time.Duration
+	}
+)
+
+// NewCacheStat returns the CacheStat of name, caches with the same name share the counters.
+// Release should be called when the cache is closed.
+func NewCacheStat(name string) *CacheStat {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	stat, ok := registry.caches[name]
+	if !ok {
+		stat = &CacheStat{name: name}
+		registry.caches[name] = stat
+	}
+	stat.refs++
+
+	return stat
+}
+
+// Release unregisters the stat after all the caches sharing it are released,
+// the counters restart from zero if the name is used again.
+func (s *CacheStat) Release() {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if s.refs > 0 {
+		s.refs--
+	}
+	if s.refs == 0 && registry.caches[s.name] == s {
+		delete(registry.caches, s.name)
+	}
+}
+
+// SetLogInterval logs the stats of the caches with requests every interval,
+// the logging is disabled by default, zero or negative disables it again.
+func SetLogInterval(interval time.Duration) {
+	atomic.StoreInt64(&logInterval, int64(interval))
+	if interval > 0 {
+		logOnce.Do(func() {
+			threadingx.GoSafe(logLoop)
+		})
+	}
+}
+
+// Caches returns the snapshots of all the cache stats sorted by name.
+func Caches() []CacheSnapshot {
+	registry.lock.Lock()
+	stats := make([]*CacheStat, 0, len(registry.caches))
+	for _, stat := range registry.caches {
+		stats = append(stats, stat)
+	}
+	registry.lock.Unlock()
+
+	snapshots := make([]CacheSnapshot, 0, len(stats))
+	for _, stat := range stats {
+		snapshots = append(snapshots, stat.Snapshot())
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Name < snapshots[j].Name
+	})
+
+	return snapshots
+}
+
+// Name returns the name of the cache.
+func (s *CacheStat) Name() string {
+	return s.name
+}
+
+func (s *CacheStat) IncrementHit() {
+	atomic.AddUint64(&s.hit, 1)
+}
+
+func (s *CacheStat) IncrementMiss() {
+	atomic.AddUint64(&s.miss, 1)
+}
+
+// IncrementPlaceholder counts the requests hit the not found placeholder.
+func (s *CacheStat) IncrementPlaceholder() {
+	atomic.AddUint64(&s.placeholder, 1)
+}
+
+func (s *CacheStat) IncrementError() {
+	atomic.AddUint64(&s.errors, 1)
+}
+
+// AddQuery counts a query to the data source which takes d.
+func (s *CacheStat) AddQuery(d time.Duration) {
+	atomic.AddUint64(&s.queries, 1)
+	atomic.AddInt64(&s.queryTime, int64(d))
+}
+
+// Snapshot returns the cumulative counters.
+func (s *CacheStat) Snapshot() CacheSnapshot {
+	return CacheSnapshot{
+		Name:        s.name,
+		Hit:         atomic.LoadUint64(&s.hit),
+		Miss:        atomic.LoadUint64(&s.miss),
+		Placeholder: atomic.LoadUint64(&s.placeholder),
+		Errors:      atomic.LoadUint64(&s.errors),
+		Queries:     atomic.LoadUint64(&s.queries),
+		QueryTime:   time.Duration(atomic.LoadInt64(&s.queryTime)),
+	}
+}
+
+// Requests returns the total requests, the placeholder hits are counted as hits.
+func (s CacheSnapshot) Requests() uint64 {
+	return s.Hit + s.Miss + s.Placeholder
+}
+
+// HitRatio returns the ratio of the requests served by the cache.
+func (s CacheSnapshot) HitRatio() float64 {
+	total := s.Requests()
+	if total == 0 {
+		return 0
+	}
+
+	return float64(s.Hit+s.Placeholder) / float64(total)
+}
+
+// AvgQueryTime returns the average time of the queries to the data source.
+func (s CacheSnapshot) AvgQueryTime() time.Duration {
+	if s.Queries == 0 {
+		return 0
+	}
+
+	return s.QueryTime / time.Duration(s.Queries)
+}
+
+// Sub returns the counters between prev and s.
+func (s CacheSnapshot) Sub(prev CacheSnapshot) CacheSnapshot {
+	return CacheSnapshot{
+		Name:        s.Name,
+		Hit:         s.Hit - prev.Hit,
+		Miss:        s.Miss - prev.Miss,
+		Placeholder: s.Placeholder - prev.Placeholder,
+		Errors:      s.Errors - prev.Errors,
+		Queries:     s.Queries - prev.Queries,
+		QueryTime:   s.QueryTime - prev.QueryTime,
+	}
+}
+
+// * 周期性地输出每个缓存在这段时间内的统计
+func logLoop() {
+	for {
+		interval := time.Duration(atomic.LoadInt64(&logInterval))
+		if interval <= 0 {
+			time.Sleep(DefaultLogInterval)
+			continue
+		}
+
+		time.Sleep(interval)
+		if atomic.LoadInt64(&logInterval) > 0 {
+			logStats(interval)
+		}
+	}
+}
+
+func logStats(interval time.Duration) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	for _, stat := range registry.caches {
+		current := stat.Snapshot()
+		delta := current.Sub(stat.last)
+		stat.last = current
+		if delta.Requests() == 0 && delta.Errors == 0 {
+			continue
+		}
+
+		slogx.Default.Info(context.Background(), "cache stat",
+			"name", delta.Name,
+			"interval", interval.String(),
+			"requests", delta.Requests(),
+			"hit_ratio", delta.HitRatio(),
+			"hit", delta.Hit,
+			"miss", delta.Miss,
+			"placeholder", delta.Placeholder,
+			"errors", delta.Errors,
+			"queries", delta.Queries,
+			"avg_query", delta.AvgQueryTime().String())
+	}
+}
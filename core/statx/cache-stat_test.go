@@ -0,0 +1,90 @@
+package statx
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheStat(t *testing.T) {
+	stat := NewCacheStat("test:stat")
+	assert.Same(t, stat, NewCacheStat("test:stat"))
+
+	stat.IncrementHit()
+	stat.IncrementHit()
+	stat.IncrementPlaceholder()
+	stat.IncrementMiss()
+	stat.IncrementError()
+	stat.AddQuery(10 * time.Millisecond)
+	stat.AddQuery(30 * time.Millisecond)
+
+	s := stat.Snapshot()
+	assert.Equal(t, uint64(4), s.Requests())
+	assert.Equal(t, 0.75, s.HitRatio())
+	assert.Equal(t, 20*time.Millisecond, s.AvgQueryTime())
+
+	stat.IncrementMiss()
+	delta := stat.Snapshot().Sub(s)
+	assert.Equal(t, uint64(1), delta.Requests())
+	assert.Equal(t, float64(0), delta.HitRatio())
+	assert.Zero(t, CacheSnapshot{}.HitRatio())
+
+	var found bool
+	for _, snapshot := range Caches() {
+		if snapshot.Name == "test:stat" {
+			found = true
+			assert.Equal(t, uint64(2), snapshot.Miss)
+		}
+	}
+	assert.True(t, found)
+
+	// * 输出日志不会影响累计值
+	logStats(time.Minute)
+	assert.Equal(t, uint64(2), stat.Snapshot().Miss)
+}
+
+func TestCacheStatRelease(t *testing.T) {
+	registered := func(name string) bool {
+		for _, snapshot := range Caches() {
+			if snapshot.Name == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	a := NewCacheStat("test:release")
+	b := NewCacheStat("test:release")
+	a.IncrementHit()
+
+	// * 所有共用的缓存都释放后才注销
+	a.Release()
+	assert.True(t, registered("test:release"))
+	b.Release()
+	assert.False(t, registered("test:release"))
+	assert.Zero(t, NewCacheStat("test:release").Snapshot().Hit)
+}
+
+func TestPrometheusExporter(t *testing.T) {
+	var buf bytes.Buffer
+	err := NewPrometheusExporter(&buf, "app").Export([]CacheSnapshot{
+		{Name: `user"cache`, Hit: 3, Miss: 1, QueryTime: 1500 * time.Millisecond},
+	})
+	assert.NoError(t, err)
+
+	out := buf.String()
+	assert.Contains(t, out, "# TYPE app_cache_hits_total counter\n")
+	assert.Contains(t, out, `app_cache_hits_total{name="user\"cache"} 3`+"\n")
+	assert.Contains(t, out, `app_cache_misses_total{name="user\"cache"} 1`+"\n")
+	assert.Contains(t, out, `app_cache_query_seconds_total{name="user\"cache"} 1.5`+"\n")
+
+	NewCacheStat("test:handler").IncrementHit()
+	w := httptest.NewRecorder()
+	PrometheusHandler("").ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain"))
+	assert.Contains(t, w.Body.String(), `cache_hits_total{name="test:handler"} 1`)
+}
@@ -0,0 +1,99 @@
+package statx
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+type (
+	// An Exporter exports the snapshots of the cache stats, e.g. to a metrics system.
+	Exporter interface {
+		Export(snapshots []CacheSnapshot) error
+	}
+
+	// A PrometheusExporter writes the snapshots in the Prometheus text exposition format.
+	PrometheusExporter struct {
+		w         io.Writer
+		namespace string
+	}
+)
+
+// NewPrometheusExporter returns a PrometheusExporter writing to w,
+// the metrics are prefixed with namespace if it's not empty.
+func NewPrometheusExporter(w io.Writer, namespace string) *PrometheusExporter {
+	return &PrometheusExporter{
+		w:         w,
+		namespace: namespace,
+	}
+}
+
+// Export writes the counters of snapshots, one time series per cache name.
+func (e *PrometheusExporter) Export(snapshots []CacheSnapshot) error {
+	w := bufio.NewWriter(e.w)
+	counters := []struct {
+		name  string
+		help  string
+		value func(s CacheSnapshot) string
+	}{
+		{"cache_hits_total", "Requests served by the cache.", func(s CacheSnapshot) string {
+			return fmt.Sprint(s.Hit)
+		}},
+		{"cache_misses_total", "Requests not found in the cache.", func(s CacheSnapshot) string {
+			return fmt.Sprint(s.Miss)
+		}},
+		{"cache_placeholder_hits_total", "Requests hit the not found placeholder.", func(s CacheSnapshot) string {
+			return fmt.Sprint(s.Placeholder)
+		}},
+		{"cache_errors_total", "Requests failed by the cache.", func(s CacheSnapshot) string {
+			return fmt.Sprint(s.Errors)
+		}},
+		{"cache_queries_total", "Queries to the data source on cache misses.", func(s CacheSnapshot) string {
+			return fmt.Sprint(s.Queries)
+		}},
+		{"cache_query_seconds_total", "Time spent on the queries to the data source.", func(s CacheSnapshot) string {
+			return fmt.Sprint(s.QueryTime.Seconds())
+		}},
+	}
+
+	for _, counter := range counters {
+		name := e.metric(counter.name)
+		fmt.Fprintf(w, "# HELP %s %s\n", name, counter.help)
+		fmt.Fprintf(w, "# TYPE %s counter\n", name)
+		for _, s := range snapshots {
+			fmt.Fprintf(w, "%s{name=\"%s\"} %s\n", name, escapeLabel(s.Name), counter.value(s))
+		}
+	}
+
+	return w.Flush()
+}
+
+// WritePrometheus writes all the cache stats to w in the Prometheus text format.
+func WritePrometheus(w io.Writer, namespace string) error {
+	return NewPrometheusExporter(w, namespace).Export(Caches())
+}
+
+// PrometheusHandler returns a http.Handler serving all the cache stats for Prometheus to scrape.
+func PrometheusHandler(namespace string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", prometheusContentType)
+		_ = WritePrometheus(w, namespace)
+	})
+}
+
+func (e *PrometheusExporter) metric(name string) string {
+	if len(e.namespace) == 0 {
+		return name
+	}
+
+	return e.namespace + "_" + name
+}
+
+// * 转义 label 中的反斜杠、双引号和换行
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
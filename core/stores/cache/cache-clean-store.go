@@ -0,0 +1,425 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/uc1024/f90/core/slogx"
+	"github.com/uc1024/f90/core/stringx"
+)
+
+const (
+	defaultCleanStream = "cache:clean-tasks"
+	defaultCleanGroup  = "cleaners"
+	// * 超过这个时间没有重新保存的任务认为所在进程已经退出, 应长于 Cleaner 最长的重试间隔
+	defaultCleanClaimIdle = 2 * time.Hour
+	// * 文件中至少有这么多条记录, 且过半是已删除的任务时压缩
+	fileCompactMinRecords = 1024
+	fileCompactRatio      = 0.5
+)
+
+// * 写入任务并立即加入当前消费者的待确认列表, 其他进程只能在任务闲置过久后认领
+// KEYS[1] stream
+// ARGV[1] 消费组, ARGV[2] 消费者, ARGV[3] 任务
+var saveCleanTaskScript = redis.NewScript(`
+redis.pcall("XGROUP", "CREATE", KEYS[1], ARGV[1], "$", "MKSTREAM")
+local id = redis.call("XADD", KEYS[1], "*", "task", ARGV[3])
+redis.call("XCLAIM", KEYS[1], ARGV[1], ARGV[2], 0, id, "FORCE", "JUSTID")
+return id`)
+
+type (
+	// A CleanTask is a pending deletion of the cached keys.
+	CleanTask struct {
+		ID        string    `json:"-"`    // * 由 CleanStore 分配
+		Node      string    `json:"node"` // * 执行删除的节点, 多个节点共用一个存储时区分
+		Keys      []string  `json:"keys"`
+		Prefixes  []string  `json:"prefixes,omitempty"` // * 按前缀删除的 key
+		Attempts  int       `json:"attempts"`           // * 已经失败的次数
+		CreatedAt time.Time `json:"created_at"`
+	}
+
+	// A CleanStore persists the pending clean tasks so they survive restarts.
+	CleanStore interface {
+		// Save saves task and assigns its ID.
+		Save(task *CleanTask) error
+		// Remove removes the task with id.
+		Remove(id string) error
+		// Load returns all the pending tasks.
+		Load() ([]CleanTask, error)
+	}
+
+	// A RedisStreamCleanStore saves the clean tasks in a redis stream,
+	// it should be a different redis from the cached one.
+	// The tasks are owned by the process which saved them through a consumer group,
+	// Load only claims the tasks left idle by other processes, e.g. the exited ones.
+	RedisStreamCleanStore struct {
+		rds       redis.UniversalClient
+		stream    string
+		group     string
+		consumer  string // * 每个进程唯一
+		claimIdle time.Duration
+	}
+
+	// RedisStreamCleanStoreOption customizes a RedisStreamCleanStore.
+	RedisStreamCleanStoreOption func(s *RedisStreamCleanStore)
+
+	// A FileCleanStore appends the clean tasks to a local file,
+	// the file is compacted when the tasks are loaded or most of the records are removed tasks.
+	FileCleanStore struct {
+		path       string
+		lock       sync.Mutex
+		file       *os.File
+		nextID     uint64
+		counted    bool
+		records    int // * 文件中的记录数
+		live       int // * 未完成的任务数
+		compactMin int
+	}
+
+	// * 追加到文件的一条记录
+	fileCleanRecord struct {
+		Op   string     `json:"op"` // * add / del
+		ID   string     `json:"id"`
+		Task *CleanTask `json:"task,omitempty"`
+	}
+)
+
+// WithStreamCleanGroup sets the consumer group of the processes sharing the stream, cleaners by default.
+func WithStreamCleanGroup(group string) RedisStreamCleanStoreOption {
+	return func(s *RedisStreamCleanStore) {
+		if len(group) > 0 {
+			s.group = group
+		}
+	}
+}
+
+// WithStreamCleanClaimIdle sets how long a task is left idle before another process claims it,
+// it should be longer than the longest backoff of the Cleaner, 2 hours by default.
+func WithStreamCleanClaimIdle(idle time.Duration) RedisStreamCleanStoreOption {
+	return func(s *RedisStreamCleanStore) {
+		if idle > 0 {
+			s.claimIdle = idle
+		}
+	}
+}
+
+// NewRedisStreamCleanStore returns a RedisStreamCleanStore with stream, cache:clean-tasks by default.
+func NewRedisStreamCleanStore(rds redis.UniversalClient, stream string,
+	opts ...RedisStreamCleanStoreOption) *RedisStreamCleanStore {
+	if len(stream) == 0 {
+		stream = defaultCleanStream
+	}
+
+	s := &RedisStreamCleanStore{
+		rds:       rds,
+		stream:    stream,
+		group:     defaultCleanGroup,
+		consumer:  cleanConsumer(),
+		claimIdle: defaultCleanClaimIdle,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+func (s *RedisStreamCleanStore) Save(task *CleanTask) error {
+	data, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+
+	id, err := saveCleanTaskScript.Run(context.Background(), s.rds, []string{s.stream},
+		s.group, s.consumer, data).Text()
+	if err != nil {
+		return err
+	}
+
+	task.ID = id
+	return nil
+}
+
+func (s *RedisStreamCleanStore) Remove(id string) error {
+	ctx := context.Background()
+	_, err := s.rds.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAck(ctx, s.stream, s.group, id)
+		pipe.XDel(ctx, s.stream, id)
+		return nil
+	})
+	return err
+}
+
+// Load claims the tasks idle longer than the claim idle time, the tasks of the live processes are skipped.
+func (s *RedisStreamCleanStore) Load() ([]CleanTask, error) {
+	ctx := context.Background()
+	err := s.rds.XGroupCreateMkStream(ctx, s.stream, s.group, "$").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil, err
+	}
+
+	var messages []redis.XMessage
+	start := "0-0"
+	for {
+		claimed, next, err := s.rds.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   s.stream,
+			Group:    s.group,
+			Consumer: s.consumer,
+			MinIdle:  s.claimIdle,
+			Start:    start,
+			Count:    scanCount,
+		}).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		messages = append(messages, claimed...)
+		if start = next; start == "0-0" {
+			break
+		}
+	}
+
+	tasks := make([]CleanTask, 0, len(messages))
+	for _, msg := range messages {
+		data, _ := msg.Values["task"].(string)
+		var task CleanTask
+		if err := json.Unmarshal([]byte(data), &task); err != nil {
+			// * 无法解析的记录直接丢弃
+			s.Remove(msg.ID)
+			continue
+		}
+		task.ID = msg.ID
+		tasks = append(tasks, task)
+	}
+
+	return tasks, nil
+}
+
+// NewFileCleanStore returns a FileCleanStore which appends to the file at path.
+func NewFileCleanStore(path string) *FileCleanStore {
+	return &FileCleanStore{
+		path:       path,
+		compactMin: fileCompactMinRecords,
+	}
+}
+
+func (s *FileCleanStore) Save(task *CleanTask) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	id := strconv.FormatInt(time.Now().UnixNano(), 36) + "-" +
+		strconv.FormatUint(atomic.AddUint64(&s.nextID, 1), 36)
+	if err := s.append(fileCleanRecord{Op: "add", ID: id, Task: task}); err != nil {
+		return err
+	}
+
+	task.ID = id
+	s.live++
+	return nil
+}
+
+func (s *FileCleanStore) Remove(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.append(fileCleanRecord{Op: "del", ID: id}); err != nil {
+		return err
+	}
+
+	if s.live > 0 {
+		s.live--
+	}
+	s.maybeCompact()
+	return nil
+}
+
+// Load replays the file and rewrites it with the pending tasks only.
+func (s *FileCleanStore) Load() ([]CleanTask, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	result, _, err := s.pending()
+	if err != nil {
+		return nil, err
+	}
+
+	return result, s.compact(result)
+}
+
+// Close closes the file.
+func (s *FileCleanStore) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+func (s *FileCleanStore) append(record fileCleanRecord) error {
+	if !s.counted {
+		tasks, records, err := s.pending()
+		if err != nil {
+			return err
+		}
+		s.counted = true
+		s.records = records
+		s.live = len(tasks)
+	}
+
+	if s.file == nil {
+		file, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		s.file = file
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err = s.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+
+	s.records++
+	return s.file.Sync()
+}
+
+// * 已删除的记录过半时压缩, 失败时下次再试
+func (s *FileCleanStore) maybeCompact() {
+	dead := s.records - s.live
+	if s.records < s.compactMin || float64(dead) < float64(s.records)*fileCompactRatio {
+		return
+	}
+
+	tasks, _, err := s.pending()
+	if err == nil {
+		err = s.compact(tasks)
+	}
+	if err != nil {
+		slogx.Default.Error(nil, fmt.Sprintf("failed to compact clean tasks: %s, error: %v", s.path, err))
+	}
+}
+
+// * 返回未完成的任务和文件中的记录数
+func (s *FileCleanStore) pending() ([]CleanTask, int, error) {
+	tasks, order, records, err := s.replay()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	result := make([]CleanTask, 0, len(tasks))
+	for _, id := range order {
+		if task, ok := tasks[id]; ok {
+			result = append(result, task)
+		}
+	}
+
+	return result, records, nil
+}
+
+func (s *FileCleanStore) replay() (map[string]CleanTask, []string, int, error) {
+	tasks := make(map[string]CleanTask)
+	var order []string
+	var records int
+
+	file, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return tasks, order, 0, nil
+	}
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var record fileCleanRecord
+		// * 进程退出时可能写入了不完整的最后一行
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		records++
+
+		switch record.Op {
+		case "add":
+			if record.Task == nil {
+				continue
+			}
+			task := *record.Task
+			task.ID = record.ID
+			tasks[record.ID] = task
+			order = append(order, record.ID)
+		case "del":
+			delete(tasks, record.ID)
+		}
+	}
+
+	return tasks, order, records, scanner.Err()
+}
+
+// * 只保留未完成的任务, 先写临时文件再替换
+func (s *FileCleanStore) compact(tasks []CleanTask) error {
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+
+	tmp := s.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(file)
+	for i := range tasks {
+		data, err := json.Marshal(fileCleanRecord{Op: "add", ID: tasks[i].ID, Task: &tasks[i]})
+		if err != nil {
+			file.Close()
+			return err
+		}
+		w.Write(append(data, '\n'))
+	}
+	if err = w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("compact clean tasks: %w", err)
+	}
+
+	s.counted = true
+	s.records = len(tasks)
+	s.live = len(tasks)
+	return nil
+}
+
+// * 消费者名称, 主机名加随机串, 重启后是新的消费者
+func cleanConsumer() string {
+	host, _ := os.Hostname()
+	return host + "-" + stringx.Rand()
+}
@@ -0,0 +1,309 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileCleanStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clean.log")
+	store := NewFileCleanStore(path)
+
+	first := CleanTask{Node: "a", Keys: []string{"k1"}}
+	second := CleanTask{Node: "a", Keys: []string{"k2", "k3"}, Attempts: 2}
+	assert.NoError(t, store.Save(&first))
+	assert.NoError(t, store.Save(&second))
+	assert.NotEqual(t, first.ID, second.ID)
+	assert.NoError(t, store.Remove(first.ID))
+	assert.NoError(t, store.Close())
+
+	// * 重新打开后只剩未完成的任务
+	store = NewFileCleanStore(path)
+	tasks, err := store.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(tasks))
+	assert.Equal(t, second.ID, tasks[0].ID)
+	assert.Equal(t, []string{"k2", "k3"}, tasks[0].Keys)
+	assert.Equal(t, 2, tasks[0].Attempts)
+
+	assert.NoError(t, store.Remove(second.ID))
+	tasks, err = store.Load()
+	assert.NoError(t, err)
+	assert.Empty(t, tasks)
+	assert.NoError(t, store.Close())
+}
+
+func TestRedisStreamCleanStore(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	rds := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	store := NewRedisStreamCleanStore(rds, "")
+	task := CleanTask{Node: "a", Keys: []string{"k1"}}
+	assert.NoError(t, store.Save(&task))
+	assert.NotEmpty(t, task.ID)
+
+	// * 其他进程不会重复执行仍在重试的任务
+	other := NewRedisStreamCleanStore(rds, "")
+	tasks, err := other.Load()
+	assert.NoError(t, err)
+	assert.Empty(t, tasks)
+
+	// * 闲置过久的任务被其他进程认领
+	time.Sleep(20 * time.Millisecond)
+	other = NewRedisStreamCleanStore(rds, "", WithStreamCleanClaimIdle(10*time.Millisecond))
+	tasks, err = other.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(tasks))
+	assert.Equal(t, task.ID, tasks[0].ID)
+	assert.Equal(t, []string{"k1"}, tasks[0].Keys)
+
+	tasks, err = NewRedisStreamCleanStore(rds, "", WithStreamCleanClaimIdle(10*time.Millisecond)).Load()
+	assert.NoError(t, err)
+	assert.Empty(t, tasks)
+
+	assert.NoError(t, other.Remove(task.ID))
+	time.Sleep(20 * time.Millisecond)
+	tasks, err = other.Load()
+	assert.NoError(t, err)
+	assert.Empty(t, tasks)
+}
+
+func TestFileCleanStoreCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clean.log")
+	store := NewFileCleanStore(path)
+	defer store.Close()
+	store.compactMin = 5
+
+	tasks := make([]CleanTask, 3)
+	for i := range tasks {
+		tasks[i] = CleanTask{Node: "a", Keys: []string{"k" + strconv.Itoa(i)}}
+		assert.NoError(t, store.Save(&tasks[i]))
+	}
+	assert.NoError(t, store.Remove(tasks[0].ID))
+	assert.NoError(t, store.Remove(tasks[1].ID))
+
+	// * 删除的记录过半后文件只保留未完成的任务
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(data), "\n"))
+
+	loaded, err := store.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(loaded))
+	assert.Equal(t, tasks[2].ID, loaded[0].ID)
+}
+
+func TestCleanerRetry(t *testing.T) {
+	store := NewFileCleanStore(filepath.Join(t.TempDir(), "clean.log"))
+	defer store.Close()
+
+	var calls int32
+	done := make(chan struct{})
+	c, err := NewCleaner(store, "a", func(ctx context.Context, keys ...string) error {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			return errors.New("down")
+		}
+		close(done)
+		return nil
+	}, WithCleanerBackoff(10*time.Millisecond, 10*time.Millisecond, 10*time.Millisecond))
+	assert.NoError(t, err)
+	defer c.Stop()
+
+	c.Add("k1")
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("clean task not retried")
+	}
+
+	assert.Eventually(t, func() bool {
+		tasks, err := store.Load()
+		return err == nil && len(tasks) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestCleanerDeadLetter(t *testing.T) {
+	store := NewFileCleanStore(filepath.Join(t.TempDir(), "clean.log"))
+	defer store.Close()
+
+	var (
+		lock sync.Mutex
+		dead []CleanTask
+	)
+	c, err := NewCleaner(store, "a", func(ctx context.Context, keys ...string) error {
+		return errors.New("down")
+	}, WithCleanerBackoff(10*time.Millisecond, 10*time.Millisecond),
+		WithCleanerDeadLetter(func(task CleanTask, err error) {
+			lock.Lock()
+			dead = append(dead, task)
+			lock.Unlock()
+		}))
+	assert.NoError(t, err)
+	defer c.Stop()
+
+	c.Add("k1", "k2")
+	assert.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(dead) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	lock.Lock()
+	assert.Equal(t, []string{"k1", "k2"}, dead[0].Keys)
+	assert.Equal(t, 2, dead[0].Attempts)
+	lock.Unlock()
+
+	assert.Eventually(t, func() bool {
+		tasks, err := store.Load()
+		return err == nil && len(tasks) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestCleanerResume(t *testing.T) {
+	store := NewFileCleanStore(filepath.Join(t.TempDir(), "clean.log"))
+	defer store.Close()
+
+	pending := CleanTask{Node: "a", Keys: []string{"k1"}, Attempts: 1}
+	other := CleanTask{Node: "b", Keys: []string{"k2"}}
+	assert.NoError(t, store.Save(&pending))
+	assert.NoError(t, store.Save(&other))
+
+	deleted := make(chan []string, 2)
+	c, err := NewCleaner(store, "a", func(ctx context.Context, keys ...string) error {
+		deleted <- keys
+		return nil
+	}, WithCleanerBackoff(10*time.Millisecond))
+	assert.NoError(t, err)
+	defer c.Stop()
+
+	select {
+	case keys := <-deleted:
+		assert.Equal(t, []string{"k1"}, keys)
+	case <-time.After(5 * time.Second):
+		t.Fatal("pending task not resumed")
+	}
+
+	// * 其他节点的任务保持不变
+	assert.Eventually(t, func() bool {
+		tasks, err := store.Load()
+		return err == nil && len(tasks) == 1 && tasks[0].Node == "b"
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestNodeCleanStore(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	store := NewFileCleanStore(filepath.Join(t.TempDir(), "clean.log"))
+	defer store.Close()
+
+	c := newNode(redis.NewClient(&redis.Options{Addr: mr.Addr()}), errors.New("not found"),
+		WithCleanStore(store, WithCleanerBackoff(10*time.Millisecond)))
+	assert.NotNil(t, c.cleaner)
+	defer c.Close()
+
+	assert.NoError(t, mr.Set("k1", "v"))
+	c.asyncRetryDelCache("k1")
+	assert.Eventually(t, func() bool {
+		return !mr.Exists("k1")
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestNodeDelPrefixCleanStore(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	store := NewFileCleanStore(filepath.Join(t.TempDir(), "clean.log"))
+	defer store.Close()
+
+	c := newNode(redis.NewClient(&redis.Options{Addr: mr.Addr()}), errors.New("not found"),
+		WithCleanStore(store, WithCleanerBackoff(100*time.Millisecond, time.Second)))
+	assert.NotNil(t, c.cleaner)
+	defer c.Close()
+
+	assert.NoError(t, mr.Set("list:1", "v"))
+	mr.SetError("down")
+	assert.Error(t, c.DelPrefixCtx(context.Background(), "list:"))
+
+	// * 失败的前缀删除保存在 CleanStore 中
+	tasks, err := store.Load()
+	assert.NoError(t, err)
+	assert.Len(t, tasks, 1)
+	assert.Equal(t, []string{"list:"}, tasks[0].Prefixes)
+
+	mr.SetError("")
+	assert.Eventually(t, func() bool {
+		tasks, err := store.Load()
+		return !mr.Exists("list:1") && err == nil && len(tasks) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestCleanerReclaim(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	rds := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	deleted := make(chan []string, 1)
+	c, err := NewCleaner(NewRedisStreamCleanStore(rds, "", WithStreamCleanClaimIdle(20*time.Millisecond)), "a",
+		func(ctx context.Context, keys ...string) error {
+			deleted <- keys
+			return nil
+		}, WithCleanerBackoff(10*time.Millisecond), WithCleanerReloadInterval(10*time.Millisecond))
+	assert.NoError(t, err)
+	defer c.Stop()
+
+	// * 已退出进程留下的任务在运行期间也会被认领
+	task := CleanTask{Node: "a", Keys: []string{"k1"}}
+	assert.NoError(t, NewRedisStreamCleanStore(rds, "").Save(&task))
+	select {
+	case keys := <-deleted:
+		assert.Equal(t, []string{"k1"}, keys)
+	case <-time.After(5 * time.Second):
+		t.Fatal("orphaned task is not reclaimed")
+	}
+}
+
+func TestNodeSharedCleaner(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	store := NewFileCleanStore(filepath.Join(t.TempDir(), "clean.log"))
+	defer store.Close()
+
+	// * 同一个存储和 redis 的缓存共用一个 Cleaner, 任务只加载一次
+	rds := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	a := newNode(rds, errors.New("not found"), WithCleanStore(store))
+	b := newNode(rds, errors.New("not found"), WithCleanStore(store))
+	assert.NotNil(t, a.cleaner)
+	assert.Same(t, a.cleaner, b.cleaner)
+
+	assert.NoError(t, a.Close())
+	assert.NoError(t, a.Close())
+	select {
+	case <-b.cleaner.done:
+		t.Fatal("cleaner is stopped while it's still used")
+	default:
+	}
+
+	assert.NoError(t, b.Close())
+	<-b.cleaner.done
+	assert.Nil(t, sharedCleaner(store, a.cleaner.node))
+}
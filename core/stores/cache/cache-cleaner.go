@@ -1,9 +1,12 @@
 package cache
 
 import (
+	"context"
+	"errors"
 	"fmt"
 
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/uc1024/f90/core/collection"
@@ -15,20 +18,43 @@ import (
 const cleanWorkers = 5
 const numSlots = 300
 
+// * Cleaner 重新加载存储的间隔, 认领已退出进程留下的任务
+const defaultCleanReload = time.Minute
+
+var errNoPrefixDel = errors.New("cache: the cleaner can't delete keys by prefix")
+
 var (
 	// * time wheel
 	timingWheel *collection.TimingWheel
 	// * task runner  number of workers
 	taskRunner = threadingx.NewTaskRunner(cleanWorkers)
+	// * 默认的重试间隔
+	defaultCleanBackoff = []time.Duration{
+		time.Second, time.Second * 5, time.Minute, time.Minute * 5, time.Hour,
+	}
+	// * 内存中清理任务的重试间隔和重试耗尽时的回调
+	cleanSettings = struct {
+		sync.RWMutex
+		backoff    []time.Duration
+		deadLetter DeadLetterHandler
+	}{backoff: defaultCleanBackoff}
+	// * 缓存节点共用的 Cleaner, 同一个存储和节点只有一个
+	sharedCleaners = struct {
+		sync.Mutex
+		cleaners map[cleanerKey]*Cleaner
+	}{cleaners: make(map[cleanerKey]*Cleaner)}
 )
 
+// DeadLetterHandler is called when a clean task failed after all the retries.
+type DeadLetterHandler func(task CleanTask, err error)
+
 /*
 缓存清理任务
 */
 type cacheCleaner struct {
-	delay time.Duration
-	task  func() error
-	keys  []string
+	attempt int // * 已经重试的次数
+	task    func() error
+	keys    []string
 }
 
 func init() {
@@ -37,12 +63,32 @@ func init() {
 	slogx.Default.MustSucc(nil, err)
 }
 
+// SetCleanBackoff sets the delays between the retries of the clean tasks added by AddCleanTask.
+func SetCleanBackoff(delays ...time.Duration) {
+	if len(delays) == 0 {
+		delays = defaultCleanBackoff
+	}
+
+	cleanSettings.Lock()
+	cleanSettings.backoff = delays
+	cleanSettings.Unlock()
+}
+
+// SetCleanDeadLetter sets the handler of the clean tasks added by AddCleanTask which failed after all the retries.
+func SetCleanDeadLetter(handler DeadLetterHandler) {
+	cleanSettings.Lock()
+	cleanSettings.deadLetter = handler
+	cleanSettings.Unlock()
+}
+
+// AddCleanTask retries task in background until it succeeds or the retries are exhausted,
+// the task is kept in memory and lost if the process exits, see Cleaner for the durable one.
 func AddCleanTask(task func() error, keys ...string) {
+	delay, _ := nextDelay(0)
 	timingWheel.SetTimer(stringx.Randn(8, ""), cacheCleaner{
-		delay: time.Second,
-		task:  task,
-		keys:  keys,
-	}, time.Second)
+		task: task,
+		keys: keys,
+	}, delay)
 }
 
 /*
@@ -57,34 +103,374 @@ func clean(key, value any) {
 			return
 		}
 
-		next, ok := nextDelay(dt.delay)
+		dt.attempt++
+		next, ok := nextDelay(dt.attempt)
 		if ok {
 			// * postpone task reschedule
-			dt.delay = next
 			timingWheel.SetTimer(key, dt, next)
 		} else {
 			// * log error and give up
 			msg := fmt.Sprintf("retried but failed to clear cache with keys: %q, error: %v",
 				strings.Join(dt.keys, ","), err)
 			slogx.Default.Error(nil, msg)
+
+			cleanSettings.RLock()
+			deadLetter := cleanSettings.deadLetter
+			cleanSettings.RUnlock()
+			if deadLetter != nil {
+				threadingx.RunSafe(func() {
+					deadLetter(CleanTask{Keys: dt.keys, Attempts: dt.attempt}, err)
+				})
+			}
 		}
 	})
 }
 
 /*
-add delay to next retry
+delay before the attempt, false if the retries are exhausted
 */
-func nextDelay(delay time.Duration) (time.Duration, bool) {
-	switch delay {
-	case time.Second:
-		return time.Second * 5, true
-	case time.Second * 5:
-		return time.Minute, true
-	case time.Minute:
-		return time.Minute * 5, true
-	case time.Minute * 5:
-		return time.Hour, true
-	default:
+func nextDelay(attempt int) (time.Duration, bool) {
+	cleanSettings.RLock()
+	defer cleanSettings.RUnlock()
+
+	return backoffDelay(cleanSettings.backoff, attempt)
+}
+
+func backoffDelay(backoff []time.Duration, attempt int) (time.Duration, bool) {
+	if attempt < 0 || attempt >= len(backoff) {
 		return 0, false
 	}
+
+	return backoff[attempt], true
+}
+
+type (
+	// CleanerOption customizes a Cleaner.
+	CleanerOption func(c *Cleaner)
+
+	// A Cleaner retries the failed deletions of a redis node like AddCleanTask,
+	// the pending tasks are saved in a CleanStore and resumed when the Cleaner is created again.
+	// The store is reloaded periodically to pick up the tasks left by the exited processes.
+	Cleaner struct {
+		store      CleanStore
+		node       string
+		del        func(ctx context.Context, keys ...string) error
+		delPrefix  func(ctx context.Context, prefix string) error
+		backoff    []time.Duration
+		deadLetter DeadLetterHandler
+		reload     time.Duration
+		wheel      *collection.TimingWheel
+		lock       sync.Mutex
+		scheduled  map[string]struct{} // * 等待或正在执行的任务, 重新加载时跳过
+		done       chan struct{}
+		stopOnce   sync.Once
+		refs       int // * 共用的缓存节点数, 由 sharedCleaners 保护
+	}
+
+	// * 共用 Cleaner 的 key
+	cleanerKey struct {
+		store CleanStore
+		node  string
+	}
+)
+
+// WithCleanerBackoff sets the delays between the retries, the task is dropped after the last one.
+func WithCleanerBackoff(delays ...time.Duration) CleanerOption {
+	return func(c *Cleaner) {
+		if len(delays) > 0 {
+			c.backoff = delays
+		}
+	}
+}
+
+// WithCleanerDeadLetter sets the handler of the tasks which failed after all the retries.
+func WithCleanerDeadLetter(handler DeadLetterHandler) CleanerOption {
+	return func(c *Cleaner) {
+		c.deadLetter = handler
+	}
+}
+
+// WithCleanerPrefixDel sets the function deleting the keys with a prefix, it's required by AddPrefix.
+func WithCleanerPrefixDel(delPrefix func(ctx context.Context, prefix string) error) CleanerOption {
+	return func(c *Cleaner) {
+		c.delPrefix = delPrefix
+	}
+}
+
+// WithCleanerReloadInterval sets the interval of reloading the store, one minute by default.
+func WithCleanerReloadInterval(interval time.Duration) CleanerOption {
+	return func(c *Cleaner) {
+		if interval > 0 {
+			c.reload = interval
+		}
+	}
+}
+
+// NewCleaner returns a Cleaner which deletes the keys of node with del,
+// the pending tasks of node in store are scheduled again. Stop should be called when it's not used.
+func NewCleaner(store CleanStore, node string, del func(ctx context.Context, keys ...string) error,
+	opts ...CleanerOption) (*Cleaner, error) {
+	c, err := newCleaner(store, node, del, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = c.load(); err != nil {
+		c.wheel.Stop()
+		return nil, err
+	}
+	threadingx.GoSafe(c.reloadLoop)
+
+	return c, nil
+}
+
+// * 只创建, 不加载任务
+func newCleaner(store CleanStore, node string, del func(ctx context.Context, keys ...string) error,
+	opts ...CleanerOption) (*Cleaner, error) {
+	c := &Cleaner{
+		store:     store,
+		node:      node,
+		del:       del,
+		backoff:   defaultCleanBackoff,
+		reload:    defaultCleanReload,
+		scheduled: make(map[string]struct{}),
+		done:      make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	// * 重试间隔小于一秒时使用更小的刻度
+	interval := time.Second
+	if c.backoff[0] < interval {
+		interval = c.backoff[0]
+	}
+	wheel, err := collection.NewTimingWheel(interval, numSlots, c.execute)
+	if err != nil {
+		return nil, err
+	}
+	c.wheel = wheel
+
+	return c, nil
+}
+
+// * 同一个存储和节点的缓存共用一个 Cleaner, 避免重复加载和执行任务, 选项以第一次创建时为准
+func acquireCleaner(store CleanStore, node string, del func(ctx context.Context, keys ...string) error,
+	opts ...CleanerOption) (*Cleaner, error) {
+	sharedCleaners.Lock()
+	key := cleanerKey{store: store, node: node}
+	c, ok := sharedCleaners.cleaners[key]
+	if ok {
+		c.refs++
+		sharedCleaners.Unlock()
+		return c, nil
+	}
+
+	c, err := newCleaner(store, node, del, opts...)
+	if err != nil {
+		sharedCleaners.Unlock()
+		return nil, err
+	}
+	c.refs++
+	sharedCleaners.cleaners[key] = c
+	sharedCleaners.Unlock()
+
+	// * 加载时需要查找其他节点的 Cleaner, 不能持有锁, 失败时等下次重新加载
+	if err = c.load(); err != nil {
+		slogx.Default.Error(nil, fmt.Sprintf("failed to load clean tasks of %s, error: %v", node, err))
+	}
+	threadingx.GoSafe(c.reloadLoop)
+
+	return c, nil
+}
+
+// * 所有缓存节点都释放后停止
+func releaseCleaner(c *Cleaner) {
+	sharedCleaners.Lock()
+	defer sharedCleaners.Unlock()
+
+	if c.refs--; c.refs > 0 {
+		return
+	}
+
+	key := cleanerKey{store: c.store, node: c.node}
+	if sharedCleaners.cleaners[key] == c {
+		delete(sharedCleaners.cleaners, key)
+	}
+	c.Stop()
+}
+
+func sharedCleaner(store CleanStore, node string) *Cleaner {
+	sharedCleaners.Lock()
+	defer sharedCleaners.Unlock()
+
+	return sharedCleaners.cleaners[cleanerKey{store: store, node: node}]
+}
+
+// Add saves a task deleting keys and retries it in background.
+func (c *Cleaner) Add(keys ...string) {
+	c.add(CleanTask{
+		Node:      c.node,
+		Keys:      keys,
+		CreatedAt: time.Now(),
+	})
+}
+
+// AddPrefix saves a task deleting the keys with prefix and retries it in background.
+func (c *Cleaner) AddPrefix(prefix string) {
+	c.add(CleanTask{
+		Node:      c.node,
+		Prefixes:  []string{prefix},
+		CreatedAt: time.Now(),
+	})
+}
+
+func (c *Cleaner) add(task CleanTask) {
+	if err := c.store.Save(&task); err != nil {
+		// * 保存失败时仍然在内存中重试
+		slogx.Default.Error(nil, fmt.Sprintf("failed to save clean task with keys: %q, error: %v",
+			strings.Join(task.targets(), ","), err))
+		task.ID = stringx.Randn(8, "")
+	}
+
+	c.schedule(task, c.backoff[0])
+}
+
+// Stop stops retrying and reloading, the pending tasks are kept in the store.
+func (c *Cleaner) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.done)
+		c.wheel.Stop()
+	})
+}
+
+// * 加载存储中的任务, 其他节点的任务交给本进程中对应的 Cleaner
+func (c *Cleaner) load() error {
+	tasks, err := c.store.Load()
+	if err != nil {
+		return err
+	}
+
+	for _, task := range tasks {
+		if task.Node == c.node {
+			c.schedule(task, c.backoff[0])
+		} else if other := sharedCleaner(c.store, task.Node); other != nil {
+			other.schedule(task, other.backoff[0])
+		}
+	}
+
+	return nil
+}
+
+func (c *Cleaner) reloadLoop() {
+	ticker := time.NewTicker(c.reload)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+
+		if err := c.load(); err != nil {
+			slogx.Default.Error(nil, fmt.Sprintf("failed to reload clean tasks of %s, error: %v", c.node, err))
+		}
+	}
+}
+
+// * 已经在等待或执行的任务不再重复调度
+func (c *Cleaner) schedule(task CleanTask, delay time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.scheduled[task.ID]; ok {
+		return
+	}
+	c.scheduled[task.ID] = struct{}{}
+	c.wheel.SetTimer(task.ID, task, delay)
+}
+
+func (c *Cleaner) unschedule(id string) {
+	c.lock.Lock()
+	delete(c.scheduled, id)
+	c.lock.Unlock()
+}
+
+func (c *Cleaner) execute(key, value any) {
+	task := value.(CleanTask)
+	taskRunner.Schedule(func() {
+		c.run(task)
+	})
+}
+
+func (c *Cleaner) run(task CleanTask) {
+	err := c.clean(context.Background(), task)
+	if err == nil {
+		c.remove(task.ID)
+		c.unschedule(task.ID)
+		return
+	}
+
+	task.Attempts++
+	next, ok := backoffDelay(c.backoff, task.Attempts)
+	if !ok {
+		msg := fmt.Sprintf("retried but failed to clear cache with keys: %q, error: %v",
+			strings.Join(task.targets(), ","), err)
+		slogx.Default.Error(nil, msg)
+		if c.deadLetter != nil {
+			threadingx.RunSafe(func() {
+				c.deadLetter(task, err)
+			})
+		}
+		c.remove(task.ID)
+		c.unschedule(task.ID)
+		return
+	}
+
+	// * 保存新的重试次数, 失败时沿用旧的记录
+	old := task.ID
+	if err := c.store.Save(&task); err == nil {
+		c.remove(old)
+	} else {
+		task.ID = old
+	}
+	c.unschedule(old)
+	c.schedule(task, next)
+}
+
+func (c *Cleaner) clean(ctx context.Context, task CleanTask) error {
+	if len(task.Keys) > 0 {
+		if err := c.del(ctx, task.Keys...); err != nil {
+			return err
+		}
+	}
+
+	for _, prefix := range task.Prefixes {
+		if c.delPrefix == nil {
+			return errNoPrefixDel
+		}
+		if err := c.delPrefix(ctx, prefix); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Cleaner) remove(id string) {
+	if err := c.store.Remove(id); err != nil {
+		slogx.Default.Error(nil, fmt.Sprintf("failed to remove clean task: %s, error: %v", id, err))
+	}
+}
+
+// * 用于日志, 前缀以 * 结尾
+func (t CleanTask) targets() []string {
+	targets := make([]string, 0, len(t.Keys)+len(t.Prefixes))
+	targets = append(targets, t.Keys...)
+	for _, prefix := range t.Prefixes {
+		targets = append(targets, prefix+"*")
+	}
+
+	return targets
 }
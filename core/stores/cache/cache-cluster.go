@@ -0,0 +1,345 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/uc1024/f90/core/errorx"
+	"github.com/uc1024/f90/core/hashx"
+	"github.com/uc1024/f90/core/stores/redisx"
+)
+
+var (
+	// ErrNoCacheNodes means the cluster is created without any node.
+	ErrNoCacheNodes = errors.New("no cache nodes")
+	// ErrDuplicateCacheNode means more than one node of the cluster have the same addr/db.
+	ErrDuplicateCacheNode = errors.New("duplicate cache node")
+)
+
+type (
+	// A NodeConf is a redis node of the cache cluster.
+	NodeConf struct {
+		redisx.Config
+		Weight int // * 权重, 决定虚拟节点的数量, 默认为 1
+	}
+
+	// ClusterConf is the nodes of the cache cluster.
+	ClusterConf []NodeConf
+
+	// A Cluster distributes the keys to the redis nodes by consistent hashing.
+	Cluster struct {
+		dispatcher  *hashx.ConsistentHash
+		nodes       map[string]*clusterNode
+		errNotFound error
+	}
+
+	// NodeStats is the request stats of a node of the cluster.
+	NodeStats struct {
+		Requests uint64 // * 请求次数
+		Errors   uint64 // * 失败次数, 不包括缓存不存在
+	}
+
+	clusterNode struct {
+		Cache
+		rds      redis.UniversalClient // * 由集群创建, 关闭时一起关闭
+		requests uint64
+		errors   uint64
+	}
+)
+
+// NewCluster returns a Cluster over the nodes in conf, keys are routed to the nodes
+// with a consistent hash ring so adding a node only moves a part of the keys.
+func NewCluster(conf ClusterConf, errNotFound error, opts ...Option) (*Cluster, error) {
+	if len(conf) == 0 {
+		return nil, ErrNoCacheNodes
+	}
+	// * 重复的节点会覆盖之前的节点, 创建客户端前检查
+	names := make(map[string]struct{}, len(conf))
+	for _, node := range conf {
+		name := nodeName(node.Config)
+		if _, ok := names[name]; ok {
+			return nil, fmt.Errorf("%w: %s", ErrDuplicateCacheNode, name)
+		}
+		names[name] = struct{}{}
+	}
+
+	c := &Cluster{
+		dispatcher:  hashx.NewConsistentHash(),
+		nodes:       make(map[string]*clusterNode),
+		errNotFound: errNotFound,
+	}
+	for _, node := range conf {
+		name := nodeName(node.Config)
+		rds := redisx.NewUniversalRds(node.Config)
+		c.nodes[name] = &clusterNode{
+			Cache: NewNode(rds, errNotFound, opts...),
+			rds:   rds,
+		}
+		c.dispatcher.AddWithWeight(name, node.Weight)
+	}
+
+	return c, nil
+}
+
+// Stats returns the request stats of every node, keyed by addr/db.
+func (c *Cluster) Stats() map[string]NodeStats {
+	stats := make(map[string]NodeStats, len(c.nodes))
+	for name, node := range c.nodes {
+		stats[name] = NodeStats{
+			Requests: atomic.LoadUint64(&node.requests),
+			Errors:   atomic.LoadUint64(&node.errors),
+		}
+	}
+
+	return stats
+}
+
+func (c *Cluster) IsNotFound(err error) bool {
+	return errors.Is(err, c.errNotFound)
+}
+
+func (c *Cluster) Del(keys ...string) error {
+	return c.DelCtx(context.Background(), keys...)
+}
+
+// DelCtx deletes the keys grouped by node, one call per node.
+func (c *Cluster) DelCtx(ctx context.Context, keys ...string) error {
+	switch len(keys) {
+	case 0:
+		return nil
+	case 1:
+		node := c.node(keys[0])
+		return node.done(c, node.DelCtx(ctx, keys[0]))
+	}
+
+	var be errorx.BatchError
+	for node, nodeKeys := range c.group(keys) {
+		be.Add(node.done(c, node.DelCtx(ctx, nodeKeys...)))
+	}
+
+	return be.Err()
+}
+
+func (c *Cluster) Get(key string, val interface{}) error {
+	return c.GetCtx(context.Background(), key, val)
+}
+
+func (c *Cluster) GetCtx(ctx context.Context, key string, val interface{}) error {
+	node := c.node(key)
+	return node.done(c, node.GetCtx(ctx, key, val))
+}
+
+func (c *Cluster) Set(key string, val interface{}) error {
+	return c.SetCtx(context.Background(), key, val)
+}
+
+func (c *Cluster) SetCtx(ctx context.Context, key string, val interface{}) error {
+	node := c.node(key)
+	return node.done(c, node.SetCtx(ctx, key, val))
+}
+
+func (c *Cluster) SetWithExpire(key string, val interface{}, expire time.Duration) error {
+	return c.SetWithExpireCtx(context.Background(), key, val, expire)
+}
+
+func (c *Cluster) SetWithExpireCtx(ctx context.Context, key string, val interface{},
+	expire time.Duration) error {
+	node := c.node(key)
+	return node.done(c, node.SetWithExpireCtx(ctx, key, val, expire))
+}
+
+func (c *Cluster) Take(val interface{}, key string, query func(val interface{}) error) error {
+	return c.TakeCtx(context.Background(), val, key, query)
+}
+
+func (c *Cluster) TakeCtx(ctx context.Context, val interface{}, key string,
+	query func(val interface{}) error) error {
+	node := c.node(key)
+	return node.done(c, node.TakeCtx(ctx, val, key, query))
+}
+
+func (c *Cluster) TakeWithExpire(val interface{}, key string,
+	query func(val interface{}, expire time.Duration) error) error {
+	return c.TakeWithExpireCtx(context.Background(), val, key, query)
+}
+
+func (c *Cluster) TakeWithExpireCtx(ctx context.Context, val interface{}, key string,
+	query func(val interface{}, expire time.Duration) error) error {
+	node := c.node(key)
+	return node.done(c, node.TakeWithExpireCtx(ctx, val, key, query))
+}
+
+func (c *Cluster) node(key string) *clusterNode {
+	// * 节点在创建后不会变化, 环不会为空
+	name, _ := c.dispatcher.Get(key)
+	return c.nodes[name]
+}
+
+// * 记录请求结果, 原样返回 err
+func (n *clusterNode) done(c *Cluster, err error) error {
+	atomic.AddUint64(&n.requests, 1)
+	if err != nil && !c.IsNotFound(err) {
+		atomic.AddUint64(&n.errors, 1)
+	}
+
+	return err
+}
+
+func (c *Cluster) GetMany(keys []string, vals interface{}) error {
+	return c.GetManyCtx(context.Background(), keys, vals)
+}
+
+func (c *Cluster) GetManyCtx(ctx context.Context, keys []string, vals interface{}) error {
+	return c.takeMany(vals, keys, func(node *clusterNode, nodeKeys []string, nodeVals interface{}) error {
+		return node.GetManyCtx(ctx, nodeKeys, nodeVals)
+	})
+}
+
+func (c *Cluster) SetMany(vals interface{}) error {
+	return c.SetManyCtx(context.Background(), vals)
+}
+
+func (c *Cluster) SetManyCtx(ctx context.Context, vals interface{}) error {
+	return c.setMany(vals, func(node *clusterNode, nodeVals interface{}) error {
+		return node.SetManyCtx(ctx, nodeVals)
+	})
+}
+
+func (c *Cluster) SetManyWithExpireCtx(ctx context.Context, vals interface{},
+	expire time.Duration) error {
+	return c.setMany(vals, func(node *clusterNode, nodeVals interface{}) error {
+		return node.SetManyWithExpireCtx(ctx, nodeVals, expire)
+	})
+}
+
+func (c *Cluster) TakeMany(vals interface{}, keys []string,
+	query func(keys []string, vals interface{}) error) error {
+	return c.TakeManyCtx(context.Background(), vals, keys, query)
+}
+
+// TakeManyCtx takes the keys from every node, query is called once per node with its missing keys.
+func (c *Cluster) TakeManyCtx(ctx context.Context, vals interface{}, keys []string,
+	query func(keys []string, vals interface{}) error) error {
+	return c.takeMany(vals, keys, func(node *clusterNode, nodeKeys []string, nodeVals interface{}) error {
+		return node.TakeManyCtx(ctx, nodeVals, nodeKeys, query)
+	})
+}
+
+func (c *Cluster) setMany(vals interface{}, fn func(node *clusterNode, nodeVals interface{}) error) error {
+	m, err := mapValue(vals)
+	if err != nil {
+		return err
+	}
+
+	groups := make(map[*clusterNode]reflect.Value)
+	iter := m.MapRange()
+	for iter.Next() {
+		node := c.node(iter.Key().String())
+		group, ok := groups[node]
+		if !ok {
+			group = reflect.MakeMap(m.Type())
+			groups[node] = group
+		}
+		group.SetMapIndex(iter.Key(), iter.Value())
+	}
+
+	var be errorx.BatchError
+	for node, group := range groups {
+		be.Add(node.done(c, fn(node, group.Interface())))
+	}
+
+	return be.Err()
+}
+
+func (c *Cluster) takeMany(vals interface{}, keys []string,
+	fn func(node *clusterNode, nodeKeys []string, nodeVals interface{}) error) error {
+	m, err := mapValue(vals)
+	if err != nil {
+		return err
+	}
+
+	var be errorx.BatchError
+	for node, nodeKeys := range c.group(keys) {
+		nodeVals := newMapLike(m)
+		if err := node.done(c, fn(node, nodeKeys, nodeVals)); err != nil {
+			be.Add(err)
+			continue
+		}
+		mergeMap(m, nodeVals)
+	}
+
+	return be.Err()
+}
+
+// * 按节点对 key 分组
+func (c *Cluster) group(keys []string) map[*clusterNode][]string {
+	groups := make(map[*clusterNode][]string)
+	for _, key := range keys {
+		node := c.node(key)
+		groups[node] = append(groups[node], key)
+	}
+
+	return groups
+}
+
+func (c *Cluster) SetWithTags(key string, val interface{}, tags ...string) error {
+	return c.SetWithTagsCtx(context.Background(), key, val, tags...)
+}
+
+// SetWithTagsCtx sets the cache on the node of key, the tags are kept on the same node.
+func (c *Cluster) SetWithTagsCtx(ctx context.Context, key string, val interface{}, tags ...string) error {
+	node := c.node(key)
+	return node.done(c, node.SetWithTagsCtx(ctx, key, val, tags...))
+}
+
+func (c *Cluster) InvalidateTags(tags ...string) error {
+	return c.InvalidateTagsCtx(context.Background(), tags...)
+}
+
+// InvalidateTagsCtx invalidates the tags on every node.
+func (c *Cluster) InvalidateTagsCtx(ctx context.Context, tags ...string) error {
+	return c.each(func(node *clusterNode) error {
+		return node.InvalidateTagsCtx(ctx, tags...)
+	})
+}
+
+func (c *Cluster) DelPrefix(prefix string) error {
+	return c.DelPrefixCtx(context.Background(), prefix)
+}
+
+// DelPrefixCtx deletes the keys with prefix on every node.
+func (c *Cluster) DelPrefixCtx(ctx context.Context, prefix string) error {
+	return c.each(func(node *clusterNode) error {
+		return node.DelPrefixCtx(ctx, prefix)
+	})
+}
+
+// Close closes the nodes and their redis clients.
+func (c *Cluster) Close() error {
+	var be errorx.BatchError
+	for _, node := range c.nodes {
+		be.Add(node.Close())
+		be.Add(node.rds.Close())
+	}
+
+	return be.Err()
+}
+
+// * 在每个节点上执行 fn
+func (c *Cluster) each(fn func(node *clusterNode) error) error {
+	var be errorx.BatchError
+	for _, node := range c.nodes {
+		be.Add(node.done(c, fn(node)))
+	}
+
+	return be.Err()
+}
+
+func nodeName(conf redisx.Config) string {
+	return fmt.Sprintf("%s/%d", conf.Addrs, conf.Index)
+}
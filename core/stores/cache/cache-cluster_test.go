@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/uc1024/f90/core/stores/redisx"
+)
+
+func TestCluster(t *testing.T) {
+	a, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer a.Close()
+	b, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer b.Close()
+
+	_, err = NewCluster(nil, errors.New("not found"))
+	assert.ErrorIs(t, err, ErrNoCacheNodes)
+	_, err = NewCluster(ClusterConf{
+		{Config: redisx.Config{Addrs: a.Addr()}},
+		{Config: redisx.Config{Addrs: a.Addr()}, Weight: 2},
+	}, errors.New("not found"))
+	assert.ErrorIs(t, err, ErrDuplicateCacheNode)
+
+	errNotFound := errors.New("not found")
+	c, err := NewCluster(ClusterConf{
+		{Config: redisx.Config{Addrs: a.Addr()}, Weight: 1},
+		{Config: redisx.Config{Addrs: b.Addr()}, Weight: 2},
+	}, errNotFound)
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	var keys []string
+	for i := 0; i < 100; i++ {
+		key := "key" + strconv.Itoa(i)
+		keys = append(keys, key)
+		assert.NoError(t, c.SetCtx(ctx, key, i))
+	}
+	// * key 分布在两个节点上, 权重高的节点更多
+	assert.Equal(t, 100, len(a.Keys())+len(b.Keys()))
+	assert.Greater(t, len(b.Keys()), len(a.Keys()))
+
+	var val int
+	assert.NoError(t, c.GetCtx(ctx, "key42", &val))
+	assert.Equal(t, 42, val)
+	assert.NoError(t, c.SetCtx(ctx, "key42", 420))
+	assert.NoError(t, c.GetCtx(ctx, "key42", &val))
+	assert.Equal(t, 420, val)
+
+	assert.NoError(t, c.DelCtx(ctx, keys...))
+	assert.Empty(t, a.Keys())
+	assert.Empty(t, b.Keys())
+	assert.True(t, c.IsNotFound(c.GetCtx(ctx, "key42", &val)))
+
+	stats := c.Stats()
+	assert.Len(t, stats, 2)
+	var requests uint64
+	for _, s := range stats {
+		requests += s.Requests
+		assert.Zero(t, s.Errors)
+	}
+	// * 101 次写入, 3 次读取, 每个节点一次删除
+	assert.Equal(t, uint64(106), requests)
+
+	// * 节点不可用时记录失败次数
+	addrA, addrB := a.Addr()+"/0", b.Addr()+"/0"
+	a.Close()
+	for _, key := range keys[:20] {
+		_ = c.GetCtx(ctx, key, &val)
+	}
+	assert.NotZero(t, c.Stats()[addrA].Errors)
+	assert.Zero(t, c.Stats()[addrB].Errors)
+}
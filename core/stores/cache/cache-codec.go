@@ -0,0 +1,204 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+/*
+	缓存值的格式: [header][payload]
+	header 的低 3 位为编码器 id, 0x08 表示 payload 经过 gzip 压缩,
+	取值范围为 0x01 - 0x0F, 不会与 json 的首字节冲突,
+	没有 header 的数据按 json 解析, 因此未压缩的 json 不写 header, 与旧版本互相兼容.
+*/
+
+const (
+	CodecJSON     byte = 1
+	CodecGob      byte = 2
+	CodecMsgpack  byte = 3
+	CodecProtobuf byte = 4
+
+	codecMask      byte = 0x07
+	compressedFlag byte = 0x08
+)
+
+var (
+	// ErrUnknownCodec means the cached value is written by a codec which is not registered.
+	ErrUnknownCodec = errors.New("unknown cache codec")
+	// ErrNotProtoMessage means the value used with ProtobufCodec is not a proto.Message.
+	ErrNotProtoMessage = errors.New("value is not a proto message")
+
+	builtinCodecs = map[byte]Codec{
+		CodecJSON:     JSONCodec{},
+		CodecGob:      GobCodec{},
+		CodecMsgpack:  MsgpackCodec{},
+		CodecProtobuf: ProtobufCodec{},
+	}
+)
+
+type (
+	// A Codec serializes the cached values.
+	// ID is written to the header of the value so it can be decoded after the codec is switched,
+	// 1 to 4 are used by the builtin codecs, custom codecs should use 5 to 7.
+	Codec interface {
+		ID() byte
+		Marshal(v any) ([]byte, error)
+		Unmarshal(data []byte, v any) error
+	}
+
+	JSONCodec     struct{}
+	GobCodec      struct{}
+	MsgpackCodec  struct{}
+	ProtobufCodec struct{}
+
+	// * 负责缓存值的编解码与压缩
+	serializer struct {
+		codec     Codec
+		threshold int // * 超过该长度时压缩, 0 表示不压缩
+	}
+)
+
+func (JSONCodec) ID() byte { return CodecJSON }
+
+func (JSONCodec) Marshal(v any) ([]byte, error) { return json.Marshal(v) }
+
+func (JSONCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+func (GobCodec) ID() byte { return CodecGob }
+
+func (GobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+func (MsgpackCodec) ID() byte { return CodecMsgpack }
+
+func (MsgpackCodec) Marshal(v any) ([]byte, error) { return msgpack.Marshal(v) }
+
+func (MsgpackCodec) Unmarshal(data []byte, v any) error { return msgpack.Unmarshal(data, v) }
+
+func (ProtobufCodec) ID() byte { return CodecProtobuf }
+
+func (ProtobufCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, ErrNotProtoMessage
+	}
+
+	return proto.Marshal(m)
+}
+
+func (ProtobufCodec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return ErrNotProtoMessage
+	}
+
+	return proto.Unmarshal(data, m)
+}
+
+func newSerializer(codec Codec, threshold int) serializer {
+	if codec == nil {
+		codec = JSONCodec{}
+	}
+
+	return serializer{
+		codec:     codec,
+		threshold: threshold,
+	}
+}
+
+// * 编码并写入 header
+func (s serializer) marshal(v any) ([]byte, error) {
+	payload, err := s.codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	header := s.codec.ID() & codecMask
+	if s.threshold > 0 && len(payload) > s.threshold {
+		if payload, err = compress(payload); err != nil {
+			return nil, err
+		}
+		header |= compressedFlag
+	} else if header == CodecJSON {
+		return payload, nil
+	}
+
+	data := make([]byte, 0, len(payload)+1)
+	data = append(data, header)
+	return append(data, payload...), nil
+}
+
+// * 根据 header 解码, 兼容没有 header 的 json 数据
+func (s serializer) unmarshal(data []byte, v any) error {
+	// * 忽略软过期信息
+	_, _, data, _ = decodeStale(data)
+	if len(data) == 0 || data[0] == 0 || data[0] > codecMask|compressedFlag {
+		return json.Unmarshal(data, v)
+	}
+
+	header, payload := data[0], data[1:]
+	codec, err := s.lookup(header & codecMask)
+	if err != nil {
+		return err
+	}
+
+	if header&compressedFlag != 0 {
+		if payload, err = decompress(payload); err != nil {
+			return err
+		}
+	}
+
+	return codec.Unmarshal(payload, v)
+}
+
+func (s serializer) lookup(id byte) (Codec, error) {
+	if s.codec.ID()&codecMask == id {
+		return s.codec, nil
+	}
+	if codec, ok := builtinCodecs[id]; ok {
+		return codec, nil
+	}
+
+	return nil, fmt.Errorf("%w: %d", ErrUnknownCodec, id)
+}
+
+func compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type codecValue struct {
+	ID        int64
+	Name      string
+	CreatedAt time.Time
+}
+
+func TestSerializer(t *testing.T) {
+	value := codecValue{
+		ID:        1<<62 + 1,
+		Name:      strings.Repeat("f90", 100),
+		CreatedAt: time.Unix(1700000000, 123).UTC(),
+	}
+
+	for _, codec := range []Codec{JSONCodec{}, GobCodec{}, MsgpackCodec{}} {
+		for _, threshold := range []int{0, 64} {
+			s := newSerializer(codec, threshold)
+			data, err := s.marshal(value)
+			assert.NoError(t, err)
+
+			var got codecValue
+			assert.NoError(t, s.unmarshal(data, &got))
+			assert.Equal(t, value.ID, got.ID)
+			assert.Equal(t, value.Name, got.Name)
+			assert.True(t, value.CreatedAt.Equal(got.CreatedAt))
+
+			// * 切换编码器后仍然可以读取
+			assert.NoError(t, newSerializer(JSONCodec{}, 0).unmarshal(data, &got))
+		}
+	}
+
+	s := newSerializer(ProtobufCodec{}, 0)
+	data, err := s.marshal(wrapperspb.String("f90"))
+	assert.NoError(t, err)
+	var msg wrapperspb.StringValue
+	assert.NoError(t, s.unmarshal(data, &msg))
+	assert.Equal(t, "f90", msg.GetValue())
+	_, err = s.marshal(value)
+	assert.ErrorIs(t, err, ErrNotProtoMessage)
+}
+
+func TestSerializerLegacy(t *testing.T) {
+	s := newSerializer(MsgpackCodec{}, 0)
+
+	var got codecValue
+	assert.NoError(t, s.unmarshal([]byte(`{"ID":1,"Name":"f90"}`), &got))
+	assert.Equal(t, codecValue{ID: 1, Name: "f90"}, got)
+
+	// * 未压缩的 json 不写 header
+	data, err := newSerializer(nil, 0).marshal(got)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"ID":1,"Name":"f90","CreatedAt":"0001-01-01T00:00:00Z"}`, string(data))
+
+	assert.ErrorIs(t, s.unmarshal([]byte{0x07, '1'}, &got), ErrUnknownCodec)
+}
+
+func TestNodeCodec(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	errNotFound := errors.New("not found")
+	c := NewNode(redis.NewClient(&redis.Options{Addr: mr.Addr()}), errNotFound,
+		WithCodec(MsgpackCodec{}), WithCompression(16))
+
+	ctx := context.Background()
+	value := codecValue{ID: 1<<62 + 1, Name: strings.Repeat("f90", 10)}
+	assert.NoError(t, c.SetCtx(ctx, "value", value))
+	raw, err := mr.Get("value")
+	assert.NoError(t, err)
+	assert.Equal(t, CodecMsgpack|compressedFlag, raw[0])
+
+	var got codecValue
+	assert.NoError(t, c.GetCtx(ctx, "value", &got))
+	assert.Equal(t, value.ID, got.ID)
+
+	var taken codecValue
+	assert.NoError(t, c.TakeCtx(ctx, &taken, "taken", func(v interface{}) error {
+		*v.(*codecValue) = value
+		return nil
+	}))
+	assert.Equal(t, value.Name, taken.Name)
+}
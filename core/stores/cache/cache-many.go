@@ -0,0 +1,373 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/uc1024/f90/core/slogx"
+)
+
+// ErrInvalidManyValue means the value of the batch operations is not a map[string]T or a pointer to it.
+var ErrInvalidManyValue = errors.New("cache value must be a map[string]T or a pointer to it")
+
+// * 查询函数 panic 时等待的调用方收到的错误
+var errManyQueryPanic = errors.New("cache query panicked")
+
+type (
+	// * 编码后待写入的缓存
+	encodedEntry struct {
+		data []byte
+		ttl  time.Duration
+	}
+
+	// * 批量查询中单个 key 的共享调用
+	manyCall struct {
+		done chan struct{}
+		data []byte // * 编码后的数据, 为空表示不存在
+		err  error
+	}
+
+	// * 按 key 共享批量查询, 重叠的批量查询如 a,b 和 b,c 中的 b 只查询一次
+	manyBarrier struct {
+		lock  sync.Mutex
+		calls map[string]*manyCall
+	}
+)
+
+func newManyBarrier() *manyBarrier {
+	return &manyBarrier{calls: make(map[string]*manyCall)}
+}
+
+/*
+	批量操作的值为 map[string]T,
+	GetMany / TakeMany 传入 *map[string]T 接收结果, 只包含存在的 key
+	SetMany 传入 map[string]T 或 *map[string]T
+*/
+
+func (c cacheNode) GetMany(keys []string, vals interface{}) error {
+	return c.GetManyCtx(context.Background(), keys, vals)
+}
+
+// GetManyCtx gets the caches with keys by MGET, or GET in a pipeline if the keys may be in different slots,
+// the missing keys are not filled into vals.
+func (c cacheNode) GetManyCtx(ctx context.Context, keys []string, vals interface{}) error {
+	m, err := mapValue(vals)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.doGetMany(ctx, keys, m)
+	return err
+}
+
+func (c cacheNode) SetMany(vals interface{}) error {
+	return c.SetManyWithExpireCtx(context.Background(), vals, c.expiry)
+}
+
+func (c cacheNode) SetManyCtx(ctx context.Context, vals interface{}) error {
+	return c.SetManyWithExpireCtx(ctx, vals, c.expiry)
+}
+
+// SetManyWithExpireCtx sets the caches in one pipeline, the existing values are overwritten like SetCtx.
+func (c cacheNode) SetManyWithExpireCtx(ctx context.Context, vals interface{}, expire time.Duration) error {
+	m, err := mapValue(vals)
+	if err != nil {
+		return err
+	}
+	if m.Len() == 0 {
+		return nil
+	}
+
+	entries := make(map[string]encodedEntry, m.Len())
+	iter := m.MapRange()
+	for iter.Next() {
+		data, ttl, err := c.encode(iter.Value().Interface(), expire, 0)
+		if err != nil {
+			return err
+		}
+		entries[iter.Key().String()] = encodedEntry{data: data, ttl: ttl}
+	}
+
+	return c.setMany(ctx, entries, nil, false)
+}
+
+func (c cacheNode) TakeMany(vals interface{}, keys []string,
+	query func(keys []string, vals interface{}) error) error {
+	return c.TakeManyCtx(context.Background(), vals, keys, query)
+}
+
+// TakeManyCtx gets the caches with keys, query is called once with the missing keys
+// which are not being queried by other calls, and a pointer to an empty map of the same type as vals,
+// the values filled by query are cached,
+// the keys which are not filled are cached as not found.
+func (c cacheNode) TakeManyCtx(ctx context.Context, vals interface{}, keys []string,
+	query func(keys []string, vals interface{}) error) error {
+	m, err := mapValue(vals)
+	if err != nil {
+		return err
+	}
+
+	missing, err := c.doGetMany(ctx, keys, m)
+	if err != nil || len(missing) == 0 {
+		return err
+	}
+
+	// * 重叠的批量查询中相同的 key 只查询一次
+	owned, calls := c.manyBarrier.claim(missing)
+	if len(owned) > 0 {
+		c.queryMany(ctx, owned, calls, m, query)
+	}
+
+	// * 共享的结果是编码后的数据, 每个调用方解码得到自己的值
+	for key, call := range calls {
+		<-call.done
+		if call.err != nil {
+			return call.err
+		}
+		if call.data == nil {
+			continue
+		}
+		if err := c.decodeInto(m, key, call.data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// * 查询 keys 并写入缓存, 结果通过 calls 共享给等待的调用方
+func (c cacheNode) queryMany(ctx context.Context, keys []string, calls map[string]*manyCall,
+	m reflect.Value, query func(keys []string, vals interface{}) error) {
+	data := make(map[string][]byte, len(keys))
+	err := errManyQueryPanic
+	defer func() {
+		c.manyBarrier.release(keys, calls, data, err)
+	}()
+
+	queried := newMapLike(m)
+	start := time.Now()
+	err = query(keys, queried)
+	delta := time.Since(start)
+	c.stat.AddQuery(delta)
+	if err != nil {
+		c.stat.IncrementError()
+		return
+	}
+	result := reflect.ValueOf(queried).Elem()
+
+	entries := make(map[string]encodedEntry, len(keys))
+	var notFound []string
+	for _, key := range keys {
+		v := result.MapIndex(mapKey(result, key))
+		if !v.IsValid() {
+			notFound = append(notFound, key)
+			continue
+		}
+
+		var encoded []byte
+		var ttl time.Duration
+		encoded, ttl, err = c.encode(v.Interface(), c.expiry, delta)
+		if err != nil {
+			return
+		}
+		entries[key] = encodedEntry{data: encoded, ttl: ttl}
+		data[key] = encoded
+	}
+
+	if err := c.setMany(ctx, entries, notFound, true); err != nil {
+		slogx.Default.Error(ctx, "failed to set caches", "keys", keys, "error", err.Error())
+	}
+}
+
+// * 返回需要自己查询的 key, calls 包含所有 key 的共享调用
+func (b *manyBarrier) claim(keys []string) ([]string, map[string]*manyCall) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	var owned []string
+	calls := make(map[string]*manyCall, len(keys))
+	for _, key := range keys {
+		if _, ok := calls[key]; ok {
+			continue
+		}
+		if call, ok := b.calls[key]; ok {
+			calls[key] = call
+			continue
+		}
+
+		call := &manyCall{done: make(chan struct{})}
+		b.calls[key] = call
+		calls[key] = call
+		owned = append(owned, key)
+	}
+
+	return owned, calls
+}
+
+// * 保存查询结果并唤醒等待的调用方
+func (b *manyBarrier) release(keys []string, calls map[string]*manyCall,
+	data map[string][]byte, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for _, key := range keys {
+		call := calls[key]
+		call.data, call.err = data[key], err
+		delete(b.calls, key)
+		close(call.done)
+	}
+}
+
+// * 批量读取并解码到 m, 返回缺失的 key, 占位符既不填充也不算缺失
+func (c cacheNode) doGetMany(ctx context.Context, keys []string, m reflect.Value) ([]string, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	values, err := getKeys(ctx, c.rds, keys)
+	if err != nil {
+		c.stat.IncrementError()
+		return nil, err
+	}
+
+	var missing []string
+	for i, key := range keys {
+		value, _ := values[i].(string)
+		if len(value) == 0 {
+			c.stat.IncrementMiss()
+			missing = append(missing, key)
+			continue
+		}
+		if value == notFoundPlaceholder {
+			c.stat.IncrementPlaceholder()
+			continue
+		}
+
+		if err := c.decodeInto(m, key, []byte(value)); err != nil {
+			// * 内容格式不正常, 删除后重新查询
+			slogx.Default.Error(ctx, "failed to unmarshal cache", "key", key, "error", err.Error())
+			c.stat.IncrementError()
+			c.DelCtx(ctx, key)
+			missing = append(missing, key)
+			continue
+		}
+		c.stat.IncrementHit()
+	}
+
+	return missing, nil
+}
+
+func (c cacheNode) decodeInto(m reflect.Value, key string, data []byte) error {
+	v := reflect.New(m.Type().Elem())
+	if err := c.serializer.unmarshal(data, v.Interface()); err != nil {
+		return err
+	}
+	m.SetMapIndex(mapKey(m, key), v.Elem())
+
+	return nil
+}
+
+// * 单节点使用 MGET, 其他模式下 key 可能不在同一个 slot, 在 pipeline 中逐个 GET
+func getKeys(ctx context.Context, rds redis.UniversalClient, keys []string) ([]interface{}, error) {
+	if _, ok := rds.(*redis.Client); ok {
+		return rds.MGet(ctx, keys...).Result()
+	}
+
+	cmds := make([]*redis.StringCmd, len(keys))
+	// * 不存在的 key 返回 redis.Nil, 逐个检查命令的错误
+	rds.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.Get(ctx, key)
+		}
+		return nil
+	})
+
+	values := make([]interface{}, len(keys))
+	for i, cmd := range cmds {
+		value, err := cmd.Result()
+		switch err {
+		case nil:
+			values[i] = value
+		case redis.Nil:
+		default:
+			return nil, err
+		}
+	}
+
+	return values, nil
+}
+
+// * 在一个 pipeline 中写入缓存和占位符, nx 为 true 时不覆盖已经存在的 key
+func (c cacheNode) setMany(ctx context.Context, entries map[string]encodedEntry,
+	notFound []string, nx bool) error {
+	if len(entries) == 0 && len(notFound) == 0 {
+		return nil
+	}
+
+	_, err := c.rds.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for key, entry := range entries {
+			if nx {
+				pipe.SetNX(ctx, key, entry.data, entry.ttl)
+			} else {
+				pipe.Set(ctx, key, entry.data, entry.ttl)
+			}
+		}
+		for _, key := range notFound {
+			pipe.SetNX(ctx, key, notFoundPlaceholder, c.notFoundExpiry)
+		}
+		return nil
+	})
+
+	return err
+}
+
+// * 返回 vals 对应的 map, *map 为 nil 时创建新的 map
+func mapValue(vals interface{}) (reflect.Value, error) {
+	v := reflect.ValueOf(vals)
+	if v.Kind() == reflect.Pointer && !v.IsNil() {
+		v = v.Elem()
+		if v.Kind() == reflect.Map && v.IsNil() && v.CanSet() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+	}
+	if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String || v.IsNil() {
+		return reflect.Value{}, ErrInvalidManyValue
+	}
+
+	return v, nil
+}
+
+// * key 的类型可能是基于 string 定义的类型
+func mapKey(m reflect.Value, key string) reflect.Value {
+	return reflect.ValueOf(key).Convert(m.Type().Key())
+}
+
+// * 返回 map 中的 key
+func mapKeys(m reflect.Value) []string {
+	keys := make([]string, 0, m.Len())
+	iter := m.MapRange()
+	for iter.Next() {
+		keys = append(keys, iter.Key().String())
+	}
+
+	return keys
+}
+
+// * 创建与 m 类型相同的空 map, 返回其指针
+func newMapLike(m reflect.Value) interface{} {
+	p := reflect.New(m.Type())
+	p.Elem().Set(reflect.MakeMap(m.Type()))
+	return p.Interface()
+}
+
+// * 将 src 中的值合并到 dst
+func mergeMap(dst reflect.Value, src interface{}) {
+	iter := reflect.ValueOf(src).Elem().MapRange()
+	for iter.Next() {
+		dst.SetMapIndex(iter.Key(), iter.Value())
+	}
+}
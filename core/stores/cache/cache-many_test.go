@@ -0,0 +1,218 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/uc1024/f90/core/stores/redisx"
+)
+
+var (
+	_ Cache = cacheNode{}
+	_ Cache = (*TwoLevelCache)(nil)
+	_ Cache = (*Cluster)(nil)
+)
+
+type manyUser struct {
+	ID   int64
+	Name string
+}
+
+func TestNodeMany(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	errNotFound := errors.New("not found")
+	c := NewNode(redis.NewClient(&redis.Options{Addr: mr.Addr()}), errNotFound)
+	ctx := context.Background()
+
+	assert.ErrorIs(t, c.GetManyCtx(ctx, []string{"a"}, []manyUser{}), ErrInvalidManyValue)
+
+	assert.NoError(t, c.SetManyCtx(ctx, map[string]manyUser{
+		"user:1": {ID: 1, Name: "a"},
+	}))
+
+	var queried int32
+	query := func(keys []string, vals interface{}) error {
+		atomic.AddInt32(&queried, 1)
+		assert.Equal(t, []string{"user:2", "user:3"}, keys)
+		// * 只返回存在的数据, user:3 写入占位符
+		(*vals.(*map[string]manyUser))["user:2"] = manyUser{ID: 2, Name: "b"}
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var users map[string]manyUser
+			assert.NoError(t, c.TakeManyCtx(ctx, &users, []string{"user:1", "user:2", "user:3"}, query))
+			assert.Equal(t, map[string]manyUser{
+				"user:1": {ID: 1, Name: "a"},
+				"user:2": {ID: 2, Name: "b"},
+			}, users)
+		}()
+	}
+	wg.Wait()
+	assert.LessOrEqual(t, atomic.LoadInt32(&queried), int32(5))
+
+	// * 再次读取不需要查询
+	atomic.StoreInt32(&queried, 0)
+	users := make(map[string]manyUser)
+	assert.NoError(t, c.TakeManyCtx(ctx, &users, []string{"user:1", "user:2", "user:3"}, query))
+	assert.Len(t, users, 2)
+	assert.Zero(t, atomic.LoadInt32(&queried))
+	assert.True(t, mr.Exists("user:3"))
+
+	users = make(map[string]manyUser)
+	assert.NoError(t, c.GetManyCtx(ctx, []string{"user:2", "user:4"}, &users))
+	assert.Equal(t, map[string]manyUser{"user:2": {ID: 2, Name: "b"}}, users)
+
+	// * SetMany 覆盖已经存在的 key
+	assert.NoError(t, c.SetManyCtx(ctx, map[string]manyUser{"user:2": {ID: 2, Name: "c"}}))
+	users = make(map[string]manyUser)
+	assert.NoError(t, c.GetManyCtx(ctx, []string{"user:2"}, &users))
+	assert.Equal(t, "c", users["user:2"].Name)
+}
+
+func TestNodeManyOverlapping(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	c := NewNode(redis.NewClient(&redis.Options{Addr: mr.Addr()}), errors.New("not found"))
+	ctx := context.Background()
+
+	var lock sync.Mutex
+	queried := make(map[string]int)
+	query := func(keys []string, vals interface{}) error {
+		lock.Lock()
+		for _, key := range keys {
+			queried[key]++
+		}
+		lock.Unlock()
+		for _, key := range keys {
+			(*vals.(*map[string]string))[key] = "v" + key
+		}
+		time.Sleep(50 * time.Millisecond)
+		return nil
+	}
+
+	// * a,b 和 b,c 中的 b 只查询一次
+	var wg sync.WaitGroup
+	for i, keys := range [][]string{{"a", "b"}, {"b", "c"}} {
+		wg.Add(1)
+		go func(i int, keys []string) {
+			defer wg.Done()
+			time.Sleep(time.Duration(i) * 10 * time.Millisecond)
+			var vals map[string]string
+			assert.NoError(t, c.TakeManyCtx(ctx, &vals, keys, query))
+			assert.Equal(t, map[string]string{keys[0]: "v" + keys[0], keys[1]: "v" + keys[1]}, vals)
+		}(i, keys)
+	}
+	wg.Wait()
+	assert.Equal(t, map[string]int{"a": 1, "b": 1, "c": 1}, queried)
+
+	// * 查询失败时等待的调用方收到同样的错误
+	errQuery := errors.New("query failed")
+	var errs int32
+	for i, keys := range [][]string{{"d", "e"}, {"e"}} {
+		wg.Add(1)
+		go func(i int, keys []string) {
+			defer wg.Done()
+			time.Sleep(time.Duration(i) * 10 * time.Millisecond)
+			var vals map[string]string
+			err := c.TakeManyCtx(ctx, &vals, keys, func(keys []string, vals interface{}) error {
+				time.Sleep(50 * time.Millisecond)
+				return errQuery
+			})
+			if errors.Is(err, errQuery) {
+				atomic.AddInt32(&errs, 1)
+			}
+		}(i, keys)
+	}
+	wg.Wait()
+	assert.Equal(t, int32(2), atomic.LoadInt32(&errs))
+}
+
+func TestTwoLevelAndClusterMany(t *testing.T) {
+	a, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer a.Close()
+	b, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer b.Close()
+
+	errNotFound := errors.New("not found")
+	two, err := NewTwoLevel(redis.NewClient(&redis.Options{Addr: a.Addr()}), errNotFound)
+	assert.NoError(t, err)
+	defer two.Close()
+	cluster, err := NewCluster(ClusterConf{
+		{Config: redisx.Config{Addrs: a.Addr(), Index: 1}},
+		{Config: redisx.Config{Addrs: b.Addr()}},
+	}, errNotFound)
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	keys := []string{"k1", "k2", "k3", "k4", "k5", "k6"}
+	for _, c := range []Cache{two, cluster} {
+		var queried []string
+		query := func(missing []string, vals interface{}) error {
+			queried = append(queried, missing...)
+			for _, key := range missing {
+				(*vals.(*map[string]string))[key] = "v" + key
+			}
+			return nil
+		}
+
+		vals := make(map[string]string)
+		assert.NoError(t, c.TakeManyCtx(ctx, &vals, keys, query))
+		assert.Len(t, vals, len(keys))
+		assert.ElementsMatch(t, keys, queried)
+
+		queried = nil
+		vals = make(map[string]string)
+		assert.NoError(t, c.TakeManyCtx(ctx, &vals, keys, query))
+		assert.Len(t, vals, len(keys))
+		assert.Empty(t, queried)
+
+		assert.NoError(t, c.DelCtx(ctx, keys...))
+		vals = make(map[string]string)
+		assert.NoError(t, c.GetManyCtx(ctx, keys, &vals))
+		assert.Empty(t, vals)
+	}
+}
+
+func TestNodeClusterClient(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	rds := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{mr.Addr()}})
+	defer rds.Close()
+	c := NewNode(rds, errors.New("not found"))
+	ctx := context.Background()
+
+	// * 集群模式下不使用跨 slot 的 MGET 和多 key 的 DEL
+	assert.NoError(t, c.SetManyCtx(ctx, map[string]string{"a:1": "1", "b:1": "2", "a:2": "3"}))
+	vals := make(map[string]string)
+	assert.NoError(t, c.GetManyCtx(ctx, []string{"a:1", "b:1", "c:1"}, &vals))
+	assert.Equal(t, map[string]string{"a:1": "1", "b:1": "2"}, vals)
+
+	assert.NoError(t, c.DelCtx(ctx, "a:1", "b:1"))
+	assert.False(t, mr.Exists("a:1"))
+	assert.False(t, mr.Exists("b:1"))
+
+	assert.NoError(t, c.DelPrefixCtx(ctx, "a:"))
+	assert.False(t, mr.Exists("a:2"))
+}
@@ -2,15 +2,17 @@ package cache
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/uc1024/f90/core/mathx"
 	"github.com/uc1024/f90/core/slogx"
+	"github.com/uc1024/f90/core/statx"
+	"github.com/uc1024/f90/core/stores/redisx"
 	"github.com/uc1024/f90/core/syncx"
 	"github.com/redis/go-redis/v9"
 )
@@ -27,25 +29,69 @@ var errPlaceholder = errors.New("placeholder")
 
 type (
 	cacheNode struct {
-		rds            *redis.Client
+		rds            redis.UniversalClient
 		expiry         time.Duration // default expiry time
 		notFoundExpiry time.Duration // * 占位锁过期时间
 		barrier        syncx.ShareResults
+		manyBarrier    *manyBarrier // * 批量查询按 key 共享调用
 		unstable       mathx.Unstable
 		errNotFound    error
+		serializer     serializer
+		stale          time.Duration // * 软过期后仍可返回旧值的时长, 0 表示不开启
+		beta           float64       // * 提前刷新的系数, 0 表示不提前刷新
+		refreshLock    time.Duration // * 后台刷新锁的过期时间
+		stat           *statx.CacheStat
+		cleaner        *Cleaner // * 为空时使用内存中的 AddCleanTask, 同一个存储和节点共用
+		closeOnce      *sync.Once
 	}
 )
 
-func NewNode(rds *redis.Client, errNotFound error, opts ...Option) Cache {
+func NewNode(rds redis.UniversalClient, errNotFound error, opts ...Option) Cache {
+	return newNode(rds, errNotFound, opts...)
+}
+
+func newNode(rds redis.UniversalClient, errNotFound error, opts ...Option) cacheNode {
 	o := newOptions(opts...)
-	return cacheNode{
+	c := cacheNode{
 		rds:            rds,
 		expiry:         o.Expiry,
 		notFoundExpiry: o.NotFoundExpiry,
 		barrier:        syncx.NewShareCall(),
+		manyBarrier:    newManyBarrier(),
 		unstable:       mathx.NewUnstable(expiryDeviation),
 		errNotFound:    errNotFound,
+		serializer:     newSerializer(o.Codec, o.CompressAbove),
+		stale:          o.StaleTTL,
+		beta:           o.EarlyRefreshBeta,
+		refreshLock:    o.RefreshLockExpiry,
+		stat:           statx.NewCacheStat(o.Name),
+		closeOnce:      new(sync.Once),
+	}
+
+	if o.CleanStore != nil {
+		cleaner, err := acquireCleaner(o.CleanStore, redisx.Name(rds), func(ctx context.Context, keys ...string) error {
+			return delKeys(ctx, rds, keys...)
+		}, append([]CleanerOption{WithCleanerPrefixDel(c.delPrefix)}, o.CleanerOptions...)...)
+		if err != nil {
+			slogx.Default.Error(nil, fmt.Sprintf("failed to create cache cleaner, error: %v", err))
+		} else {
+			c.cleaner = cleaner
+		}
 	}
+
+	return c
+}
+
+// Close releases the Cleaner and the stat of the node, the redis client is not closed.
+func (c cacheNode) Close() error {
+	c.closeOnce.Do(func() {
+		if c.cleaner != nil {
+			releaseCleaner(c.cleaner)
+		}
+		c.stat.Release()
+	})
+
+	return nil
 }
 
 func (c cacheNode) IsNotFound(err error) bool {
@@ -55,25 +101,41 @@ func (c cacheNode) IsNotFound(err error) bool {
 func (c cacheNode) doGetCache(ctx context.Context,
 	key string, v interface{}) (err error) {
 
+	_, err = c.doGetCacheStale(ctx, key, v)
+	return
+}
+
+// * refresh 表示缓存已过软过期时间(或被提前刷新选中), 需要在后台刷新
+func (c cacheNode) doGetCacheStale(ctx context.Context,
+	key string, v interface{}) (refresh bool, err error) {
+
 	result := c.rds.Get(ctx, key)
 
 	value := ""
 	if result.Err() != nil {
 		if !errors.Is(result.Err(), redis.Nil) {
-			return result.Err()
+			c.stat.IncrementError()
+			return false, result.Err()
 		}
 	} else {
 		value = result.Val()
 	}
 
 	if len(value) == 0 {
-		return c.errNotFound
+		c.stat.IncrementMiss()
+		return false, c.errNotFound
 	}
 	if value == notFoundPlaceholder {
-		return errPlaceholder
+		c.stat.IncrementPlaceholder()
+		return false, errPlaceholder
 	}
 
-	err = c.processCache(ctx, key, value, v)
+	if err = c.processCache(ctx, key, value, v); err != nil {
+		c.stat.IncrementError()
+		return
+	}
+	c.stat.IncrementHit()
+	refresh = c.stale > 0 && c.shouldRefresh([]byte(value))
 
 	return
 }
@@ -84,7 +146,7 @@ func (c cacheNode) Take(val interface{}, key string, query func(val interface{})
 
 func (c cacheNode) TakeCtx(ctx context.Context, val interface{}, key string, query func(val interface{}) error) error {
 	return c.doTake(ctx, val, key, query, func(v interface{}) error {
-		return c.SetCtx(ctx, key, v)
+		return c.setWithExpire(ctx, key, v, c.expiry, true)
 	})
 }
 
@@ -94,7 +156,7 @@ func (c cacheNode) TakeWithExpire(val interface{}, key string, query func(val in
 
 func (c cacheNode) TakeWithExpireCtx(ctx context.Context, val interface{}, key string, query func(val interface{}, expire time.Duration) error) error {
 	cacheval := func(v interface{}) error {
-		return c.SetWithExpireCtx(ctx, key, v, c.expiry)
+		return c.setWithExpire(ctx, key, v, c.expiry, true)
 	}
 	call_query := func(v interface{}) error {
 		return query(v, c.expiry)
@@ -107,14 +169,22 @@ func (c cacheNode) doTake(ctx context.Context, v interface{}, key string,
 
 	val, fresh, err := c.barrier.DoEx(key, func() (interface{}, error) {
 
-		if err := c.doGetCache(ctx, key, v); err != nil {
+		refresh, err := c.doGetCacheStale(ctx, key, v)
+		if err == nil && refresh {
+			// * 返回旧值, 由一个实例在后台刷新
+			c.refreshAsync(key, v, query)
+		}
+		if err != nil {
 			if err == errPlaceholder {
 				return nil, c.errNotFound
 			} else if err != c.errNotFound {
 				return nil, err
 			}
 
-			if err = query(v); err == c.errNotFound {
+			start := time.Now()
+			err = query(v)
+			c.stat.AddQuery(time.Since(start))
+			if err == c.errNotFound {
 
 				if err = c.setCacheWithNotFound(ctx, key); err != nil {
 					fmt.Println(err)
@@ -123,16 +193,22 @@ func (c cacheNode) doTake(ctx context.Context, v interface{}, key string,
 				return nil, c.errNotFound
 
 			} else if err != nil {
+				c.stat.IncrementError()
 				return nil, err
 			}
 
 			// call the callback setting cache value
-			if err = cacheVal(v); err != nil {
+			if c.stale > 0 {
+				err = c.setStale(ctx, key, v, c.expiry, time.Since(start), true)
+			} else {
+				err = cacheVal(v)
+			}
+			if err != nil {
 				fmt.Println(err)
 			}
 		}
 
-		return json.Marshal(v)
+		return c.serializer.codec.Marshal(v)
 	})
 
 	if err != nil {
@@ -144,7 +220,7 @@ func (c cacheNode) doTake(ctx context.Context, v interface{}, key string,
 		return nil
 	}
 
-	return json.Unmarshal(val.([]byte), v)
+	return c.serializer.codec.Unmarshal(val.([]byte), v)
 }
 
 // delete the selected cache
@@ -156,13 +232,10 @@ func (c cacheNode) DelCtx(ctx context.Context, keys ...string) (err error) {
 	if len(keys) == 0 {
 		return nil
 	}
-	for _, key := range keys {
-		if err = c.rds.Del(ctx, key).Err(); err != nil {
-			fmt.Printf("cache delete key:%s err:%s \n", key, err.Error())
-		} else {
-			slogx.Default.Error(nil, "failed to clear cache with keys: %s, error: %v", strings.Join(keys, ","), err)
-			c.asyncRetryDelCache(keys...)
-		}
+	if err = delKeys(ctx, c.rds, keys...); err != nil {
+		slogx.Default.Error(nil, fmt.Sprintf("failed to clear cache with keys: %q, error: %v",
+			strings.Join(keys, ","), err))
+		c.asyncRetryDelCache(keys...)
 	}
 	return nil
 }
@@ -183,7 +256,7 @@ func (c cacheNode) GetCtx(ctx context.Context, key string, val interface{}) erro
 func (c cacheNode) processCache(ctx context.Context,
 	key, data string, v interface{}) error {
 
-	err := json.Unmarshal([]byte(data), v)
+	err := c.serializer.unmarshal([]byte(data), v)
 	if err == nil {
 		return nil
 	}
@@ -229,36 +302,59 @@ func (c cacheNode) SetWithExpire(key string,
 	return c.SetWithExpireCtx(context.Background(), key, val, expire)
 }
 
+// SetWithExpireCtx sets the cache with key and val, the existing value is overwritten.
+func (c cacheNode) SetWithExpireCtx(ctx context.Context, key string,
+	val interface{}, expire time.Duration) error {
+	return c.setWithExpire(ctx, key, val, expire, false)
+}
+
 /*
-只在键 key 不存在的情况下， 将键 key 的值设置为 value 。
-若键 key 已经存在， 则 SETNX 命令不做任何动作。
+nx 为 true 时只在键 key 不存在的情况下写入, 用于查询后回填缓存,
+避免覆盖查询期间其他调用写入的新值, 键已经存在时不是错误.
 */
-func (c cacheNode) SetWithExpireCtx(ctx context.Context, key string,
-	val interface{}, expire time.Duration) (err error) {
+func (c cacheNode) setWithExpire(ctx context.Context, key string,
+	val interface{}, expire time.Duration, nx bool) error {
 
-	data, err := json.Marshal(val)
-	if err != nil {
-		return err
+	if c.stale > 0 {
+		return c.setStale(ctx, key, val, expire, 0, nx)
 	}
 
-	result := c.rds.SetNX(ctx, key, data, c.unstable.AroundDuration(expire))
-
-	if !result.Val() {
-		return fmt.Errorf("set cache key:%s value:%s err:%s", key, data, result.Err().Error())
+	data, err := c.serializer.marshal(val)
+	if err != nil {
+		return err
 	}
 
-	if result.Err() != nil {
-		return result.Err()
+	expire = c.unstable.AroundDuration(expire)
+	if nx {
+		return c.rds.SetNX(ctx, key, data, expire).Err()
 	}
 
-	return nil
-
+	return c.rds.Set(ctx, key, data, expire).Err()
 }
 
 // delay delete caches
 func (c cacheNode) asyncRetryDelCache(keys ...string) {
+	if c.cleaner != nil {
+		c.cleaner.Add(keys...)
+		return
+	}
+
 	AddCleanTask(func() error {
-		result := c.rds.Del(context.Background(), keys...)
-		return result.Err()
+		return delKeys(context.Background(), c.rds, keys...)
 	}, keys...)
 }
+
+// * 单节点一次删除, 其他模式下 key 可能不在同一个 slot, 在 pipeline 中逐个删除
+func delKeys(ctx context.Context, rds redis.UniversalClient, keys ...string) error {
+	if _, ok := rds.(*redis.Client); ok || len(keys) == 1 {
+		return rds.Del(ctx, keys...).Err()
+	}
+
+	_, err := rds.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Del(ctx, key)
+		}
+		return nil
+	})
+	return err
+}
@@ -3,14 +3,35 @@ package cache
 import "time"
 
 const (
-	defaultExpiry         = time.Hour * 24 * 7
-	defaultNotFoundExpiry = time.Minute
+	defaultExpiry            = time.Hour * 24 * 7
+	defaultNotFoundExpiry    = time.Minute
+	defaultLocalExpiry       = time.Minute
+	defaultLocalLimit        = 10000
+	defaultInvalidateChannel = "cache:invalidate"
+	defaultRefreshLockExpiry = time.Second * 10
+	defaultName              = "cache"
 )
 
 type (
 	Options struct {
+		Name           string // * 缓存名称, 用于统计
 		Expiry         time.Duration
 		NotFoundExpiry time.Duration
+		Codec          Codec // * 缓存值的编码器, 默认为 json
+		CompressAbove  int   // * 编码后超过该字节数时使用 gzip 压缩, 0 表示不压缩
+
+		StaleTTL          time.Duration // * 过期后继续返回旧值并在后台刷新的时长, 0 表示不开启
+		EarlyRefreshBeta  float64       // * XFetch 提前刷新的系数, 越大越早刷新, 0 表示不提前刷新
+		RefreshLockExpiry time.Duration // * 后台刷新时跨实例锁的过期时间
+
+		CleanStore     CleanStore      // * 持久化删除失败的重试任务, 为空时只在内存中重试, 同一个 redis 的缓存共用一个 Cleaner
+		CleanerOptions []CleanerOption // * 重试间隔和重试耗尽的回调, 共用的 Cleaner 以第一次创建时为准
+
+		// * 以下仅用于二级缓存
+		LocalExpiry       time.Duration                  // * 本地缓存默认过期时间
+		LocalExpiryFunc   func(key string) time.Duration // * 按 key 设置本地过期时间, 返回 0 表示不缓存到本地
+		LocalLimit        int                            // * 本地缓存的最大数量
+		InvalidateChannel string                         // * 通知其他实例删除本地缓存的频道
 	}
 
 	Option func(o *Options)
@@ -22,16 +43,38 @@ func newOptions(opts ...Option) Options {
 		opt(&o)
 	}
 
+	if len(o.Name) == 0 {
+		o.Name = defaultName
+	}
 	if o.Expiry <= 0 {
 		o.Expiry = defaultExpiry
 	}
 	if o.NotFoundExpiry <= 0 {
 		o.NotFoundExpiry = defaultNotFoundExpiry
 	}
+	if o.LocalExpiry <= 0 {
+		o.LocalExpiry = defaultLocalExpiry
+	}
+	if o.LocalLimit <= 0 {
+		o.LocalLimit = defaultLocalLimit
+	}
+	if o.RefreshLockExpiry <= 0 {
+		o.RefreshLockExpiry = defaultRefreshLockExpiry
+	}
+	if len(o.InvalidateChannel) == 0 {
+		o.InvalidateChannel = defaultInvalidateChannel
+	}
 
 	return o
 }
 
+// WithName sets the name of the cache, caches with the same name share the stats.
+func WithName(name string) Option {
+	return func(o *Options) {
+		o.Name = name
+	}
+}
+
 func WithExpiry(expiry time.Duration) Option {
 	return func(o *Options) {
 		o.Expiry = expiry
@@ -43,3 +86,71 @@ func WithNotFoundExpiry(expiry time.Duration) Option {
 		o.NotFoundExpiry = expiry
 	}
 }
+
+// WithCodec sets the codec of the cached values,
+// values written by the other builtin codecs are still readable.
+func WithCodec(codec Codec) Option {
+	return func(o *Options) {
+		o.Codec = codec
+	}
+}
+
+// WithCompression compresses the values larger than threshold bytes.
+func WithCompression(threshold int) Option {
+	return func(o *Options) {
+		o.CompressAbove = threshold
+	}
+}
+
+// WithLocalExpiry sets the default expiry of the local copies of the two level cache.
+func WithLocalExpiry(expiry time.Duration) Option {
+	return func(o *Options) {
+		o.LocalExpiry = expiry
+	}
+}
+
+// WithLocalExpiryFunc sets the local expiry per key, keys with zero expiry are not cached locally.
+func WithLocalExpiryFunc(fn func(key string) time.Duration) Option {
+	return func(o *Options) {
+		o.LocalExpiryFunc = fn
+	}
+}
+
+// WithLocalLimit sets the max number of the local copies, the least recently used are evicted.
+func WithLocalLimit(limit int) Option {
+	return func(o *Options) {
+		o.LocalLimit = limit
+	}
+}
+
+// WithInvalidateChannel sets the pub/sub channel used to evict the local copies on every instance.
+func WithInvalidateChannel(channel string) Option {
+	return func(o *Options) {
+		o.InvalidateChannel = channel
+	}
+}
+
+// WithStaleWhileRevalidate keeps the values stale longer after they expire,
+// Take returns the stale value and one caller across instances refreshes it in background.
+func WithStaleWhileRevalidate(stale time.Duration) Option {
+	return func(o *Options) {
+		o.StaleTTL = stale
+	}
+}
+
+// WithEarlyRefresh refreshes the values before they expire with a probability
+// which grows as the expiry approaches and with the time the query takes (XFetch),
+// 1 is a good default of beta. It works with WithStaleWhileRevalidate.
+func WithEarlyRefresh(beta float64) Option {
+	return func(o *Options) {
+		o.EarlyRefreshBeta = beta
+	}
+}
+
+// WithCleanStore saves the failed deletions in store so they are retried after restarts.
+func WithCleanStore(store CleanStore, opts ...CleanerOption) Option {
+	return func(o *Options) {
+		o.CleanStore = store
+		o.CleanerOptions = opts
+	}
+}
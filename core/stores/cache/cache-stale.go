@@ -0,0 +1,141 @@
+package cache
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/uc1024/f90/core/slogx"
+	"github.com/uc1024/f90/core/stringx"
+	"github.com/uc1024/f90/core/threadingx"
+)
+
+/*
+	开启 stale-while-revalidate 时缓存值的格式:
+	[0x10][软过期时间(毫秒) 8 字节][查询耗时(毫秒) 4 字节][编码后的值]
+	redis 中的过期时间为软过期时间加上 stale, 软过期后 Take 返回旧值,
+	同时由获得锁的实例在后台刷新.
+*/
+
+const (
+	staleFlag         byte = 0x10
+	staleHeaderSize        = 13
+	refreshLockSuffix      = ":refresh-lock"
+)
+
+// * 值与 token 相同时才删除刷新锁
+var refreshUnlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+    return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// * 写入带软过期时间的缓存
+func (c cacheNode) setStale(ctx context.Context, key string, val interface{},
+	expire, delta time.Duration, nx bool) error {
+	data, ttl, err := c.encode(val, expire, delta)
+	if err != nil {
+		return err
+	}
+
+	if nx {
+		return c.rds.SetNX(ctx, key, data, ttl).Err()
+	}
+
+	return c.rds.Set(ctx, key, data, ttl).Err()
+}
+
+// * 编码缓存值, 返回写入 redis 的数据和过期时间
+func (c cacheNode) encode(val interface{}, expire, delta time.Duration) ([]byte, time.Duration, error) {
+	payload, err := c.serializer.marshal(val)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	expire = c.unstable.AroundDuration(expire)
+	if c.stale <= 0 {
+		return payload, expire, nil
+	}
+
+	data := make([]byte, staleHeaderSize, staleHeaderSize+len(payload))
+	data[0] = staleFlag
+	binary.BigEndian.PutUint64(data[1:9], uint64(time.Now().Add(expire).UnixMilli()))
+	binary.BigEndian.PutUint32(data[9:13], uint32(delta.Milliseconds()))
+
+	return append(data, payload...), expire + c.stale, nil
+}
+
+// * 解析软过期信息, 不是该格式时 ok 为 false
+func decodeStale(data []byte) (softExpireAt time.Time, delta time.Duration, payload []byte, ok bool) {
+	if len(data) < staleHeaderSize || data[0] != staleFlag {
+		return time.Time{}, 0, data, false
+	}
+
+	softExpireAt = time.UnixMilli(int64(binary.BigEndian.Uint64(data[1:9])))
+	delta = time.Duration(binary.BigEndian.Uint32(data[9:13])) * time.Millisecond
+
+	return softExpireAt, delta, data[staleHeaderSize:], true
+}
+
+// * 已过软过期时间, 或者按 XFetch 的概率提前刷新
+// * now - delta * beta * ln(rand()) >= expiry
+func (c cacheNode) shouldRefresh(data []byte) bool {
+	softExpireAt, delta, _, ok := decodeStale(data)
+	if !ok {
+		return false
+	}
+
+	now := time.Now()
+	if !now.Before(softExpireAt) {
+		return true
+	}
+	if c.beta <= 0 || delta <= 0 {
+		return false
+	}
+
+	early := time.Duration(float64(delta) * c.beta * -math.Log(1-rand.Float64()))
+	return !now.Add(early).Before(softExpireAt)
+}
+
+// * 在后台刷新缓存, 通过 redis 锁保证只有一个实例执行查询
+func (c cacheNode) refreshAsync(key string, v interface{}, query func(v interface{}) error) {
+	typ := reflect.TypeOf(v)
+	if typ.Kind() != reflect.Pointer {
+		return
+	}
+
+	threadingx.GoSafe(func() {
+		ctx := context.Background()
+		lock := key + refreshLockSuffix
+		// * 查询超过锁的过期时间后锁可能被其他实例持有, 只释放自己的锁
+		token := stringx.Rand()
+		ok, err := c.rds.SetNX(ctx, lock, token, c.refreshLock).Result()
+		if err != nil || !ok {
+			return
+		}
+		defer refreshUnlockScript.Run(ctx, c.rds, []string{lock}, token)
+
+		val := reflect.New(typ.Elem()).Interface()
+		start := time.Now()
+		err = query(val)
+		c.stat.AddQuery(time.Since(start))
+		if err != nil {
+			if err == c.errNotFound {
+				c.rds.Set(ctx, key, notFoundPlaceholder, c.notFoundExpiry)
+				return
+			}
+			c.stat.IncrementError()
+			slogx.Default.Error(ctx, fmt.Sprintf("failed to refresh cache with key: %s, error: %v", key, err))
+			return
+		}
+
+		if err := c.setStale(ctx, key, val, c.expiry, time.Since(start), false); err != nil {
+			slogx.Default.Error(ctx, fmt.Sprintf("failed to refresh cache with key: %s, error: %v", key, err))
+		}
+	})
+}
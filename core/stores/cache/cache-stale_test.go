@@ -0,0 +1,118 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	c := NewNode(redis.NewClient(&redis.Options{Addr: mr.Addr()}), errors.New("not found"),
+		WithExpiry(50*time.Millisecond), WithStaleWhileRevalidate(time.Minute))
+
+	ctx := context.Background()
+	var version int32
+	query := func(v interface{}) error {
+		*v.(*int32) = atomic.AddInt32(&version, 1)
+		return nil
+	}
+
+	var val int32
+	assert.NoError(t, c.TakeCtx(ctx, &val, "key", query))
+	assert.Equal(t, int32(1), val)
+	assert.NoError(t, c.GetCtx(ctx, "key", &val))
+	assert.Equal(t, int32(1), val)
+
+	// * 其他实例正在刷新时不会重复查询
+	time.Sleep(60 * time.Millisecond)
+	mr.Set("key"+refreshLockSuffix, "1")
+	assert.NoError(t, c.TakeCtx(ctx, &val, "key", query))
+	assert.Equal(t, int32(1), val)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&version))
+
+	// * 软过期后返回旧值, 后台刷新
+	mr.Del("key" + refreshLockSuffix)
+	assert.NoError(t, c.TakeCtx(ctx, &val, "key", query))
+	assert.Equal(t, int32(1), val)
+	assert.Eventually(t, func() bool {
+		var v int32
+		return c.GetCtx(ctx, "key", &v) == nil && v == 2
+	}, time.Second, 5*time.Millisecond)
+	assert.False(t, mr.Exists("key"+refreshLockSuffix))
+}
+
+func TestEarlyRefresh(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	c := NewNode(redis.NewClient(&redis.Options{Addr: mr.Addr()}), errors.New("not found"),
+		WithExpiry(time.Minute), WithStaleWhileRevalidate(time.Minute), WithEarlyRefresh(1e9))
+
+	ctx := context.Background()
+	var version int32
+	query := func(v interface{}) error {
+		time.Sleep(2 * time.Millisecond)
+		*v.(*int32) = atomic.AddInt32(&version, 1)
+		return nil
+	}
+
+	var val int32
+	assert.NoError(t, c.TakeCtx(ctx, &val, "key", query))
+	// * 查询耗时乘以很大的系数, 未过期也会提前刷新
+	assert.NoError(t, c.TakeCtx(ctx, &val, "key", query))
+	assert.Equal(t, int32(1), val)
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&version) == 2
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestRefreshLockHeldByOthers(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	c := NewNode(redis.NewClient(&redis.Options{Addr: mr.Addr()}), errors.New("not found"),
+		WithExpiry(10*time.Millisecond), WithStaleWhileRevalidate(time.Minute))
+
+	ctx := context.Background()
+	var val int32
+	assert.NoError(t, c.TakeCtx(ctx, &val, "key", func(v interface{}) error {
+		*v.(*int32) = 1
+		return nil
+	}))
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	time.Sleep(20 * time.Millisecond)
+	assert.NoError(t, c.TakeCtx(ctx, &val, "key", func(v interface{}) error {
+		close(started)
+		<-release
+		*v.(*int32) = 2
+		return nil
+	}))
+
+	// * 查询期间锁过期并被其他实例获取, 刷新结束后不能删除其他实例的锁
+	<-started
+	mr.Set("key"+refreshLockSuffix, "other")
+	close(release)
+	assert.Eventually(t, func() bool {
+		var v int32
+		return c.GetCtx(ctx, "key", &v) == nil && v == 2
+	}, time.Second, 5*time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	lock, err := mr.Get("key" + refreshLockSuffix)
+	assert.NoError(t, err)
+	assert.Equal(t, "other", lock)
+}
@@ -0,0 +1,191 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// * 标签集合的 key 前缀, 集合中保存打了该标签的 key
+	tagKeyPrefix = "cache:tag:"
+	scanCount    = 100
+)
+
+// * 把 key 加入标签集合, 集合按 key 的过期时间排序, 同时清理已经过期的 key,
+// * 重新打标签时分数一定会变化, 清除标签时据此判断 key 是否被重新打上了标签,
+// * 只访问标签集合一个 key, 集群模式下不会跨 slot
+// KEYS[1] 标签集合
+// ARGV[1] 缓存 key, ARGV[2] 过期时间(毫秒), ARGV[3] 当前时间(毫秒)
+var tagScript = redis.NewScript(`
+local ttl = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now)
+local score = now + ttl
+local old = redis.call("ZSCORE", KEYS[1], ARGV[1])
+if old and tonumber(old) == score then
+    score = score + 1
+end
+redis.call("ZADD", KEYS[1], score, ARGV[1])
+if redis.call("PTTL", KEYS[1]) < ttl then
+    redis.call("PEXPIRE", KEYS[1], ttl)
+end
+return 1`)
+
+// * 只移除分数没有变化的 key, 删除期间重新打上标签的 key 保留在集合中
+// KEYS[1] 标签集合
+// ARGV 读取时的 key 和分数, 依次排列
+var untagScript = redis.NewScript(`
+local removed = 0
+for i = 1, #ARGV, 2 do
+    local score = redis.call("ZSCORE", KEYS[1], ARGV[i])
+    if score and tonumber(score) == tonumber(ARGV[i + 1]) then
+        removed = removed + redis.call("ZREM", KEYS[1], ARGV[i])
+    end
+end
+return removed`)
+
+func (c cacheNode) SetWithTags(key string, val interface{}, tags ...string) error {
+	return c.SetWithTagsCtx(context.Background(), key, val, tags...)
+}
+
+// SetWithTagsCtx sets the cache like SetCtx and attaches tags to key,
+// the tag sets expire no earlier than the keys in them.
+func (c cacheNode) SetWithTagsCtx(ctx context.Context, key string, val interface{}, tags ...string) error {
+	data, ttl, err := c.encode(val, c.expiry, 0)
+	if err != nil {
+		return err
+	}
+
+	if err = c.rds.Set(ctx, key, data, ttl).Err(); err != nil {
+		return err
+	}
+
+	now := time.Now().UnixMilli()
+	for _, tag := range tags {
+		if err = tagScript.Run(ctx, c.rds, []string{tagKey(tag)}, key, ttl.Milliseconds(), now).Err(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c cacheNode) InvalidateTags(tags ...string) error {
+	return c.InvalidateTagsCtx(context.Background(), tags...)
+}
+
+// InvalidateTagsCtx deletes all the keys attached with tags,
+// the keys failed to delete are retried in background.
+func (c cacheNode) InvalidateTagsCtx(ctx context.Context, tags ...string) error {
+	_, err := c.invalidateTags(ctx, tags...)
+	return err
+}
+
+// * 读出标签集合中的 key 和分数, 删除 key 后只从集合中移除分数没有变化的 key,
+// * 删除期间重新打上标签的 key 分数已经变化, 保留在集合中, 之后清除标签时还会删除
+func (c cacheNode) invalidateTags(ctx context.Context, tags ...string) ([]string, error) {
+	var keys []string
+	for _, tag := range tags {
+		zs, err := c.rds.ZRangeWithScores(ctx, tagKey(tag), 0, -1).Result()
+		if err != nil {
+			return keys, err
+		}
+		if len(zs) == 0 {
+			continue
+		}
+
+		members := make([]string, 0, len(zs))
+		args := make([]interface{}, 0, len(zs)*2)
+		for _, z := range zs {
+			member, _ := z.Member.(string)
+			members = append(members, member)
+			args = append(args, member, strconv.FormatFloat(z.Score, 'f', -1, 64))
+		}
+
+		if err = delKeys(ctx, c.rds, members...); err != nil {
+			c.asyncRetryDelCache(members...)
+		}
+		keys = append(keys, members...)
+
+		if err = untagScript.Run(ctx, c.rds, []string{tagKey(tag)}, args...).Err(); err != nil {
+			return keys, err
+		}
+	}
+
+	return keys, nil
+}
+
+func (c cacheNode) DelPrefix(prefix string) error {
+	return c.DelPrefixCtx(context.Background(), prefix)
+}
+
+// DelPrefixCtx deletes the keys with prefix by SCAN in batches, so redis is not blocked,
+// it's retried in background by the Cleaner of the node, or the clean tasks if there isn't one.
+func (c cacheNode) DelPrefixCtx(ctx context.Context, prefix string) error {
+	if len(prefix) == 0 {
+		return nil
+	}
+
+	err := c.delPrefix(ctx, prefix)
+	if err != nil {
+		if c.cleaner != nil {
+			c.cleaner.AddPrefix(prefix)
+			return err
+		}
+
+		AddCleanTask(func() error {
+			return c.delPrefix(context.Background(), prefix)
+		}, prefix+"*")
+	}
+
+	return err
+}
+
+func (c cacheNode) delPrefix(ctx context.Context, prefix string) error {
+	pattern := escapePattern(prefix) + "*"
+	// * 集群和分片模式下 SCAN 只会扫描一个节点, 扫描到的 key 通过原客户端删除
+	switch rds := c.rds.(type) {
+	case *redis.ClusterClient:
+		return rds.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			return scanDel(ctx, node, c.rds, pattern)
+		})
+	case *redis.Ring:
+		return rds.ForEachShard(ctx, func(ctx context.Context, shard *redis.Client) error {
+			return scanDel(ctx, shard, c.rds, pattern)
+		})
+	default:
+		return scanDel(ctx, c.rds, c.rds, pattern)
+	}
+}
+
+func scanDel(ctx context.Context, node, rds redis.UniversalClient, pattern string) error {
+	var cursor uint64
+	for {
+		keys, next, err := node.Scan(ctx, cursor, pattern, scanCount).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if err = delKeys(ctx, rds, keys...); err != nil {
+				return err
+			}
+		}
+
+		if cursor = next; cursor == 0 {
+			return nil
+		}
+	}
+}
+
+func tagKey(tag string) string {
+	return tagKeyPrefix + tag
+}
+
+// * 转义 SCAN MATCH 的通配符
+func escapePattern(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`).Replace(s)
+}
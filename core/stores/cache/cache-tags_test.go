@@ -0,0 +1,141 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeTags(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	c := NewNode(redis.NewClient(&redis.Options{Addr: mr.Addr()}), errors.New("not found"))
+	ctx := context.Background()
+
+	assert.NoError(t, c.SetWithTagsCtx(ctx, "user:1:profile", "p", "user:1"))
+	assert.NoError(t, c.SetWithTagsCtx(ctx, "user:1:orders", "o", "user:1", "orders"))
+	assert.NoError(t, c.SetWithTagsCtx(ctx, "user:2:orders", "o", "orders"))
+	assert.True(t, mr.TTL(tagKey("user:1")) > 0)
+
+	assert.NoError(t, c.InvalidateTagsCtx(ctx, "user:1"))
+	assert.False(t, mr.Exists("user:1:profile"))
+	assert.False(t, mr.Exists("user:1:orders"))
+	assert.False(t, mr.Exists(tagKey("user:1")))
+	assert.True(t, mr.Exists("user:2:orders"))
+}
+
+func TestNodeTagsPrune(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	c := NewNode(redis.NewClient(&redis.Options{Addr: mr.Addr()}), errors.New("not found"),
+		WithExpiry(20*time.Millisecond))
+	ctx := context.Background()
+
+	assert.NoError(t, c.SetWithTagsCtx(ctx, "user:1:profile", "p", "user:1"))
+	// * 覆盖已经存在的 key
+	assert.NoError(t, c.SetWithTagsCtx(ctx, "user:1:profile", "q", "user:1"))
+	var val string
+	assert.NoError(t, c.GetCtx(ctx, "user:1:profile", &val))
+	assert.Equal(t, "q", val)
+
+	// * 再次打标签时清理已经过期的 key
+	time.Sleep(30 * time.Millisecond)
+	assert.NoError(t, c.SetWithTagsCtx(ctx, "user:1:orders", "o", "user:1"))
+	members, err := mr.ZMembers(tagKey("user:1"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"user:1:orders"}, members)
+	assert.True(t, mr.TTL(tagKey("user:1")) > 0)
+}
+
+func TestNodeTagsRetagDuringInvalidate(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	rds := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	c := NewNode(rds, errors.New("not found"))
+	ctx := context.Background()
+
+	assert.NoError(t, c.SetWithTagsCtx(ctx, "user:1:profile", "p", "user:1"))
+	assert.NoError(t, c.SetWithTagsCtx(ctx, "user:1:orders", "o", "user:1"))
+	zs, err := rds.ZRangeWithScores(ctx, tagKey("user:1"), 0, -1).Result()
+	assert.NoError(t, err)
+
+	// * 读取之后重新打上标签的 key, 即使在同一毫秒内分数也会变化, 不会从集合中移除
+	assert.NoError(t, c.SetWithTagsCtx(ctx, "user:1:profile", "q", "user:1"))
+	var args []interface{}
+	for _, z := range zs {
+		args = append(args, z.Member, strconv.FormatFloat(z.Score, 'f', -1, 64))
+	}
+	n, err := untagScript.Run(ctx, rds, []string{tagKey("user:1")}, args...).Int()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	members, err := mr.ZMembers(tagKey("user:1"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"user:1:profile"}, members)
+
+	assert.NoError(t, c.InvalidateTagsCtx(ctx, "user:1"))
+	assert.False(t, mr.Exists("user:1:profile"))
+}
+
+func TestNodeDelPrefix(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	c := NewNode(redis.NewClient(&redis.Options{Addr: mr.Addr()}), errors.New("not found"))
+	ctx := context.Background()
+
+	for i := 0; i < 250; i++ {
+		assert.NoError(t, mr.Set("list:"+strconv.Itoa(i), "1"))
+	}
+	assert.NoError(t, mr.Set("list*", "1"))
+	assert.NoError(t, mr.Set("lists", "1"))
+
+	// * 通配符按字面匹配
+	assert.NoError(t, c.DelPrefixCtx(ctx, "list*"))
+	assert.False(t, mr.Exists("list*"))
+	assert.True(t, mr.Exists("list:1"))
+
+	assert.NoError(t, c.DelPrefixCtx(ctx, "list:"))
+	assert.Equal(t, []string{"lists"}, mr.Keys())
+}
+
+func TestTwoLevelTags(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	newCache := func() *TwoLevelCache {
+		c, err := NewTwoLevel(redis.NewClient(&redis.Options{Addr: mr.Addr()}), errors.New("not found"))
+		assert.NoError(t, err)
+		return c
+	}
+	a, b := newCache(), newCache()
+	defer a.Close()
+	defer b.Close()
+
+	ctx := context.Background()
+	assert.NoError(t, a.SetWithTagsCtx(ctx, "user:1:profile", "p", "user:1"))
+	assert.NoError(t, a.SetCtx(ctx, "page:1", "x"))
+	var val string
+	assert.NoError(t, b.GetCtx(ctx, "user:1:profile", &val))
+	assert.NoError(t, b.GetCtx(ctx, "page:1", &val))
+
+	assert.NoError(t, a.InvalidateTagsCtx(ctx, "user:1"))
+	assert.NoError(t, a.DelPrefixCtx(ctx, "page:"))
+	assert.Eventually(t, func() bool {
+		return b.local.Size() == 0
+	}, time.Second, 10*time.Millisecond)
+	assert.True(t, b.IsNotFound(b.GetCtx(ctx, "page:1", &val)))
+}
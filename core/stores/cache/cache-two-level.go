@@ -0,0 +1,470 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/uc1024/f90/core/collection"
+	"github.com/uc1024/f90/core/slogx"
+	"github.com/uc1024/f90/core/stringx"
+	"github.com/uc1024/f90/core/threadingx"
+)
+
+const (
+	listenRetryInterval = 100 * time.Millisecond
+	// * 本地缓存版本号的分片数
+	versionStripes = 256
+)
+
+type (
+	// A TwoLevelCache keeps local copies of the values in front of the redis node,
+	// Del and Set broadcast the keys through redis pub/sub to evict the local copies on every instance.
+	// The local copies of an instance are flushed when its subscription is broken,
+	// since the broadcasts published meanwhile are lost.
+	TwoLevelCache struct {
+		node        cacheNode
+		rds         redis.UniversalClient
+		local       *collection.Cache
+		serializer  serializer
+		localExpiry func(key string) time.Duration
+		channel     string
+		id          string // * 实例标识, 忽略自己发出的通知
+		pubsub      *redis.PubSub
+		closeOnce   sync.Once
+		// * 按 key 分片的版本号, 清除本地缓存时递增,
+		// * 读取 redis 期间版本号变化说明读到的可能是旧值, 不保存到本地
+		versions [versionStripes]uint64
+	}
+
+	// * 删除本地缓存的通知
+	invalidateMessage struct {
+		ID     string   `json:"id"`
+		Keys   []string `json:"keys,omitempty"`
+		Prefix string   `json:"prefix,omitempty"`
+	}
+)
+
+// NewTwoLevel returns a TwoLevelCache, Close should be called to stop listening the invalidations.
+func NewTwoLevel(rds redis.UniversalClient, errNotFound error, opts ...Option) (*TwoLevelCache, error) {
+	o := newOptions(opts...)
+	local, err := collection.NewCache(o.LocalExpiry,
+		collection.SetCacheLimit(o.LocalLimit),
+		collection.SetCacheName(o.Name+":local"))
+	if err != nil {
+		return nil, err
+	}
+
+	localExpiry := o.LocalExpiryFunc
+	if localExpiry == nil {
+		localExpiry = func(string) time.Duration {
+			return o.LocalExpiry
+		}
+	}
+
+	c := &TwoLevelCache{
+		node:        newNode(rds, errNotFound, opts...),
+		rds:         rds,
+		local:       local,
+		serializer:  newSerializer(o.Codec, 0),
+		localExpiry: localExpiry,
+		channel:     o.InvalidateChannel,
+		id:          stringx.Rand(),
+	}
+
+	c.pubsub = rds.Subscribe(context.Background(), c.channel)
+	// * 等待订阅成功, 避免漏掉之后的通知
+	if _, err = c.pubsub.Receive(context.Background()); err != nil {
+		c.pubsub.Close()
+		local.Close()
+		c.node.Close()
+		return nil, err
+	}
+	threadingx.GoSafe(c.listen)
+
+	return c, nil
+}
+
+// Close stops listening the invalidations and closes the local cache and the redis node.
+func (c *TwoLevelCache) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		err = c.pubsub.Close()
+		c.local.Close()
+		c.node.Close()
+	})
+
+	return err
+}
+
+func (c *TwoLevelCache) IsNotFound(err error) bool {
+	return c.node.IsNotFound(err)
+}
+
+func (c *TwoLevelCache) Del(keys ...string) error {
+	return c.DelCtx(context.Background(), keys...)
+}
+
+func (c *TwoLevelCache) DelCtx(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	c.evict(keys...)
+	err := c.node.DelCtx(ctx, keys...)
+	// * 删除期间读到的旧值不保存到本地
+	c.evict(keys...)
+	c.broadcast(ctx, keys...)
+
+	return err
+}
+
+func (c *TwoLevelCache) Get(key string, val interface{}) error {
+	return c.GetCtx(context.Background(), key, val)
+}
+
+func (c *TwoLevelCache) GetCtx(ctx context.Context, key string, val interface{}) error {
+	if c.getLocal(key, val) {
+		return nil
+	}
+
+	version := c.version(key)
+	if err := c.node.GetCtx(ctx, key, val); err != nil {
+		return err
+	}
+	c.setLocal(key, val, version)
+
+	return nil
+}
+
+func (c *TwoLevelCache) Set(key string, val interface{}) error {
+	return c.SetCtx(context.Background(), key, val)
+}
+
+func (c *TwoLevelCache) SetCtx(ctx context.Context, key string, val interface{}) error {
+	return c.set(ctx, key, func() error {
+		return c.node.SetCtx(ctx, key, val)
+	})
+}
+
+func (c *TwoLevelCache) SetWithExpire(key string, val interface{}, expire time.Duration) error {
+	return c.SetWithExpireCtx(context.Background(), key, val, expire)
+}
+
+func (c *TwoLevelCache) SetWithExpireCtx(ctx context.Context, key string, val interface{},
+	expire time.Duration) error {
+	return c.set(ctx, key, func() error {
+		return c.node.SetWithExpireCtx(ctx, key, val, expire)
+	})
+}
+
+func (c *TwoLevelCache) Take(val interface{}, key string, query func(val interface{}) error) error {
+	return c.TakeCtx(context.Background(), val, key, query)
+}
+
+func (c *TwoLevelCache) TakeCtx(ctx context.Context, val interface{}, key string,
+	query func(val interface{}) error) error {
+	return c.take(key, val, func() error {
+		return c.node.TakeCtx(ctx, val, key, query)
+	})
+}
+
+func (c *TwoLevelCache) TakeWithExpire(val interface{}, key string,
+	query func(val interface{}, expire time.Duration) error) error {
+	return c.TakeWithExpireCtx(context.Background(), val, key, query)
+}
+
+func (c *TwoLevelCache) TakeWithExpireCtx(ctx context.Context, val interface{}, key string,
+	query func(val interface{}, expire time.Duration) error) error {
+	return c.take(key, val, func() error {
+		return c.node.TakeWithExpireCtx(ctx, val, key, query)
+	})
+}
+
+func (c *TwoLevelCache) set(ctx context.Context, key string, fn func() error) error {
+	// * 其他实例的本地缓存可能是旧值
+	c.evict(key)
+	err := fn()
+	c.evict(key)
+	c.broadcast(ctx, key)
+
+	return err
+}
+
+func (c *TwoLevelCache) take(key string, val interface{}, fn func() error) error {
+	if c.getLocal(key, val) {
+		return nil
+	}
+
+	version := c.version(key)
+	if err := fn(); err != nil {
+		return err
+	}
+	c.setLocal(key, val, version)
+
+	return nil
+}
+
+// * 本地缓存保存编码后的数据, 避免调用方修改共享的值
+func (c *TwoLevelCache) getLocal(key string, val interface{}) bool {
+	data, ok := c.local.Get(key)
+	if !ok {
+		return false
+	}
+
+	if err := c.serializer.unmarshal(data.([]byte), val); err != nil {
+		c.local.Del(key)
+		return false
+	}
+
+	return true
+}
+
+// * version 是读取 redis 之前的版本号, 期间本地缓存被清除过则不保存
+func (c *TwoLevelCache) setLocal(key string, val interface{}, version uint64) {
+	expire := c.localExpiry(key)
+	if expire <= 0 || c.version(key) != version {
+		return
+	}
+
+	data, err := c.serializer.marshal(val)
+	if err != nil {
+		return
+	}
+	c.local.SetWithExpire(key, data, expire)
+	// * 保存的同时被清除
+	if c.version(key) != version {
+		c.local.Del(key)
+	}
+}
+
+func (c *TwoLevelCache) version(key string) uint64 {
+	return atomic.LoadUint64(&c.versions[c.stripe(key)])
+}
+
+func (c *TwoLevelCache) stripe(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32() % versionStripes
+}
+
+// * 先递增版本号再删除, 保证正在读取的旧值不会被保存到本地
+func (c *TwoLevelCache) evict(keys ...string) {
+	for _, key := range keys {
+		atomic.AddUint64(&c.versions[c.stripe(key)], 1)
+		c.local.Del(key)
+	}
+}
+
+func (c *TwoLevelCache) evictPrefix(prefix string) {
+	if len(prefix) == 0 {
+		return
+	}
+
+	c.bumpAll()
+	for _, key := range c.local.Keys() {
+		if strings.HasPrefix(key, prefix) {
+			c.local.Del(key)
+		}
+	}
+}
+
+// * 清空本地缓存, 用于可能漏掉通知的时候
+func (c *TwoLevelCache) flush() {
+	c.bumpAll()
+	for _, key := range c.local.Keys() {
+		c.local.Del(key)
+	}
+}
+
+func (c *TwoLevelCache) bumpAll() {
+	for i := range c.versions {
+		atomic.AddUint64(&c.versions[i], 1)
+	}
+}
+
+func (c *TwoLevelCache) broadcast(ctx context.Context, keys ...string) {
+	if len(keys) == 0 {
+		return
+	}
+
+	c.publish(ctx, invalidateMessage{ID: c.id, Keys: keys})
+}
+
+func (c *TwoLevelCache) publish(ctx context.Context, m invalidateMessage) {
+	msg, err := json.Marshal(m)
+	if err != nil {
+		return
+	}
+
+	if err = c.rds.Publish(ctx, c.channel, msg).Err(); err != nil {
+		slogx.Default.Error(ctx, "failed to broadcast cache invalidation",
+			"keys", m.Keys, "prefix", m.Prefix, "error", err.Error())
+	}
+}
+
+func (c *TwoLevelCache) listen() {
+	for {
+		msg, err := c.pubsub.ReceiveMessage(context.Background())
+		if err != nil {
+			if errors.Is(err, redis.ErrClosed) {
+				return
+			}
+			// * 连接断开时 go-redis 会在下次读取时重连, 断开期间的通知已经丢失
+			c.flush()
+			time.Sleep(listenRetryInterval)
+			continue
+		}
+
+		var m invalidateMessage
+		if err = json.Unmarshal([]byte(msg.Payload), &m); err != nil || m.ID == c.id {
+			continue
+		}
+		c.evict(m.Keys...)
+		c.evictPrefix(m.Prefix)
+	}
+}
+
+func (c *TwoLevelCache) GetMany(keys []string, vals interface{}) error {
+	return c.GetManyCtx(context.Background(), keys, vals)
+}
+
+func (c *TwoLevelCache) GetManyCtx(ctx context.Context, keys []string, vals interface{}) error {
+	return c.takeMany(vals, keys, func(remote []string, remoteVals interface{}) error {
+		return c.node.GetManyCtx(ctx, remote, remoteVals)
+	})
+}
+
+func (c *TwoLevelCache) SetMany(vals interface{}) error {
+	return c.SetManyCtx(context.Background(), vals)
+}
+
+func (c *TwoLevelCache) SetManyCtx(ctx context.Context, vals interface{}) error {
+	return c.setMany(ctx, vals, func() error {
+		return c.node.SetManyCtx(ctx, vals)
+	})
+}
+
+func (c *TwoLevelCache) SetManyWithExpireCtx(ctx context.Context, vals interface{},
+	expire time.Duration) error {
+	return c.setMany(ctx, vals, func() error {
+		return c.node.SetManyWithExpireCtx(ctx, vals, expire)
+	})
+}
+
+func (c *TwoLevelCache) TakeMany(vals interface{}, keys []string,
+	query func(keys []string, vals interface{}) error) error {
+	return c.TakeManyCtx(context.Background(), vals, keys, query)
+}
+
+func (c *TwoLevelCache) TakeManyCtx(ctx context.Context, vals interface{}, keys []string,
+	query func(keys []string, vals interface{}) error) error {
+	return c.takeMany(vals, keys, func(remote []string, remoteVals interface{}) error {
+		return c.node.TakeManyCtx(ctx, remoteVals, remote, query)
+	})
+}
+
+func (c *TwoLevelCache) setMany(ctx context.Context, vals interface{}, fn func() error) error {
+	m, err := mapValue(vals)
+	if err != nil {
+		return err
+	}
+
+	keys := mapKeys(m)
+	c.evict(keys...)
+	err = fn()
+	c.evict(keys...)
+	c.broadcast(ctx, keys...)
+
+	return err
+}
+
+// * 先读取本地缓存, 剩余的 key 交给 fn 读取后保存到本地
+func (c *TwoLevelCache) takeMany(vals interface{}, keys []string,
+	fn func(keys []string, vals interface{}) error) error {
+	m, err := mapValue(vals)
+	if err != nil {
+		return err
+	}
+
+	var remote []string
+	versions := make(map[string]uint64)
+	for _, key := range keys {
+		v := reflect.New(m.Type().Elem())
+		if !c.getLocal(key, v.Interface()) {
+			remote = append(remote, key)
+			versions[key] = c.version(key)
+			continue
+		}
+		m.SetMapIndex(mapKey(m, key), v.Elem())
+	}
+	if len(remote) == 0 {
+		return nil
+	}
+
+	remoteVals := newMapLike(m)
+	if err := fn(remote, remoteVals); err != nil {
+		return err
+	}
+
+	iter := reflect.ValueOf(remoteVals).Elem().MapRange()
+	for iter.Next() {
+		key := iter.Key().String()
+		c.setLocal(key, iter.Value().Interface(), versions[key])
+	}
+	mergeMap(m, remoteVals)
+
+	return nil
+}
+
+func (c *TwoLevelCache) SetWithTags(key string, val interface{}, tags ...string) error {
+	return c.SetWithTagsCtx(context.Background(), key, val, tags...)
+}
+
+func (c *TwoLevelCache) SetWithTagsCtx(ctx context.Context, key string, val interface{},
+	tags ...string) error {
+	return c.set(ctx, key, func() error {
+		return c.node.SetWithTagsCtx(ctx, key, val, tags...)
+	})
+}
+
+func (c *TwoLevelCache) InvalidateTags(tags ...string) error {
+	return c.InvalidateTagsCtx(context.Background(), tags...)
+}
+
+// InvalidateTagsCtx deletes the keys attached with tags and evicts their local copies on every instance.
+func (c *TwoLevelCache) InvalidateTagsCtx(ctx context.Context, tags ...string) error {
+	keys, err := c.node.invalidateTags(ctx, tags...)
+	if err != nil {
+		return err
+	}
+
+	c.evict(keys...)
+	c.broadcast(ctx, keys...)
+
+	return nil
+}
+
+func (c *TwoLevelCache) DelPrefix(prefix string) error {
+	return c.DelPrefixCtx(context.Background(), prefix)
+}
+
+// DelPrefixCtx deletes the keys with prefix and evicts their local copies on every instance.
+func (c *TwoLevelCache) DelPrefixCtx(ctx context.Context, prefix string) error {
+	if len(prefix) == 0 {
+		return nil
+	}
+
+	c.evictPrefix(prefix)
+	err := c.node.DelPrefixCtx(ctx, prefix)
+	c.publish(ctx, invalidateMessage{ID: c.id, Prefix: prefix})
+
+	return err
+}
@@ -0,0 +1,130 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTwoLevelCache(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	errNotFound := errors.New("not found")
+	newCache := func() *TwoLevelCache {
+		c, err := NewTwoLevel(redis.NewClient(&redis.Options{Addr: mr.Addr()}), errNotFound,
+			WithLocalExpiryFunc(func(key string) time.Duration {
+				if key == "remote" {
+					return 0
+				}
+				return time.Minute
+			}))
+		assert.NoError(t, err)
+		return c
+	}
+	a, b := newCache(), newCache()
+	defer a.Close()
+	defer b.Close()
+
+	ctx := context.Background()
+	assert.NoError(t, mr.Set("config", `"v1"`))
+	var val string
+	assert.NoError(t, b.GetCtx(ctx, "config", &val))
+	assert.Equal(t, "v1", val)
+
+	// * 命中本地缓存, 不再读取 redis
+	assert.NoError(t, mr.Set("config", `"v2"`))
+	assert.NoError(t, b.GetCtx(ctx, "config", &val))
+	assert.Equal(t, "v1", val)
+
+	// * 其他实例删除后, 本地缓存被清除
+	assert.NoError(t, a.DelCtx(ctx, "config"))
+	assert.Eventually(t, func() bool {
+		_, ok := b.local.Get("config")
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+	assert.True(t, b.IsNotFound(b.GetCtx(ctx, "config", &val)))
+
+	var queried int
+	query := func(v interface{}) error {
+		queried++
+		*v.(*string) = "v3"
+		return nil
+	}
+	assert.NoError(t, b.TakeCtx(ctx, &val, "config", query))
+	assert.NoError(t, b.TakeCtx(ctx, &val, "config", query))
+	assert.Equal(t, "v3", val)
+	assert.Equal(t, 1, queried)
+
+	// * 覆盖已经存在的 key
+	assert.NoError(t, a.SetCtx(ctx, "config", "v4"))
+	assert.Eventually(t, func() bool {
+		var v string
+		return b.GetCtx(ctx, "config", &v) == nil && v == "v4"
+	}, time.Second, 10*time.Millisecond)
+
+	// * 本地过期时间为 0 的 key 不缓存到本地
+	assert.NoError(t, a.SetCtx(ctx, "remote", "v1"))
+	assert.NoError(t, b.GetCtx(ctx, "remote", &val))
+	_, ok := b.local.Get("remote")
+	assert.False(t, ok)
+}
+
+func TestTwoLevelCacheEvictWhileReading(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	errNotFound := errors.New("not found")
+	c, err := NewTwoLevel(redis.NewClient(&redis.Options{Addr: mr.Addr()}), errNotFound)
+	assert.NoError(t, err)
+	defer c.Close()
+
+	// * 读取 redis 期间收到删除通知, 读到的旧值不保存到本地
+	ctx := context.Background()
+	var val string
+	assert.NoError(t, c.TakeCtx(ctx, &val, "config", func(v interface{}) error {
+		c.evict("config")
+		*v.(*string) = "old"
+		return nil
+	}))
+	assert.Equal(t, "old", val)
+	_, ok := c.local.Get("config")
+	assert.False(t, ok)
+
+	assert.NoError(t, c.GetCtx(ctx, "config", &val))
+	_, ok = c.local.Get("config")
+	assert.True(t, ok)
+}
+
+func TestTwoLevelCacheFlushOnDisconnect(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	errNotFound := errors.New("not found")
+	c, err := NewTwoLevel(redis.NewClient(&redis.Options{Addr: mr.Addr()}), errNotFound)
+	assert.NoError(t, err)
+	defer c.Close()
+
+	ctx := context.Background()
+	assert.NoError(t, mr.Set("config", `"v1"`))
+	var val string
+	assert.NoError(t, c.GetCtx(ctx, "config", &val))
+	_, ok := c.local.Get("config")
+	assert.True(t, ok)
+
+	// * 断开期间的通知会丢失, 重连时清空本地缓存
+	mr.Close()
+	assert.NoError(t, mr.Restart())
+	assert.Eventually(t, func() bool {
+		_, ok := c.local.Get("config")
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,135 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// keySeparator joins the parts of the keys built by Typed.
+const keySeparator = ":"
+
+// A Typed is a type safe wrapper of Cache, the values are always of type T,
+// so there is no pointer to pass and no type assertion to write.
+type Typed[T any] struct {
+	cache  Cache
+	prefix string
+}
+
+// NewTyped returns a Typed on c, the keys built by Key start with prefix.
+func NewTyped[T any](c Cache, prefix string) *Typed[T] {
+	return &Typed[T]{
+		cache:  c,
+		prefix: prefix,
+	}
+}
+
+// FormatKey joins prefix and parts with ':', e.g. FormatKey("user", 1, "profile") is user:1:profile.
+func FormatKey(prefix string, parts ...interface{}) string {
+	var b strings.Builder
+	b.WriteString(prefix)
+	for _, part := range parts {
+		if b.Len() > 0 {
+			b.WriteString(keySeparator)
+		}
+		fmt.Fprint(&b, part)
+	}
+
+	return b.String()
+}
+
+// Key returns the key of parts with the prefix of t.
+func (t *Typed[T]) Key(parts ...interface{}) string {
+	return FormatKey(t.prefix, parts...)
+}
+
+// Keys returns the keys of ids with the prefix of t.
+func (t *Typed[T]) Keys(ids ...interface{}) []string {
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = t.Key(id)
+	}
+
+	return keys
+}
+
+// Cache returns the underlying Cache.
+func (t *Typed[T]) Cache() Cache {
+	return t.cache
+}
+
+// IsNotFound checks if err means the value is not found.
+func (t *Typed[T]) IsNotFound(err error) bool {
+	return t.cache.IsNotFound(err)
+}
+
+// Get returns the cached value of key.
+func (t *Typed[T]) Get(ctx context.Context, key string) (T, error) {
+	var val T
+	err := t.cache.GetCtx(ctx, key, &val)
+	return val, err
+}
+
+// Set sets val to key with the default expiry.
+func (t *Typed[T]) Set(ctx context.Context, key string, val T) error {
+	return t.cache.SetCtx(ctx, key, val)
+}
+
+// SetWithExpire sets val to key with expire.
+func (t *Typed[T]) SetWithExpire(ctx context.Context, key string, val T, expire time.Duration) error {
+	return t.cache.SetWithExpireCtx(ctx, key, val, expire)
+}
+
+// Del deletes the cached values of keys.
+func (t *Typed[T]) Del(ctx context.Context, keys ...string) error {
+	return t.cache.DelCtx(ctx, keys...)
+}
+
+// Take returns the cached value of key, if not found, the value is returned by query and cached.
+func (t *Typed[T]) Take(ctx context.Context, key string, query func(ctx context.Context) (T, error)) (T, error) {
+	var val T
+	err := t.cache.TakeCtx(ctx, &val, key, func(v interface{}) error {
+		result, err := query(ctx)
+		if err != nil {
+			return err
+		}
+
+		*v.(*T) = result
+		return nil
+	})
+
+	return val, err
+}
+
+// GetMany returns the cached values of keys, the missing keys are not in the result.
+func (t *Typed[T]) GetMany(ctx context.Context, keys []string) (map[string]T, error) {
+	vals := make(map[string]T, len(keys))
+	err := t.cache.GetManyCtx(ctx, keys, &vals)
+	return vals, err
+}
+
+// SetMany sets vals with the default expiry.
+func (t *Typed[T]) SetMany(ctx context.Context, vals map[string]T) error {
+	return t.cache.SetManyCtx(ctx, vals)
+}
+
+// TakeMany returns the cached values of keys, the missing keys are queried at once and cached.
+func (t *Typed[T]) TakeMany(ctx context.Context, keys []string,
+	query func(ctx context.Context, keys []string) (map[string]T, error)) (map[string]T, error) {
+	vals := make(map[string]T, len(keys))
+	err := t.cache.TakeManyCtx(ctx, &vals, keys, func(missing []string, v interface{}) error {
+		result, err := query(ctx, missing)
+		if err != nil {
+			return err
+		}
+
+		m := *v.(*map[string]T)
+		for key, val := range result {
+			m[key] = val
+		}
+		return nil
+	})
+
+	return vals, err
+}
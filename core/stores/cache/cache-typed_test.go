@@ -0,0 +1,89 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatKey(t *testing.T) {
+	assert.Equal(t, "user:1:profile", FormatKey("user", 1, "profile"))
+	assert.Equal(t, "user", FormatKey("user"))
+	assert.Equal(t, "1", FormatKey("", 1))
+}
+
+func TestTyped(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	errNotFound := errors.New("not found")
+	users := NewTyped[manyUser](NewNode(redis.NewClient(&redis.Options{Addr: mr.Addr()}), errNotFound), "user")
+	ctx := context.Background()
+
+	assert.Equal(t, "user:1", users.Key(1))
+	assert.Equal(t, []string{"user:1", "user:2"}, users.Keys(1, 2))
+
+	_, err = users.Get(ctx, users.Key(1))
+	assert.True(t, users.IsNotFound(err))
+
+	var queried int32
+	query := func(ctx context.Context) (manyUser, error) {
+		atomic.AddInt32(&queried, 1)
+		return manyUser{ID: 1, Name: "a"}, nil
+	}
+	for i := 0; i < 2; i++ {
+		user, err := users.Take(ctx, users.Key(1), query)
+		assert.NoError(t, err)
+		assert.Equal(t, manyUser{ID: 1, Name: "a"}, user)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&queried))
+
+	_, err = users.Take(ctx, users.Key(2), func(ctx context.Context) (manyUser, error) {
+		return manyUser{}, errNotFound
+	})
+	assert.True(t, users.IsNotFound(err))
+
+	assert.NoError(t, users.Set(ctx, users.Key(3), manyUser{ID: 3}))
+	user, err := users.Get(ctx, users.Key(3))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), user.ID)
+
+	// * 覆盖已经存在的 key
+	assert.NoError(t, users.Set(ctx, users.Key(3), manyUser{ID: 3, Name: "c"}))
+	user, err = users.Get(ctx, users.Key(3))
+	assert.NoError(t, err)
+	assert.Equal(t, "c", user.Name)
+
+	assert.NoError(t, users.Del(ctx, users.Key(3)))
+	_, err = users.Get(ctx, users.Key(3))
+	assert.True(t, users.IsNotFound(err))
+}
+
+func TestTypedMany(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	users := NewTyped[*manyUser](NewNode(redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+		errors.New("not found")), "user")
+	ctx := context.Background()
+
+	assert.NoError(t, users.SetMany(ctx, map[string]*manyUser{"user:1": {ID: 1}}))
+
+	vals, err := users.TakeMany(ctx, users.Keys(1, 2, 3), func(ctx context.Context, keys []string) (map[string]*manyUser, error) {
+		assert.Equal(t, []string{"user:2", "user:3"}, keys)
+		return map[string]*manyUser{"user:2": {ID: 2}}, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]*manyUser{"user:1": {ID: 1}, "user:2": {ID: 2}}, vals)
+
+	vals, err = users.GetMany(ctx, users.Keys(1, 2, 3))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(vals))
+}
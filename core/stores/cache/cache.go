@@ -40,6 +40,38 @@ type ( // Cache interface is used to define the cache implementation.
 		// query from DB and set cache using given expire, then return the result.
 		TakeWithExpireCtx(ctx context.Context, val interface{}, key string,
 			query func(val interface{}, expire time.Duration) error) error
+		// GetMany gets the caches with keys and fills the found ones into vals, a *map[string]T.
+		GetMany(keys []string, vals interface{}) error
+		// GetManyCtx gets the caches with keys and fills the found ones into vals, a *map[string]T.
+		GetManyCtx(ctx context.Context, keys []string, vals interface{}) error
+		// SetMany sets the caches in vals, a map[string]T, using c.expiry.
+		SetMany(vals interface{}) error
+		// SetManyCtx sets the caches in vals, a map[string]T, using c.expiry.
+		SetManyCtx(ctx context.Context, vals interface{}) error
+		// SetManyWithExpireCtx sets the caches in vals, a map[string]T, using given expire.
+		SetManyWithExpireCtx(ctx context.Context, vals interface{}, expire time.Duration) error
+		// TakeMany takes the results from cache first, the missing keys are queried
+		// from DB at once and set to cache using c.expiry, then fills the results into vals.
+		TakeMany(vals interface{}, keys []string, query func(keys []string, vals interface{}) error) error
+		// TakeManyCtx takes the results from cache first, the missing keys are queried
+		// from DB at once and set to cache using c.expiry, then fills the results into vals.
+		TakeManyCtx(ctx context.Context, vals interface{}, keys []string,
+			query func(keys []string, vals interface{}) error) error
+		// SetWithTags sets the cache with key and v using c.expiry, and attaches tags to key.
+		SetWithTags(key string, val interface{}, tags ...string) error
+		// SetWithTagsCtx sets the cache with key and v using c.expiry, and attaches tags to key.
+		SetWithTagsCtx(ctx context.Context, key string, val interface{}, tags ...string) error
+		// InvalidateTags deletes the cached values attached with tags.
+		InvalidateTags(tags ...string) error
+		// InvalidateTagsCtx deletes the cached values attached with tags.
+		InvalidateTagsCtx(ctx context.Context, tags ...string) error
+		// DelPrefix deletes the cached values whose keys start with prefix.
+		DelPrefix(prefix string) error
+		// DelPrefixCtx deletes the cached values whose keys start with prefix.
+		DelPrefixCtx(ctx context.Context, prefix string) error
+		// Close releases the background resources of the cache, e.g. the Cleaner and the stat,
+		// the redis clients passed in are not closed.
+		Close() error
 	}
 )
 
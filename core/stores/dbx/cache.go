@@ -0,0 +1,626 @@
+package dbx
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/uc1024/f90/core/slogx"
+	"github.com/uc1024/f90/core/stores/cache"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	cachePluginName = "dbx:cache"
+	cacheKeyPrefix  = "dbx"
+)
+
+var errEmptyRows = errors.New("empty cached rows")
+
+// * 匹配 Where("id = ?", 1) 这样的等值条件
+var equalExpr = regexp.MustCompile("^\\s*([\\w.`]+)\\s*=\\s*\\?\\s*$")
+
+type (
+	// A CachePlugin caches the rows queried by primary key or unique index in a cache.Cache,
+	// the cached rows are invalidated after the updates and deletes through gorm are committed.
+	// Raw sql executed by Exec is not tracked.
+	CachePlugin struct {
+		cache cache.Cache
+		query func(db *gorm.DB)
+		// * 每个 schema 可以作为缓存 key 的列组合
+		keySets sync.Map
+	}
+
+	skipCacheKey struct{}
+)
+
+// NewCachePlugin returns a CachePlugin on c, register it with db.Use.
+func NewCachePlugin(c cache.Cache) *CachePlugin {
+	return &CachePlugin{cache: c}
+}
+
+// SkipCache returns a context which makes the queries with it bypass the cache,
+// e.g. db.WithContext(dbx.SkipCache(ctx)).First(&user, 1).
+func SkipCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, skipCacheKey{}, true)
+}
+
+func (p *CachePlugin) Name() string {
+	return cachePluginName
+}
+
+func (p *CachePlugin) Initialize(db *gorm.DB) error {
+	p.query = db.Callback().Query().Get("gorm:query")
+	if p.query == nil {
+		return fmt.Errorf("%s: gorm:query callback not found", cachePluginName)
+	}
+
+	if err := db.Callback().Query().Replace("gorm:query", p.queryCallback); err != nil {
+		return err
+	}
+	// * 在默认事务提交之后失效, 避免其他查询在提交前把旧数据重新写入缓存
+	if err := db.Callback().Create().After("gorm:commit_or_rollback_transaction").
+		Register(cachePluginName+":create", p.createCallback); err != nil {
+		return err
+	}
+	if err := db.Callback().Update().After("gorm:commit_or_rollback_transaction").
+		Register(cachePluginName+":update", p.invalidate); err != nil {
+		return err
+	}
+	if err := db.Callback().Delete().After("gorm:commit_or_rollback_transaction").
+		Register(cachePluginName+":delete", p.invalidate); err != nil {
+		return err
+	}
+
+	// * 通过连接池开启的事务在提交之后才失效
+	pool := newTxConnPool(db.ConnPool)
+	if db.Statement.ConnPool == db.ConnPool {
+		db.Statement.ConnPool = pool
+	} else {
+		db.Statement.ConnPool = newTxConnPool(db.Statement.ConnPool)
+	}
+	db.ConnPool = pool
+
+	return nil
+}
+
+func (p *CachePlugin) queryCallback(db *gorm.DB) {
+	key, ok := p.cacheKey(db)
+	if !ok {
+		p.query(db)
+		return
+	}
+
+	ctx := db.Statement.Context
+	var data []byte
+	if err := p.cache.GetCtx(ctx, key, &data); err == nil {
+		if err = p.decode(db, data); err == nil {
+			return
+		}
+		slogx.Default.Error(nil, fmt.Sprintf("failed to decode cached rows with key: %s, error: %v", key, err))
+	} else if !p.cache.IsNotFound(err) {
+		slogx.Default.Error(nil, fmt.Sprintf("failed to get cached rows with key: %s, error: %v", key, err))
+	}
+
+	p.query(db)
+	// * 不缓存不存在的记录, 新建记录时不需要失效
+	if db.Error != nil || db.RowsAffected == 0 {
+		return
+	}
+
+	data, err := encodeRows(db.Statement.ReflectValue)
+	if err != nil {
+		slogx.Default.Error(nil, fmt.Sprintf("failed to encode rows with key: %s, error: %v", key, err))
+		return
+	}
+	// * 以主键作为标签, 主键和唯一索引的缓存一起失效
+	tags := p.rowKeys(db.Statement)
+	if err = p.cache.SetWithTagsCtx(ctx, key, data, tags...); err != nil {
+		slogx.Default.Error(nil, fmt.Sprintf("failed to cache rows with key: %s, error: %v", key, err))
+	}
+}
+
+// * upsert 可能更新已缓存的记录
+func (p *CachePlugin) createCallback(db *gorm.DB) {
+	if db.Error != nil || db.Statement.Schema == nil || db.RowsAffected == 0 {
+		return
+	}
+	if _, ok := db.Statement.Clauses["ON CONFLICT"]; !ok {
+		return
+	}
+
+	stmt := db.Statement
+	tags := p.rowKeys(stmt)
+	if len(tags) == 0 {
+		p.invalidateTable(stmt)
+		return
+	}
+
+	p.invalidateTags(stmt, tags)
+}
+
+func (p *CachePlugin) invalidate(db *gorm.DB) {
+	if db.Error != nil || db.Statement.Schema == nil || db.RowsAffected == 0 {
+		return
+	}
+
+	stmt := db.Statement
+	tags, ok := p.primaryKeys(stmt)
+	if !ok {
+		// * 无法确定更新的记录时, 清除整个表的缓存
+		p.invalidateTable(stmt)
+		return
+	}
+
+	p.invalidateTags(stmt, tags)
+}
+
+func (p *CachePlugin) invalidateTags(stmt *gorm.Statement, tags []string) {
+	ctx, table := stmt.Context, stmt.Table
+	afterCommit(stmt, func() {
+		if err := p.cache.InvalidateTagsCtx(ctx, tags...); err != nil {
+			slogx.Default.Error(nil, fmt.Sprintf("failed to invalidate cached rows of %s, error: %v", table, err))
+		}
+	})
+}
+
+func (p *CachePlugin) invalidateTable(stmt *gorm.Statement) {
+	ctx, table := stmt.Context, stmt.Table
+	afterCommit(stmt, func() {
+		if err := p.cache.DelPrefixCtx(ctx, cache.FormatKey(cacheKeyPrefix, table)+":"); err != nil {
+			slogx.Default.Error(nil, fmt.Sprintf("failed to invalidate cached rows of %s, error: %v", table, err))
+		}
+	})
+}
+
+// * 只缓存按主键或唯一索引等值查询整行的语句
+func (p *CachePlugin) cacheKey(db *gorm.DB) (string, bool) {
+	stmt := db.Statement
+	if db.Error != nil || stmt.Schema == nil || stmt.SQL.Len() > 0 || stmt.Unscoped ||
+		len(stmt.Selects) > 0 || len(stmt.Omits) > 0 || len(stmt.Joins) > 0 || stmt.Distinct {
+		return "", false
+	}
+	if skip, _ := stmt.Context.Value(skipCacheKey{}).(bool); skip {
+		return "", false
+	}
+	// * 事务中可能读到未提交的数据
+	if _, ok := stmt.ConnPool.(gorm.TxCommitter); ok {
+		return "", false
+	}
+	if !isModelValue(stmt) {
+		return "", false
+	}
+
+	for name, c := range stmt.Clauses {
+		switch name {
+		case "WHERE", "ORDER BY":
+		case "LIMIT":
+			if limit, ok := c.Expression.(clause.Limit); ok && limit.Offset > 0 {
+				return "", false
+			}
+		default:
+			return "", false
+		}
+	}
+
+	conds, ok := p.equalConditions(stmt)
+	if !ok {
+		return "", false
+	}
+	// * First(&User{ID: 1}) 的主键条件在查询时才加入
+	if stmt.ReflectValue.Kind() == reflect.Struct {
+		for _, field := range stmt.Schema.PrimaryFields {
+			if v, isZero := field.ValueOf(stmt.Context, stmt.ReflectValue); !isZero {
+				if old, exists := conds[field.DBName]; exists && fmt.Sprint(old) != fmt.Sprint(v) {
+					return "", false
+				}
+				conds[field.DBName] = v
+			}
+		}
+	}
+
+	for _, keySet := range p.uniqueKeySets(stmt.Schema) {
+		if sameColumns(conds, keySet) {
+			return rowKey(stmt.Table, conds), true
+		}
+	}
+
+	return "", false
+}
+
+// * 所有条件都是单值的等值条件, 合并为 列 -> 值
+func (p *CachePlugin) equalConditions(stmt *gorm.Statement) (map[string]interface{}, bool) {
+	exprs, ok := whereExprs(stmt)
+	if !ok {
+		return nil, false
+	}
+
+	conds := make(map[string]interface{})
+	for _, expr := range exprs {
+		columns, rows, ok := equalCondition(stmt, expr)
+		if !ok || len(rows) != 1 {
+			return nil, false
+		}
+		for i, column := range columns {
+			if old, exists := conds[column]; exists && fmt.Sprint(old) != fmt.Sprint(rows[0][i]) {
+				return nil, false
+			}
+			conds[column] = rows[0][i]
+		}
+	}
+
+	return conds, len(conds) > 0
+}
+
+// * 从更新或删除语句的条件中找出主键, 返回对应的缓存标签
+func (p *CachePlugin) primaryKeys(stmt *gorm.Statement) ([]string, bool) {
+	pks := stmt.Schema.PrimaryFieldDBNames
+	if len(pks) == 0 {
+		return nil, false
+	}
+	exprs, ok := whereExprs(stmt)
+	if !ok {
+		return nil, false
+	}
+
+	merged := make(map[string]interface{})
+	for _, expr := range exprs {
+		columns, rows, ok := equalCondition(stmt, expr)
+		if !ok {
+			// * AND 连接的其他条件只会缩小范围
+			continue
+		}
+
+		if sameColumns(toSet(columns), pks) {
+			keys := make([]string, 0, len(rows))
+			for _, row := range rows {
+				conds := make(map[string]interface{}, len(columns))
+				for i, column := range columns {
+					conds[column] = row[i]
+				}
+				keys = append(keys, rowKey(stmt.Table, conds))
+			}
+			return keys, true
+		}
+
+		if len(rows) == 1 {
+			for i, column := range columns {
+				merged[column] = rows[0][i]
+			}
+		}
+	}
+
+	conds := make(map[string]interface{}, len(pks))
+	for _, pk := range pks {
+		v, ok := merged[pk]
+		if !ok {
+			return nil, false
+		}
+		conds[pk] = v
+	}
+
+	return []string{rowKey(stmt.Table, conds)}, true
+}
+
+// * 查询结果中每一行的主键缓存 key
+func (p *CachePlugin) rowKeys(stmt *gorm.Statement) []string {
+	pks := stmt.Schema.PrimaryFields
+	if len(pks) == 0 {
+		return nil
+	}
+
+	var keys []string
+	appendKey := func(rv reflect.Value) {
+		rv = reflect.Indirect(rv)
+		if rv.Kind() != reflect.Struct {
+			return
+		}
+
+		conds := make(map[string]interface{}, len(pks))
+		for _, field := range pks {
+			v, isZero := field.ValueOf(stmt.Context, rv)
+			if isZero {
+				return
+			}
+			conds[field.DBName] = v
+		}
+		keys = append(keys, rowKey(stmt.Table, conds))
+	}
+
+	switch rv := stmt.ReflectValue; rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			appendKey(rv.Index(i))
+		}
+	default:
+		appendKey(rv)
+	}
+
+	return keys
+}
+
+// * 主键、唯一字段和唯一索引的列组合
+func (p *CachePlugin) uniqueKeySets(s *schema.Schema) [][]string {
+	if keySets, ok := p.keySets.Load(s); ok {
+		return keySets.([][]string)
+	}
+
+	var keySets [][]string
+	if len(s.PrimaryFieldDBNames) > 0 {
+		keySets = append(keySets, s.PrimaryFieldDBNames)
+	}
+	for _, field := range s.Fields {
+		if field.Unique && len(field.DBName) > 0 {
+			keySets = append(keySets, []string{field.DBName})
+		}
+	}
+	for _, index := range s.ParseIndexes() {
+		if index.Class != "UNIQUE" || len(index.Where) > 0 {
+			continue
+		}
+
+		columns := make([]string, 0, len(index.Fields))
+		for _, field := range index.Fields {
+			if field.Field == nil || len(field.Expression) > 0 {
+				columns = nil
+				break
+			}
+			columns = append(columns, field.DBName)
+		}
+		if len(columns) > 0 {
+			keySets = append(keySets, columns)
+		}
+	}
+
+	p.keySets.Store(s, keySets)
+	return keySets
+}
+
+// * 统一按切片解码, First 和 Find 可以共用同一个缓存
+func (p *CachePlugin) decode(db *gorm.DB, data []byte) error {
+	rv := db.Statement.ReflectValue
+	if rv.Kind() == reflect.Slice {
+		v := reflect.New(rv.Type())
+		if err := gob.NewDecoder(bytes.NewReader(data)).DecodeValue(v); err != nil {
+			return err
+		}
+
+		rv.Set(v.Elem())
+		db.RowsAffected = int64(rv.Len())
+		return nil
+	}
+
+	v := reflect.New(reflect.SliceOf(rv.Type()))
+	if err := gob.NewDecoder(bytes.NewReader(data)).DecodeValue(v); err != nil {
+		return err
+	}
+	if v.Elem().Len() == 0 {
+		return errEmptyRows
+	}
+
+	rv.Set(v.Elem().Index(0))
+	db.RowsAffected = 1
+	return nil
+}
+
+// * 使用 gob 编码整行, 不受 json 标签的影响
+func encodeRows(rv reflect.Value) ([]byte, error) {
+	if rv.Kind() != reflect.Slice {
+		rows := reflect.MakeSlice(reflect.SliceOf(rv.Type()), 0, 1)
+		rv = reflect.Append(rows, rv)
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).EncodeValue(rv); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// * 查询结果写入模型本身, 而不是其他结构或 map
+func isModelValue(stmt *gorm.Statement) bool {
+	rv := stmt.ReflectValue
+	if !rv.IsValid() || !rv.CanSet() {
+		return false
+	}
+
+	t := rv.Type()
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+		if t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+	}
+
+	return t == stmt.Schema.ModelType
+}
+
+// * 展开 WHERE 中以 AND 连接的条件, 包含 OR / NOT 时返回 false
+func whereExprs(stmt *gorm.Statement) ([]clause.Expression, bool) {
+	c, ok := stmt.Clauses["WHERE"]
+	if !ok || c.Expression == nil {
+		return nil, false
+	}
+	where, ok := c.Expression.(clause.Where)
+	if !ok {
+		return nil, false
+	}
+
+	var exprs []clause.Expression
+	var flatten func(list []clause.Expression) bool
+	flatten = func(list []clause.Expression) bool {
+		for _, expr := range list {
+			switch v := expr.(type) {
+			case clause.AndConditions:
+				if !flatten(v.Exprs) {
+					return false
+				}
+			case clause.OrConditions, clause.NotConditions:
+				return false
+			default:
+				exprs = append(exprs, expr)
+			}
+		}
+		return true
+	}
+	if !flatten(where.Exprs) {
+		return nil, false
+	}
+
+	return exprs, len(exprs) > 0
+}
+
+// * 解析等值条件, 返回列名和每一组值
+func equalCondition(stmt *gorm.Statement, expr clause.Expression) ([]string, [][]interface{}, bool) {
+	switch v := expr.(type) {
+	case clause.Eq:
+		column, ok := columnName(stmt, v.Column)
+		if !ok || v.Value == nil {
+			return nil, nil, false
+		}
+		if rv := reflect.ValueOf(v.Value); rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() != reflect.Uint8 {
+			return nil, nil, false
+		}
+		return []string{column}, [][]interface{}{{v.Value}}, true
+	case clause.IN:
+		if columns, ok := v.Column.([]clause.Column); ok {
+			names := make([]string, len(columns))
+			for i, c := range columns {
+				name, ok := columnName(stmt, c)
+				if !ok {
+					return nil, nil, false
+				}
+				names[i] = name
+			}
+
+			rows := make([][]interface{}, len(v.Values))
+			for i, value := range v.Values {
+				row, ok := value.([]interface{})
+				if !ok || len(row) != len(names) {
+					return nil, nil, false
+				}
+				rows[i] = row
+			}
+			return names, rows, len(rows) > 0
+		}
+
+		column, ok := columnName(stmt, v.Column)
+		if !ok || len(v.Values) == 0 {
+			return nil, nil, false
+		}
+		rows := make([][]interface{}, len(v.Values))
+		for i, value := range v.Values {
+			rows[i] = []interface{}{value}
+		}
+		return []string{column}, rows, true
+	case clause.Expr:
+		match := equalExpr.FindStringSubmatch(v.SQL)
+		if match == nil || len(v.Vars) != 1 {
+			return nil, nil, false
+		}
+		return equalCondition(stmt, clause.Eq{Column: match[1], Value: v.Vars[0]})
+	}
+
+	return nil, nil, false
+}
+
+func columnName(stmt *gorm.Statement, column interface{}) (string, bool) {
+	var name string
+	switch c := column.(type) {
+	case string:
+		name = c
+	case clause.Column:
+		if c.Raw {
+			return "", false
+		}
+		if c.Name == clause.PrimaryKey {
+			if stmt.Schema.PrioritizedPrimaryField == nil {
+				return "", false
+			}
+			return stmt.Schema.PrioritizedPrimaryField.DBName, true
+		}
+		if len(c.Table) > 0 && c.Table != clause.CurrentTable && c.Table != stmt.Table {
+			return "", false
+		}
+		name = c.Name
+	default:
+		return "", false
+	}
+
+	name = strings.ReplaceAll(name, "`", "")
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		if table := name[:i]; table != stmt.Table {
+			return "", false
+		}
+		name = name[i+1:]
+	}
+
+	field := stmt.Schema.LookUpField(name)
+	if field == nil || len(field.DBName) == 0 {
+		return "", false
+	}
+
+	return field.DBName, true
+}
+
+// * dbx:<table>:<column>:<value>..., 列按名称排序
+func rowKey(table string, conds map[string]interface{}) string {
+	columns := make([]string, 0, len(conds))
+	for column := range conds {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	parts := make([]interface{}, 0, len(columns)*2+1)
+	parts = append(parts, table)
+	for _, column := range columns {
+		parts = append(parts, column, keyValue(conds[column]))
+	}
+
+	return cache.FormatKey(cacheKeyPrefix, parts...)
+}
+
+// * 指针取其指向的值, 与查询结果中的字段值一致
+func keyValue(v interface{}) interface{} {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return v
+	}
+
+	return rv.Interface()
+}
+
+func sameColumns(conds map[string]interface{}, columns []string) bool {
+	if len(conds) != len(columns) {
+		return false
+	}
+	for _, column := range columns {
+		if _, ok := conds[column]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+func toSet(columns []string) map[string]interface{} {
+	set := make(map[string]interface{}, len(columns))
+	for _, column := range columns {
+		set[column] = nil
+	}
+
+	return set
+}
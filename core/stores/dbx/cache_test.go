@@ -0,0 +1,219 @@
+package dbx
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/uc1024/f90/core/stores/cache"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type cacheUser struct {
+	ID    int64
+	Name  string
+	Email string `gorm:"uniqueIndex"`
+	Token string `json:"-"`
+}
+
+// * 记录执行的语句, 所有查询都返回 rows
+type fakeDriver struct {
+	lock    sync.Mutex
+	queries []string
+	execs   []string
+	rows    [][]driver.Value
+}
+
+type (
+	fakeConn struct{ d *fakeDriver }
+	fakeStmt struct {
+		d     *fakeDriver
+		query string
+	}
+	fakeRows struct {
+		rows [][]driver.Value
+		i    int
+	}
+)
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) { return fakeConn{d: d}, nil }
+
+func (d *fakeDriver) setRows(rows ...[]driver.Value) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.rows = rows
+}
+
+func (d *fakeDriver) queryCount() int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return len(d.queries)
+}
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeStmt{d: c.d, query: query}, nil
+}
+func (c fakeConn) Close() error              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error) { return c, nil }
+func (c fakeConn) Commit() error             { return nil }
+func (c fakeConn) Rollback() error           { return nil }
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.d.lock.Lock()
+	defer s.d.lock.Unlock()
+	s.d.execs = append(s.d.execs, s.query)
+	return driver.RowsAffected(1), nil
+}
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.d.lock.Lock()
+	defer s.d.lock.Unlock()
+	s.d.queries = append(s.d.queries, s.query)
+	return &fakeRows{rows: s.d.rows}, nil
+}
+
+func (r *fakeRows) Columns() []string { return []string{"id", "name", "email", "token"} }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.i >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.i])
+	r.i++
+	return nil
+}
+
+func newCacheDB(t *testing.T) (*gorm.DB, *fakeDriver, *miniredis.Miniredis) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	t.Cleanup(mr.Close)
+
+	d := &fakeDriver{}
+	name := "fake-" + strings.ReplaceAll(t.Name(), "/", "-")
+	sql.Register(name, d)
+	conn, err := sql.Open(name, "")
+	assert.NoError(t, err)
+
+	db, err := gorm.Open(mysql.New(mysql.Config{Conn: conn, SkipInitializeWithVersion: true}),
+		&gorm.Config{Logger: logger.Discard})
+	assert.NoError(t, err)
+
+	c := cache.NewNode(redis.NewClient(&redis.Options{Addr: mr.Addr()}), errors.New("not found"))
+	assert.NoError(t, db.Use(NewCachePlugin(c)))
+
+	return db, d, mr
+}
+
+func TestCachePluginQuery(t *testing.T) {
+	db, d, mr := newCacheDB(t)
+	d.setRows([]driver.Value{int64(1), "a", "a@x.com", "secret"})
+
+	for i := 0; i < 2; i++ {
+		var user cacheUser
+		assert.NoError(t, db.First(&user, 1).Error)
+		assert.Equal(t, cacheUser{ID: 1, Name: "a", Email: "a@x.com", Token: "secret"}, user)
+	}
+	assert.Equal(t, 1, d.queryCount())
+	assert.True(t, mr.Exists("dbx:cache_users:id:1"))
+
+	// * First 和 Find 共用缓存
+	var users []cacheUser
+	assert.NoError(t, db.Where("id = ?", 1).Find(&users).Error)
+	assert.Equal(t, 1, len(users))
+	assert.Equal(t, 1, d.queryCount())
+
+	var user, other cacheUser
+	assert.NoError(t, db.Where(&cacheUser{Email: "a@x.com"}).First(&user).Error)
+	assert.NoError(t, db.Where("email = ?", "a@x.com").Take(&other).Error)
+	assert.Equal(t, int64(1), other.ID)
+	assert.Equal(t, 2, d.queryCount())
+
+	// * 已有主键的 dest 会附加主键条件, 不能命中唯一索引的缓存
+	assert.NoError(t, db.Where("email = ?", "a@x.com").Take(&other).Error)
+	assert.Equal(t, 3, d.queryCount())
+
+	// * 非唯一条件不缓存
+	assert.NoError(t, db.Where("name = ?", "a").First(&user).Error)
+	assert.NoError(t, db.Where("name = ?", "a").First(&user).Error)
+	assert.Equal(t, 5, d.queryCount())
+
+	assert.NoError(t, db.WithContext(SkipCache(context.Background())).First(&user, 1).Error)
+	assert.Equal(t, 6, d.queryCount())
+	assert.NoError(t, db.Select("id").First(&user, 1).Error)
+	assert.Equal(t, 7, d.queryCount())
+
+	// * 不存在的记录不缓存
+	d.setRows()
+	assert.ErrorIs(t, db.First(&user, 2).Error, gorm.ErrRecordNotFound)
+	assert.ErrorIs(t, db.First(&user, 2).Error, gorm.ErrRecordNotFound)
+	assert.Equal(t, 9, d.queryCount())
+}
+
+func TestCachePluginInvalidate(t *testing.T) {
+	db, d, mr := newCacheDB(t)
+	d.setRows([]driver.Value{int64(1), "a", "a@x.com", ""})
+
+	var user, other cacheUser
+	assert.NoError(t, db.First(&user, 1).Error)
+	assert.NoError(t, db.Where("email = ?", "a@x.com").First(&other).Error)
+	assert.True(t, mr.Exists("dbx:cache_users:id:1"))
+	assert.True(t, mr.Exists("dbx:cache_users:email:a@x.com"))
+
+	// * 按主键更新, 主键和唯一索引的缓存一起失效
+	assert.NoError(t, db.Model(&user).Update("email", "b@x.com").Error)
+	assert.False(t, mr.Exists("dbx:cache_users:id:1"))
+	assert.False(t, mr.Exists("dbx:cache_users:email:a@x.com"))
+
+	assert.NoError(t, db.First(&user, 1).Error)
+	assert.True(t, mr.Exists("dbx:cache_users:id:1"))
+	assert.NoError(t, db.Delete(&cacheUser{}, 1).Error)
+	assert.False(t, mr.Exists("dbx:cache_users:id:1"))
+
+	// * 无法确定主键时清除整个表
+	assert.NoError(t, db.First(&user, 1).Error)
+	assert.NoError(t, mr.Set("dbx:cache_users_log:id:1", "x"))
+	assert.NoError(t, db.Model(&cacheUser{}).Where("name = ?", "a").Update("name", "b").Error)
+	assert.False(t, mr.Exists("dbx:cache_users:id:1"))
+	assert.True(t, mr.Exists("dbx:cache_users_log:id:1"))
+}
+
+func TestCachePluginInvalidateInTransaction(t *testing.T) {
+	db, d, mr := newCacheDB(t)
+	d.setRows([]driver.Value{int64(1), "a", "a@x.com", ""})
+
+	var user cacheUser
+	assert.NoError(t, db.First(&user, 1).Error)
+	assert.True(t, mr.Exists("dbx:cache_users:id:1"))
+
+	// * 提交前不失效, 其他查询在提交前重新写入的旧数据也会在提交后清除
+	assert.NoError(t, db.Transaction(func(tx *gorm.DB) error {
+		assert.NoError(t, tx.Model(&user).Update("name", "b").Error)
+		assert.True(t, mr.Exists("dbx:cache_users:id:1"))
+		return nil
+	}))
+	assert.False(t, mr.Exists("dbx:cache_users:id:1"))
+
+	// * 回滚时不失效
+	assert.NoError(t, db.First(&user, 1).Error)
+	assert.True(t, mr.Exists("dbx:cache_users:id:1"))
+	assert.Error(t, db.Transaction(func(tx *gorm.DB) error {
+		assert.NoError(t, tx.Delete(&cacheUser{}, 1).Error)
+		return errors.New("rollback")
+	}))
+	assert.True(t, mr.Exists("dbx:cache_users:id:1"))
+
+	sqlDB, err := db.DB()
+	assert.NoError(t, err)
+	assert.NotNil(t, sqlDB)
+}
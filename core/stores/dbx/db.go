@@ -3,6 +3,7 @@ package dbx
 import (
 	"fmt"
 
+	"github.com/uc1024/f90/core/stores/cache"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -24,6 +25,7 @@ type (
 		Source  []Server `json:"source"`
 		Replica []Server `json:"replica"`
 		Logger  logger.Interface
+		Cache   cache.Cache // * 不为空时按主键和唯一索引缓存查询结果
 	}
 
 	DBOptions = func(*Options)
@@ -66,6 +68,11 @@ func NewDB(opts ...DBOptions) (*gorm.DB, error) {
 	if err != nil {
 		return nil, err
 	}
+	if options.Cache != nil {
+		if err = db.Use(NewCachePlugin(options.Cache)); err != nil {
+			return nil, err
+		}
+	}
 	sqlDB, err := db.DB()
 	if err != nil {
 		return nil, err
@@ -0,0 +1,124 @@
+package dbx
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+type (
+	// * 包装 gorm 的连接池, 开启的事务可以注册提交后执行的函数
+	txConnPool struct {
+		gorm.ConnPool
+	}
+
+	// * 提交成功后执行 afterCommit 注册的函数, 回滚时丢弃
+	hookTx struct {
+		gorm.ConnPool
+		lock  sync.Mutex
+		hooks []func()
+	}
+)
+
+func newTxConnPool(pool gorm.ConnPool) gorm.ConnPool {
+	if _, ok := pool.(txConnPool); ok {
+		return pool
+	}
+
+	return txConnPool{ConnPool: pool}
+}
+
+func (p txConnPool) BeginTx(ctx context.Context, opts *sql.TxOptions) (gorm.ConnPool, error) {
+	switch beginner := p.ConnPool.(type) {
+	case gorm.TxBeginner:
+		tx, err := beginner.BeginTx(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		return &hookTx{ConnPool: tx}, nil
+	case gorm.ConnPoolBeginner:
+		tx, err := beginner.BeginTx(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		return &hookTx{ConnPool: tx}, nil
+	}
+
+	return nil, gorm.ErrInvalidTransaction
+}
+
+// GetDBConnWithContext keeps db.DB() working with the wrapped pool.
+func (p txConnPool) GetDBConnWithContext(db *gorm.DB) (*sql.DB, error) {
+	switch pool := p.ConnPool.(type) {
+	case gorm.GetDBConnectorWithContext:
+		return pool.GetDBConnWithContext(db)
+	case gorm.GetDBConnector:
+		return pool.GetDBConn()
+	case *sql.DB:
+		return pool, nil
+	}
+
+	return nil, gorm.ErrInvalidDB
+}
+
+func (tx *hookTx) Commit() error {
+	committer, ok := tx.ConnPool.(gorm.TxCommitter)
+	if !ok {
+		return gorm.ErrInvalidTransaction
+	}
+	if err := committer.Commit(); err != nil {
+		return err
+	}
+
+	tx.lock.Lock()
+	hooks := tx.hooks
+	tx.hooks = nil
+	tx.lock.Unlock()
+	for _, hook := range hooks {
+		hook()
+	}
+
+	return nil
+}
+
+func (tx *hookTx) Rollback() error {
+	tx.lock.Lock()
+	tx.hooks = nil
+	tx.lock.Unlock()
+
+	committer, ok := tx.ConnPool.(gorm.TxCommitter)
+	if !ok {
+		return gorm.ErrInvalidTransaction
+	}
+
+	return committer.Rollback()
+}
+
+// StmtContext lets the prepared statements be used in the transaction.
+func (tx *hookTx) StmtContext(ctx context.Context, stmt *sql.Stmt) *sql.Stmt {
+	if inner, ok := tx.ConnPool.(interface {
+		StmtContext(ctx context.Context, stmt *sql.Stmt) *sql.Stmt
+	}); ok {
+		return inner.StmtContext(ctx, stmt)
+	}
+
+	return stmt
+}
+
+func (tx *hookTx) add(hook func()) {
+	tx.lock.Lock()
+	tx.hooks = append(tx.hooks, hook)
+	tx.lock.Unlock()
+}
+
+// * 在事务中时提交后再执行 fn, 其他事务无法得知提交时间, 立即执行
+func afterCommit(stmt *gorm.Statement, fn func()) {
+	if tx, ok := stmt.ConnPool.(*hookTx); ok {
+		tx.add(fn)
+		return
+	}
+
+	fn()
+}
@@ -0,0 +1,546 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/uc1024/f90/core/slogx"
+	"github.com/uc1024/f90/core/stringx"
+	"github.com/uc1024/f90/core/threadingx"
+)
+
+const (
+	defaultVisibilityTimeout = 30 * time.Second
+	defaultPollInterval      = time.Second
+	defaultMaxRetries        = 3
+	// * 每次预留时最多转移的到期任务数
+	promoteBatch = 100
+)
+
+var (
+	defaultBackoff = []time.Duration{time.Second, 10 * time.Second, time.Minute}
+
+	// ErrVisibilityTimeout means the job was not acked within the visibility timeout too many times.
+	ErrVisibilityTimeout = errors.New("queue: visibility timeout exceeded")
+
+	// * KEYS: jobs, delayed, ready
+	// * ARGV: id, data, run_at, now
+	enqueueScript = redis.NewScript(`
+redis.call("HSET", KEYS[1], ARGV[1], ARGV[2])
+if tonumber(ARGV[3]) <= tonumber(ARGV[4]) then
+    redis.call("RPUSH", KEYS[3], ARGV[1])
+else
+    redis.call("ZADD", KEYS[2], ARGV[3], ARGV[1])
+end
+return 1`)
+	// * 将到期的延迟任务和超时未确认的任务移入 ready, 再取出一个任务放入 processing
+	// * 每次预留生成新的 token, 超时的预留失去 token, 无法再确认或延长
+	// * KEYS: jobs, attempts, delayed, ready, processing, tokens
+	// * ARGV: now, visibility_ms, batch, token
+	reserveScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+for _, src in ipairs({KEYS[3], KEYS[5]}) do
+    local ids = redis.call("ZRANGEBYSCORE", src, "-inf", now, "LIMIT", 0, tonumber(ARGV[3]))
+    for _, id in ipairs(ids) do
+        redis.call("ZREM", src, id)
+        redis.call("HDEL", KEYS[6], id)
+        redis.call("RPUSH", KEYS[4], id)
+    end
+end
+
+local id = redis.call("LPOP", KEYS[4])
+if not id then
+    return false
+end
+local data = redis.call("HGET", KEYS[1], id)
+if not data then
+    return false
+end
+redis.call("ZADD", KEYS[5], now + tonumber(ARGV[2]), id)
+redis.call("HSET", KEYS[6], id, ARGV[4])
+local attempts = redis.call("HINCRBY", KEYS[2], id, 1)
+return {id, data, attempts}`)
+	// * 只有持有当前预留的 token 才能确认
+	// * KEYS: jobs, attempts, errors, processing, tokens
+	// * ARGV: id, token
+	ackScript = redis.NewScript(`
+if redis.call("HGET", KEYS[5], ARGV[1]) ~= ARGV[2] then
+    return 0
+end
+redis.call("ZREM", KEYS[4], ARGV[1])
+redis.call("HDEL", KEYS[1], ARGV[1])
+redis.call("HDEL", KEYS[2], ARGV[1])
+redis.call("HDEL", KEYS[3], ARGV[1])
+redis.call("HDEL", KEYS[5], ARGV[1])
+return 1`)
+	// * 从 processing 移到 delayed 或 dead, 记录最后的错误, 同样需要持有 token
+	// * KEYS: errors, processing, target, tokens
+	// * ARGV: id, score, error, token
+	moveScript = redis.NewScript(`
+if redis.call("HGET", KEYS[4], ARGV[1]) ~= ARGV[4] then
+    return 0
+end
+redis.call("HDEL", KEYS[4], ARGV[1])
+if redis.call("ZREM", KEYS[2], ARGV[1]) == 0 then
+    return 0
+end
+redis.call("HSET", KEYS[1], ARGV[1], ARGV[3])
+redis.call("ZADD", KEYS[3], ARGV[2], ARGV[1])
+return 1`)
+	// * 持有 token 时延长预留的可见性超时
+	// * KEYS: processing, tokens
+	// * ARGV: id, token, deadline
+	extendScript = redis.NewScript(`
+if redis.call("HGET", KEYS[2], ARGV[1]) ~= ARGV[2] then
+    return 0
+end
+redis.call("ZADD", KEYS[1], "XX", ARGV[3], ARGV[1])
+return 1`)
+	// * KEYS: attempts, dead, ready
+	// * ARGV: id
+	requeueScript = redis.NewScript(`
+if redis.call("ZREM", KEYS[2], ARGV[1]) == 0 then
+    return 0
+end
+redis.call("HDEL", KEYS[1], ARGV[1])
+redis.call("RPUSH", KEYS[3], ARGV[1])
+return 1`)
+)
+
+type (
+	// QueueOption customizes a Queue.
+	QueueOption func(q *Queue)
+
+	// A Handler processes a job, the job is retried if an error is returned.
+	Handler func(ctx context.Context, job *Job) error
+
+	// A DeadLetterHandler is called when a job is moved to the dead letter set.
+	DeadLetterHandler func(job *Job, err error)
+
+	// A Job is a message in the queue.
+	Job struct {
+		ID        string    `json:"-"`
+		Payload   []byte    `json:"payload"`
+		CreatedAt time.Time `json:"created_at"`
+		Attempts  int       `json:"-"` // * 已经投递的次数, 包括当前这一次
+		LastError string    `json:"-"`
+		token     string    // * 当前预留的 token
+	}
+
+	// Size is the number of jobs in every state of a Queue.
+	Size struct {
+		Delayed    int64
+		Ready      int64
+		Processing int64
+		Dead       int64
+	}
+
+	// A Queue is a reliable delayed job queue in redis, the jobs are scheduled in a sorted set,
+	// and a reserved job is redelivered if it's not acked within the visibility timeout.
+	// The visibility of a running job is extended periodically, and the ctx of the handler is
+	// canceled once the reservation is lost, e.g. the job is redelivered to another consumer.
+	// All the keys share the hash tag of the name, so it works with redis cluster.
+	Queue struct {
+		rds        redis.UniversalClient
+		name       string
+		visibility time.Duration
+		poll       time.Duration
+		maxRetries int
+		backoff    []time.Duration
+		deadLetter DeadLetterHandler
+	}
+)
+
+// WithVisibilityTimeout sets how long a reserved job stays invisible before it's redelivered.
+func WithVisibilityTimeout(timeout time.Duration) QueueOption {
+	return func(q *Queue) {
+		if timeout > 0 {
+			q.visibility = timeout
+		}
+	}
+}
+
+// WithPollInterval sets the interval of polling redis when the queue is empty.
+func WithPollInterval(interval time.Duration) QueueOption {
+	return func(q *Queue) {
+		if interval > 0 {
+			q.poll = interval
+		}
+	}
+}
+
+// WithRetry sets the max retries of a failed job and the delays between the retries,
+// the last delay is used for the rest retries.
+func WithRetry(maxRetries int, backoff ...time.Duration) QueueOption {
+	return func(q *Queue) {
+		if maxRetries >= 0 {
+			q.maxRetries = maxRetries
+		}
+		if len(backoff) > 0 {
+			q.backoff = backoff
+		}
+	}
+}
+
+// WithDeadLetter sets the handler called when a job runs out of retries.
+func WithDeadLetter(handler DeadLetterHandler) QueueOption {
+	return func(q *Queue) {
+		q.deadLetter = handler
+	}
+}
+
+// NewQueue returns a Queue with name, the keys are prefixed with queue:{name}.
+func NewQueue(rds redis.UniversalClient, name string, opts ...QueueOption) *Queue {
+	q := &Queue{
+		rds:        rds,
+		name:       name,
+		visibility: defaultVisibilityTimeout,
+		poll:       defaultPollInterval,
+		maxRetries: defaultMaxRetries,
+		backoff:    defaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+
+	return q
+}
+
+// Enqueue adds a job to run as soon as possible.
+func (q *Queue) Enqueue(ctx context.Context, payload []byte) (string, error) {
+	return q.EnqueueAt(ctx, payload, time.Now())
+}
+
+// EnqueueIn adds a job to run after delay.
+func (q *Queue) EnqueueIn(ctx context.Context, payload []byte, delay time.Duration) (string, error) {
+	return q.EnqueueAt(ctx, payload, time.Now().Add(delay))
+}
+
+// EnqueueAt adds a job to run at, it returns the id of the job.
+func (q *Queue) EnqueueAt(ctx context.Context, payload []byte, at time.Time) (string, error) {
+	now := time.Now()
+	data, err := json.Marshal(Job{
+		Payload:   payload,
+		CreatedAt: now,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	id := stringx.Randn(16, "")
+	err = enqueueScript.Run(ctx, q.rds, []string{q.key("jobs"), q.key("delayed"), q.key("ready")},
+		id, data, at.UnixMilli(), now.UnixMilli()).Err()
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// Consume runs handler on the jobs with concurrency workers,
+// it blocks until ctx is done and the running jobs are finished.
+func (q *Queue) Consume(ctx context.Context, concurrency int, handler Handler) {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	var wg sync.WaitGroup
+	runner := threadingx.NewTaskRunner(concurrency)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		runner.Schedule(func() {
+			defer wg.Done()
+			q.work(ctx, handler)
+		})
+	}
+
+	wg.Wait()
+}
+
+// Size returns the number of jobs in every state.
+func (q *Queue) Size(ctx context.Context) (Size, error) {
+	pipe := q.rds.Pipeline()
+	delayed := pipe.ZCard(ctx, q.key("delayed"))
+	ready := pipe.LLen(ctx, q.key("ready"))
+	processing := pipe.ZCard(ctx, q.key("processing"))
+	dead := pipe.ZCard(ctx, q.key("dead"))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return Size{}, err
+	}
+
+	return Size{
+		Delayed:    delayed.Val(),
+		Ready:      ready.Val(),
+		Processing: processing.Val(),
+		Dead:       dead.Val(),
+	}, nil
+}
+
+// DeadJobs returns at most count jobs in the dead letter set, the oldest first.
+func (q *Queue) DeadJobs(ctx context.Context, count int64) ([]*Job, error) {
+	ids, err := q.rds.ZRange(ctx, q.key("dead"), 0, count-1).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	pipe := q.rds.Pipeline()
+	datas := pipe.HMGet(ctx, q.key("jobs"), ids...)
+	attempts := pipe.HMGet(ctx, q.key("attempts"), ids...)
+	errs := pipe.HMGet(ctx, q.key("errors"), ids...)
+	if _, err = pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	jobs := make([]*Job, 0, len(ids))
+	for i, id := range ids {
+		data, ok := datas.Val()[i].(string)
+		if !ok {
+			continue
+		}
+
+		job, err := decodeJob(id, data)
+		if err != nil {
+			return nil, err
+		}
+		if v, ok := attempts.Val()[i].(string); ok {
+			fmt.Sscan(v, &job.Attempts)
+		}
+		job.LastError, _ = errs.Val()[i].(string)
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
+
+// Requeue moves the dead job with id back to the queue with its attempts reset.
+func (q *Queue) Requeue(ctx context.Context, id string) (bool, error) {
+	n, err := requeueScript.Run(ctx, q.rds, []string{q.key("attempts"), q.key("dead"), q.key("ready")},
+		id).Int()
+	return n == 1, err
+}
+
+// Delete removes the job with id in any state.
+func (q *Queue) Delete(ctx context.Context, id string) error {
+	pipe := q.rds.TxPipeline()
+	pipe.ZRem(ctx, q.key("delayed"), id)
+	pipe.LRem(ctx, q.key("ready"), 0, id)
+	pipe.ZRem(ctx, q.key("dead"), id)
+	pipe.ZRem(ctx, q.key("processing"), id)
+	pipe.HDel(ctx, q.key("jobs"), id)
+	pipe.HDel(ctx, q.key("attempts"), id)
+	pipe.HDel(ctx, q.key("errors"), id)
+	pipe.HDel(ctx, q.key("tokens"), id)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (q *Queue) work(ctx context.Context, handler Handler) {
+	for ctx.Err() == nil {
+		job, err := q.reserve(ctx)
+		if err != nil && ctx.Err() == nil {
+			slogx.Default.Error(ctx, "failed to reserve job", "queue", q.name, "error", err.Error())
+		}
+		if job == nil {
+			select {
+			case <-ctx.Done():
+			case <-time.After(q.poll):
+			}
+			continue
+		}
+
+		q.process(ctx, job, handler)
+	}
+}
+
+func (q *Queue) reserve(ctx context.Context) (*Job, error) {
+	token := stringx.Randn(16, "")
+	res, err := reserveScript.Run(ctx, q.rds,
+		[]string{q.key("jobs"), q.key("attempts"), q.key("delayed"), q.key("ready"), q.key("processing"),
+			q.key("tokens")},
+		time.Now().UnixMilli(), q.visibility.Milliseconds(), promoteBatch, token).Slice()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(res) != 3 {
+		return nil, fmt.Errorf("queue: unexpected reserve result %v", res)
+	}
+
+	id, _ := res[0].(string)
+	data, _ := res[1].(string)
+	job, err := decodeJob(id, data)
+	if err != nil {
+		return nil, err
+	}
+	attempts, _ := res[2].(int64)
+	job.Attempts = int(attempts)
+	job.token = token
+
+	return job, nil
+}
+
+func (q *Queue) process(ctx context.Context, job *Job, handler Handler) {
+	var err error
+	// * 多次超时未确认的任务不再执行
+	if job.Attempts > q.maxRetries+1 {
+		err = ErrVisibilityTimeout
+	} else {
+		err = q.handle(ctx, job, handler)
+	}
+
+	if err == nil {
+		q.ack(job)
+		return
+	}
+
+	job.LastError = err.Error()
+	if job.Attempts <= q.maxRetries {
+		q.retry(job)
+		return
+	}
+
+	q.bury(job, err)
+}
+
+func (q *Queue) handle(ctx context.Context, job *Job, handler Handler) (err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		cancel()
+		if p := recover(); p != nil {
+			err = fmt.Errorf("queue: handler panic: %v", p)
+		}
+	}()
+
+	threadingx.GoSafe(func() {
+		q.keepAlive(job, cancel, done)
+	})
+
+	return handler(ctx, job)
+}
+
+// * 处理期间定期延长可见性超时, 预留丢失或无法在超时前延长时取消 handler 的 ctx
+func (q *Queue) keepAlive(job *Job, cancel context.CancelFunc, done <-chan struct{}) {
+	interval := q.visibility / 3
+	// * 可见性超时只有几纳秒时, 不能传 0 给 NewTicker
+	if interval <= 0 {
+		interval = q.visibility
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	deadline := time.Now().Add(q.visibility)
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		ok, err := q.extend(job, now.Add(q.visibility))
+		switch {
+		case err == nil && ok:
+			deadline = now.Add(q.visibility)
+		case err == nil:
+			slogx.Default.Error(context.Background(), "job reservation lost",
+				"queue", q.name, "id", job.ID)
+			cancel()
+			return
+		default:
+			slogx.Default.Error(context.Background(), "failed to extend job visibility",
+				"queue", q.name, "id", job.ID, "error", err.Error())
+			// * 下次延长前就会超时, 任务可能已经投递给其他消费者
+			if deadline.Sub(now) <= interval {
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// * 持有预留时将可见性超时延长到 deadline
+func (q *Queue) extend(job *Job, deadline time.Time) (bool, error) {
+	n, err := extendScript.Run(context.Background(), q.rds,
+		[]string{q.key("processing"), q.key("tokens")}, job.ID, job.token, deadline.UnixMilli()).Int()
+	return n == 1, err
+}
+
+// * 确认和重试不受消费者 ctx 的影响, 避免退出时任务状态丢失
+// * 预留已经丢失时任务由新的持有者处理, 不做任何修改
+func (q *Queue) ack(job *Job) {
+	n, err := ackScript.Run(context.Background(), q.rds,
+		[]string{q.key("jobs"), q.key("attempts"), q.key("errors"), q.key("processing"), q.key("tokens")},
+		job.ID, job.token).Int()
+	if err != nil {
+		slogx.Default.Error(context.Background(), "failed to ack job",
+			"queue", q.name, "id", job.ID, "error", err.Error())
+	} else if n == 0 {
+		slogx.Default.Error(context.Background(), "failed to ack job, reservation lost",
+			"queue", q.name, "id", job.ID)
+	}
+}
+
+func (q *Queue) retry(job *Job) {
+	runAt := time.Now().Add(q.delay(job.Attempts))
+	err := moveScript.Run(context.Background(), q.rds,
+		[]string{q.key("errors"), q.key("processing"), q.key("delayed"), q.key("tokens")},
+		job.ID, runAt.UnixMilli(), job.LastError, job.token).Err()
+	if err != nil {
+		// * 失败时任务留在 processing 中, 超时后重新投递
+		slogx.Default.Error(context.Background(), "failed to retry job",
+			"queue", q.name, "id", job.ID, "error", err.Error())
+	}
+}
+
+func (q *Queue) bury(job *Job, cause error) {
+	n, err := moveScript.Run(context.Background(), q.rds,
+		[]string{q.key("errors"), q.key("processing"), q.key("dead"), q.key("tokens")},
+		job.ID, time.Now().UnixMilli(), job.LastError, job.token).Int()
+	if err != nil {
+		slogx.Default.Error(context.Background(), "failed to bury job",
+			"queue", q.name, "id", job.ID, "error", err.Error())
+		return
+	}
+	if n == 1 && q.deadLetter != nil {
+		threadingx.RunSafe(func() {
+			q.deadLetter(job, cause)
+		})
+	}
+}
+
+// * 第 n 次失败后的重试间隔
+func (q *Queue) delay(attempts int) time.Duration {
+	i := attempts - 1
+	if i >= len(q.backoff) {
+		i = len(q.backoff) - 1
+	}
+	if i < 0 {
+		i = 0
+	}
+
+	return q.backoff[i]
+}
+
+// * 使用 {name} 作为 hash tag, 所有 key 位于同一个 slot
+func (q *Queue) key(kind string) string {
+	return "queue:{" + q.name + "}:" + kind
+}
+
+func decodeJob(id, data string) (*Job, error) {
+	var job Job
+	if err := json.Unmarshal([]byte(data), &job); err != nil {
+		return nil, err
+	}
+	job.ID = id
+
+	return &job, nil
+}
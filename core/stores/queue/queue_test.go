@@ -0,0 +1,242 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestQueue(t *testing.T, opts ...QueueOption) (*Queue, *miniredis.Miniredis) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	t.Cleanup(mr.Close)
+
+	opts = append([]QueueOption{WithPollInterval(10 * time.Millisecond)}, opts...)
+	return NewQueue(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "test", opts...), mr
+}
+
+func TestQueueConsume(t *testing.T) {
+	q, _ := newTestQueue(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err := q.EnqueueIn(ctx, []byte("later"), 200*time.Millisecond)
+	assert.NoError(t, err)
+	id, err := q.Enqueue(ctx, []byte("now"))
+	assert.NoError(t, err)
+
+	size, err := q.Size(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, Size{Delayed: 1, Ready: 1}, size)
+
+	var (
+		lock sync.Mutex
+		got  []string
+	)
+	stopped := make(chan struct{})
+	go func() {
+		q.Consume(ctx, 2, func(ctx context.Context, job *Job) error {
+			lock.Lock()
+			got = append(got, string(job.Payload))
+			lock.Unlock()
+			if string(job.Payload) == "now" {
+				assert.Equal(t, id, job.ID)
+				assert.Equal(t, 1, job.Attempts)
+			}
+			return nil
+		})
+		close(stopped)
+	}()
+
+	assert.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(got) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	lock.Lock()
+	assert.Equal(t, []string{"now", "later"}, got)
+	lock.Unlock()
+
+	size, err = q.Size(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, Size{}, size)
+
+	cancel()
+	<-stopped
+}
+
+func TestQueueRetryAndDeadLetter(t *testing.T) {
+	dead := make(chan *Job, 1)
+	q, _ := newTestQueue(t, WithRetry(2, 10*time.Millisecond),
+		WithDeadLetter(func(job *Job, err error) {
+			dead <- job
+		}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	id, err := q.Enqueue(ctx, []byte("job"))
+	assert.NoError(t, err)
+
+	var calls int32
+	go q.Consume(ctx, 1, func(ctx context.Context, job *Job) error {
+		atomic.AddInt32(&calls, 1)
+		return errors.New("boom")
+	})
+
+	select {
+	case job := <-dead:
+		assert.Equal(t, id, job.ID)
+		assert.Equal(t, 3, job.Attempts)
+	case <-time.After(5 * time.Second):
+		t.Fatal("job not moved to dead letter")
+	}
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	jobs, err := q.DeadJobs(ctx, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(jobs))
+	assert.Equal(t, "boom", jobs[0].LastError)
+	assert.Equal(t, 3, jobs[0].Attempts)
+	assert.Equal(t, []byte("job"), jobs[0].Payload)
+
+	cancel()
+	ok, err := q.Requeue(context.Background(), id)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	size, err := q.Size(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, Size{Ready: 1}, size)
+}
+
+func TestQueueVisibilityTimeout(t *testing.T) {
+	q, _ := newTestQueue(t, WithVisibilityTimeout(50*time.Millisecond))
+	ctx := context.Background()
+
+	id, err := q.Enqueue(ctx, []byte("job"))
+	assert.NoError(t, err)
+
+	// * 预留后未确认, 超时后重新投递
+	job, err := q.reserve(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, id, job.ID)
+	job, err = q.reserve(ctx)
+	assert.NoError(t, err)
+	assert.Nil(t, job)
+
+	time.Sleep(60 * time.Millisecond)
+	job, err = q.reserve(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, id, job.ID)
+	assert.Equal(t, 2, job.Attempts)
+
+	assert.NoError(t, q.Delete(ctx, id))
+	size, err := q.Size(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, Size{}, size)
+}
+
+func TestQueueHandlerPanic(t *testing.T) {
+	q, _ := newTestQueue(t, WithRetry(0))
+	ctx := context.Background()
+
+	_, err := q.Enqueue(ctx, []byte("job"))
+	assert.NoError(t, err)
+	job, err := q.reserve(ctx)
+	assert.NoError(t, err)
+
+	q.process(ctx, job, func(ctx context.Context, job *Job) error {
+		panic("boom")
+	})
+	jobs, err := q.DeadJobs(ctx, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(jobs))
+	assert.Contains(t, jobs[0].LastError, "boom")
+}
+
+func TestQueueReservationToken(t *testing.T) {
+	q, _ := newTestQueue(t, WithVisibilityTimeout(50*time.Millisecond))
+	ctx := context.Background()
+
+	id, err := q.Enqueue(ctx, []byte("job"))
+	assert.NoError(t, err)
+	stale, err := q.reserve(ctx)
+	assert.NoError(t, err)
+
+	// * 超时后重新投递, 旧的预留不能再确认、重试或延长
+	time.Sleep(60 * time.Millisecond)
+	job, err := q.reserve(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, id, job.ID)
+
+	q.ack(stale)
+	stale.LastError = "stale"
+	q.retry(stale)
+	ok, err := q.extend(stale, time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	size, err := q.Size(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, Size{Processing: 1}, size)
+
+	q.ack(job)
+	size, err = q.Size(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, Size{}, size)
+}
+
+func TestQueueKeepAlive(t *testing.T) {
+	q, mr := newTestQueue(t, WithVisibilityTimeout(60*time.Millisecond))
+	ctx := context.Background()
+
+	_, err := q.Enqueue(ctx, []byte("job"))
+	assert.NoError(t, err)
+	job, err := q.reserve(ctx)
+	assert.NoError(t, err)
+
+	// * 处理时间超过可见性超时, 期间不会重新投递
+	assert.NoError(t, q.handle(ctx, job, func(ctx context.Context, job *Job) error {
+		time.Sleep(150 * time.Millisecond)
+		other, err := q.reserve(ctx)
+		assert.NoError(t, err)
+		assert.Nil(t, other)
+		return ctx.Err()
+	}))
+
+	// * 预留丢失后取消 handler 的 ctx
+	err = q.handle(ctx, job, func(ctx context.Context, job *Job) error {
+		mr.HDel(q.key("tokens"), job.ID)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+			return nil
+		}
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestQueueTinyVisibility(t *testing.T) {
+	q, _ := newTestQueue(t, WithVisibilityTimeout(time.Nanosecond))
+	ctx := context.Background()
+
+	_, err := q.Enqueue(ctx, []byte("job"))
+	assert.NoError(t, err)
+	job, err := q.reserve(ctx)
+	assert.NoError(t, err)
+
+	// * 延长间隔不会为 0, NewTicker 不会 panic
+	done := make(chan struct{})
+	time.AfterFunc(10*time.Millisecond, func() {
+		close(done)
+	})
+	assert.NotPanics(t, func() {
+		q.keepAlive(job, func() {}, done)
+	})
+}
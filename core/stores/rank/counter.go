@@ -0,0 +1,140 @@
+package rank
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+/*
+	使用多个 key 分散热点的计数器
+*/
+
+const (
+	defaultCounterPrefixKey = "COUNTER:"
+	defaultShards           = 8
+)
+
+type (
+	CounterOptions struct {
+		PrefixKey string
+		Redis     redis.UniversalClient
+		Shards    int           // * 分片数, 默认 8
+		Expire    time.Duration // * 按 Expire 分段计数, 同一段的分片在段结束时一起过期, 0 不过期
+	}
+
+	SetCounterOptions func(*CounterOptions)
+
+	// A Counter spreads the increments of a hot counter over several keys,
+	// the value is the sum of all the shards.
+	// With Expire, the counter counts in the windows of Expire, e.g. the views of the current hour.
+	Counter struct {
+		options CounterOptions
+		rds     redis.UniversalClient
+		name    string
+		at      time.Time // * 为零时使用当前时间所在的分段
+	}
+)
+
+func NewCounter(name string, opts ...SetCounterOptions) *Counter {
+	options := CounterOptions{
+		PrefixKey: defaultCounterPrefixKey,
+		Shards:    defaultShards,
+	}
+	for _, v := range opts {
+		v(&options)
+	}
+	if options.Shards <= 0 {
+		options.Shards = 1
+	}
+
+	return &Counter{
+		options: options,
+		rds:     options.Redis,
+		name:    name,
+	}
+}
+
+// Incr adds delta to a random shard of the counter,
+// the shard expires at the end of the current window so all the shards of the window expire together.
+func (c *Counter) Incr(ctx context.Context, delta int64) error {
+	// * 只操作写入的分片, 过期时间由分段决定, 不需要刷新其他分片
+	window, end := c.window()
+	key := c.key(window, rand.Intn(c.options.Shards))
+	if c.options.Expire <= 0 {
+		return c.rds.IncrBy(ctx, key, delta).Err()
+	}
+
+	pipe := c.rds.TxPipeline()
+	pipe.IncrBy(ctx, key, delta)
+	pipe.ExpireAt(ctx, key, end)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Value returns the sum of all the shards of the current window.
+func (c *Counter) Value(ctx context.Context) (int64, error) {
+	// * 分片在集群中可能位于不同节点, 不使用 MGET
+	window, _ := c.window()
+	pipe := c.rds.Pipeline()
+	cmds := make([]*redis.StringCmd, c.options.Shards)
+	for i := range cmds {
+		cmds[i] = pipe.Get(ctx, c.key(window, i))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return 0, err
+	}
+
+	var sum int64
+	for _, cmd := range cmds {
+		n, err := cmd.Int64()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		sum += n
+	}
+
+	return sum, nil
+}
+
+// Reset deletes all the shards of the current window.
+func (c *Counter) Reset(ctx context.Context) error {
+	window, _ := c.window()
+	pipe := c.rds.Pipeline()
+	for i := 0; i < c.options.Shards; i++ {
+		pipe.Del(ctx, c.key(window, i))
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// * 当前时间所在的分段和分段的结束时间, 不过期时没有分段
+func (c *Counter) window() (int64, time.Time) {
+	if c.options.Expire <= 0 {
+		return 0, time.Time{}
+	}
+
+	now := c.at
+	if now.IsZero() {
+		now = time.Now()
+	}
+	window := now.UnixNano() / int64(c.options.Expire)
+	return window, time.Unix(0, (window+1)*int64(c.options.Expire))
+}
+
+// * COUNTER:<name>:<shard> 或分段的 COUNTER:<name>:<window>:<shard>
+func (c *Counter) key(window int64, shard int) string {
+	key := c.options.PrefixKey + c.name + ":"
+	if c.options.Expire > 0 {
+		key += strconv.FormatInt(window, 10) + ":"
+	}
+
+	return key + strconv.Itoa(shard)
+}
@@ -0,0 +1,90 @@
+package rank
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCounter(t *testing.T) {
+	rds, mr := newTestRedis(t)
+	c := NewCounter("views", func(o *CounterOptions) {
+		o.Redis = rds
+		o.Shards = 4
+		o.Expire = time.Minute
+	})
+	ctx := context.Background()
+	now := time.Date(2023, 7, 10, 15, 0, 10, 0, time.UTC)
+	mr.SetTime(now)
+	c.at = now
+
+	n, err := c.Value(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), n)
+
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, c.Incr(ctx, 2))
+	}
+	n, err = c.Value(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(200), n)
+	assert.LessOrEqual(t, len(mr.Keys()), 4)
+	// * 同一分段的分片在分段结束时一起过期
+	for _, key := range mr.Keys() {
+		assert.Equal(t, 50*time.Second, mr.TTL(key))
+	}
+
+	// * 写入只设置写入分片的过期时间, 不改变分段的结束时间
+	now = now.Add(40 * time.Second)
+	mr.SetTime(now)
+	mr.FastForward(40 * time.Second)
+	c.at = now
+	assert.NoError(t, c.Incr(ctx, 1))
+	for _, key := range mr.Keys() {
+		assert.Equal(t, 10*time.Second, mr.TTL(key))
+	}
+	n, err = c.Value(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(201), n)
+
+	// * 下一个分段重新计数
+	now = now.Add(10 * time.Second)
+	mr.SetTime(now)
+	c.at = now
+	n, err = c.Value(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), n)
+	assert.NoError(t, c.Incr(ctx, 1))
+	mr.FastForward(10 * time.Second)
+	n, err = c.Value(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	assert.LessOrEqual(t, len(mr.Keys()), 1)
+
+	assert.NoError(t, c.Reset(ctx))
+	n, err = c.Value(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), n)
+}
+
+func TestCounterNoExpire(t *testing.T) {
+	rds, mr := newTestRedis(t)
+	c := NewCounter("views", func(o *CounterOptions) {
+		o.Redis = rds
+		o.Shards = 2
+	})
+	ctx := context.Background()
+
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, c.Incr(ctx, 1))
+	}
+	n, err := c.Value(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), n)
+	for _, key := range mr.Keys() {
+		assert.Contains(t, []string{"COUNTER:views:0", "COUNTER:views:1"}, key)
+		assert.Equal(t, time.Duration(0), mr.TTL(key))
+	}
+}
@@ -0,0 +1,405 @@
+package rank
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+/*
+	使用 redis 有序集合实现排行榜
+*/
+
+const (
+	defaultPrefixKey = "RANK:"
+	defaultRetention = 7
+)
+
+// * 排行榜的统计周期
+const (
+	// Forever is a leaderboard without buckets.
+	Forever Period = iota
+	// Daily buckets the scores by day.
+	Daily
+	// Weekly buckets the scores by ISO week.
+	Weekly
+)
+
+// ErrMemberNotFound means the member is not on the leaderboard.
+var ErrMemberNotFound = errors.New("rank: member not found")
+
+type (
+	// Period is the length of the buckets of a leaderboard.
+	Period int
+
+	LeaderboardOptions struct {
+		PrefixKey string
+		Redis     redis.UniversalClient
+		Asc       bool           // * 分数低的排在前面
+		Period    Period         // * 按天或按周分桶, 默认不分桶
+		Retention int            // * 分桶结束后保留的周期数, 默认 7
+		Location  *time.Location // * 计算分桶的时区, 默认 time.Local
+	}
+
+	SetLeaderboardOptions func(*LeaderboardOptions)
+
+	// A Member is a member of the leaderboard with its score and rank,
+	// members with the same score share the same rank.
+	Member struct {
+		Member string
+		Score  float64
+		Rank   int64 // * 从 1 开始
+	}
+
+	// A Leaderboard ranks the members by their scores in a redis sorted set.
+	Leaderboard struct {
+		options LeaderboardOptions
+		rds     redis.UniversalClient
+		name    string
+		at      time.Time // * 为零时使用当前时间所在的分桶
+		key     string    // * 不为空时固定使用该 key, 如合并后的排行榜
+	}
+)
+
+func NewLeaderboard(name string, opts ...SetLeaderboardOptions) *Leaderboard {
+	options := LeaderboardOptions{
+		PrefixKey: defaultPrefixKey,
+		Retention: defaultRetention,
+		Location:  time.Local,
+	}
+	for _, v := range opts {
+		v(&options)
+	}
+
+	return &Leaderboard{
+		options: options,
+		rds:     options.Redis,
+		name:    name,
+	}
+}
+
+// At returns the leaderboard of the bucket t belongs to.
+func (l *Leaderboard) At(t time.Time) *Leaderboard {
+	board := *l
+	board.at = t
+	return &board
+}
+
+// Key returns the redis key of the current bucket.
+func (l *Leaderboard) Key() string {
+	return l.keyAt(l.now())
+}
+
+// Incr adds delta to the score of member and returns the new score.
+func (l *Leaderboard) Incr(ctx context.Context, member string, delta float64) (float64, error) {
+	// * 在同一个时间计算 key 和过期时间, 避免跨过分桶边界时设置到另一个分桶上
+	now := l.now()
+	key := l.keyAt(now)
+	pipe := l.rds.TxPipeline()
+	score := pipe.ZIncrBy(ctx, key, delta, member)
+	l.expire(ctx, pipe, key, now)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+
+	return score.Val(), nil
+}
+
+// SetScore sets the score of member.
+func (l *Leaderboard) SetScore(ctx context.Context, member string, score float64) error {
+	now := l.now()
+	key := l.keyAt(now)
+	pipe := l.rds.TxPipeline()
+	pipe.ZAdd(ctx, key, redis.Z{Score: score, Member: member})
+	l.expire(ctx, pipe, key, now)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Remove removes members from the leaderboard.
+func (l *Leaderboard) Remove(ctx context.Context, members ...string) error {
+	if len(members) == 0 {
+		return nil
+	}
+
+	args := make([]interface{}, len(members))
+	for i, member := range members {
+		args[i] = member
+	}
+
+	return l.rds.ZRem(ctx, l.Key(), args...).Err()
+}
+
+// Len returns the number of members.
+func (l *Leaderboard) Len(ctx context.Context) (int64, error) {
+	return l.rds.ZCard(ctx, l.Key()).Result()
+}
+
+// Score returns the score of member, ErrMemberNotFound if it's not on the leaderboard.
+func (l *Leaderboard) Score(ctx context.Context, member string) (float64, error) {
+	score, err := l.rds.ZScore(ctx, l.Key(), member).Result()
+	if errors.Is(err, redis.Nil) {
+		return 0, ErrMemberNotFound
+	}
+
+	return score, err
+}
+
+// Rank returns member with its score and rank, ErrMemberNotFound if it's not on the leaderboard.
+func (l *Leaderboard) Rank(ctx context.Context, member string) (Member, error) {
+	score, err := l.Score(ctx, member)
+	if err != nil {
+		return Member{}, err
+	}
+
+	rank, err := l.rankOf(ctx, score)
+	if err != nil {
+		return Member{}, err
+	}
+
+	return Member{Member: member, Score: score, Rank: rank}, nil
+}
+
+// Top returns the top n members, the members tied with the last one are also returned,
+// so the result may be longer than n.
+func (l *Leaderboard) Top(ctx context.Context, n int64) ([]Member, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+
+	zs, err := l.rangeWithScores(ctx, 0, n-1)
+	if err != nil || int64(len(zs)) < n {
+		return toMembers(zs, 0, 1), err
+	}
+
+	// * 追加与最后一名同分的成员
+	last := zs[len(zs)-1].Score
+	ties, err := l.rangeByScore(ctx, last)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]struct{}, len(zs))
+	for _, z := range zs {
+		seen[z.Member.(string)] = struct{}{}
+	}
+	for _, z := range ties {
+		if _, ok := seen[z.Member.(string)]; !ok {
+			zs = append(zs, z)
+		}
+	}
+
+	return toMembers(zs, 0, 1), nil
+}
+
+// Range returns count members from offset, for paging the leaderboard.
+func (l *Leaderboard) Range(ctx context.Context, offset, count int64) ([]Member, error) {
+	if count <= 0 {
+		return nil, nil
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	zs, err := l.rangeWithScores(ctx, offset, offset+count-1)
+	if err != nil || len(zs) == 0 {
+		return nil, err
+	}
+
+	rank, err := l.rankOf(ctx, zs[0].Score)
+	if err != nil {
+		return nil, err
+	}
+
+	return toMembers(zs, offset, rank), nil
+}
+
+// Around returns the members around member, before members ahead of it and after members behind it.
+func (l *Leaderboard) Around(ctx context.Context, member string, before, after int64) ([]Member, error) {
+	var index int64
+	var err error
+	if l.options.Asc {
+		index, err = l.rds.ZRank(ctx, l.Key(), member).Result()
+	} else {
+		index, err = l.rds.ZRevRank(ctx, l.Key(), member).Result()
+	}
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrMemberNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	start := index - before
+	if start < 0 {
+		start = 0
+	}
+	if after < 0 {
+		after = 0
+	}
+
+	return l.Range(ctx, start, index+after-start+1)
+}
+
+// Merge saves the union of the last periods buckets, including the current one, to the key of name,
+// the key is under a merge segment so it never collides with the buckets, e.g. RANK:{<board>}:merge:<name>,
+// and shares the hash tag with the buckets so it works in cluster mode. It returns a leaderboard of it which expires after expire, e.g. the scores of the last 7 days.
+func (l *Leaderboard) Merge(ctx context.Context, name string, periods int,
+	expire time.Duration) (*Leaderboard, error) {
+	if l.options.Period == Forever || len(l.key) > 0 {
+		return nil, fmt.Errorf("rank: leaderboard %s has no buckets to merge", l.name)
+	}
+	if periods <= 0 {
+		periods = 1
+	}
+
+	now := l.now()
+	keys := make([]string, periods)
+	for i := range keys {
+		keys[i] = l.bucketKey(l.shift(now, -i))
+	}
+
+	merged := &Leaderboard{
+		options: l.options,
+		rds:     l.rds,
+		name:    name,
+		key:     l.baseKey() + ":merge:" + name,
+	}
+	pipe := l.rds.TxPipeline()
+	pipe.ZUnionStore(ctx, merged.key, &redis.ZStore{Keys: keys, Aggregate: "SUM"})
+	if expire > 0 {
+		pipe.Expire(ctx, merged.key, expire)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	return merged, nil
+}
+
+func (l *Leaderboard) now() time.Time {
+	t := l.at
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	return t.In(l.options.Location)
+}
+
+// * 所有分桶使用同一个 hash tag, 集群模式下也可以合并
+func (l *Leaderboard) baseKey() string {
+	return l.options.PrefixKey + "{" + l.name + "}"
+}
+
+// * t 所在分桶的 key, 固定了 key 时返回该 key
+func (l *Leaderboard) keyAt(t time.Time) string {
+	if len(l.key) > 0 {
+		return l.key
+	}
+
+	return l.bucketKey(t)
+}
+
+// * RANK:{<name>}:20061102 或 RANK:{<name>}:2006W44
+func (l *Leaderboard) bucketKey(t time.Time) string {
+	key := l.baseKey()
+	switch l.options.Period {
+	case Daily:
+		return key + ":" + t.Format("20060102")
+	case Weekly:
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%s:%dW%02d", key, year, week)
+	default:
+		return key
+	}
+}
+
+// * now 所在的分桶 key 在结束后保留 Retention 个周期
+func (l *Leaderboard) expire(ctx context.Context, pipe redis.Pipeliner, key string, now time.Time) {
+	if l.options.Period == Forever || len(l.key) > 0 {
+		return
+	}
+
+	end := l.start(l.shift(now, 1))
+	retention := l.options.Retention
+	if retention < 0 {
+		retention = 0
+	}
+	pipe.ExpireAt(ctx, key, l.start(l.shift(end, retention)))
+}
+
+// * 分桶的开始时间
+func (l *Leaderboard) start(t time.Time) time.Time {
+	t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	if l.options.Period == Weekly {
+		// * ISO 周从周一开始
+		offset := (int(t.Weekday()) + 6) % 7
+		t = t.AddDate(0, 0, -offset)
+	}
+
+	return t
+}
+
+func (l *Leaderboard) shift(t time.Time, periods int) time.Time {
+	if l.options.Period == Weekly {
+		return t.AddDate(0, 0, 7*periods)
+	}
+
+	return t.AddDate(0, 0, periods)
+}
+
+// * 分数更高(升序时更低)的成员数 + 1
+func (l *Leaderboard) rankOf(ctx context.Context, score float64) (int64, error) {
+	s := strconv.FormatFloat(score, 'f', -1, 64)
+	var count int64
+	var err error
+	if l.options.Asc {
+		count, err = l.rds.ZCount(ctx, l.Key(), "-inf", "("+s).Result()
+	} else {
+		count, err = l.rds.ZCount(ctx, l.Key(), "("+s, "+inf").Result()
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return count + 1, nil
+}
+
+func (l *Leaderboard) rangeWithScores(ctx context.Context, start, stop int64) ([]redis.Z, error) {
+	if l.options.Asc {
+		return l.rds.ZRangeWithScores(ctx, l.Key(), start, stop).Result()
+	}
+
+	return l.rds.ZRevRangeWithScores(ctx, l.Key(), start, stop).Result()
+}
+
+func (l *Leaderboard) rangeByScore(ctx context.Context, score float64) ([]redis.Z, error) {
+	s := strconv.FormatFloat(score, 'f', -1, 64)
+	by := &redis.ZRangeBy{Min: s, Max: s}
+	if l.options.Asc {
+		return l.rds.ZRangeByScoreWithScores(ctx, l.Key(), by).Result()
+	}
+
+	return l.rds.ZRevRangeByScoreWithScores(ctx, l.Key(), by).Result()
+}
+
+// * zs 从第 offset 名开始, 第一个成员的排名为 rank, 同分的成员排名相同,
+// * 新分数的第一个成员前面的都比它分数高, 排名为 offset + i + 1
+func toMembers(zs []redis.Z, offset, rank int64) []Member {
+	members := make([]Member, len(zs))
+	for i, z := range zs {
+		if i > 0 && z.Score != zs[i-1].Score {
+			rank = offset + int64(i) + 1
+		}
+		members[i] = Member{
+			Member: z.Member.(string),
+			Score:  z.Score,
+			Rank:   rank,
+		}
+	}
+
+	return members
+}
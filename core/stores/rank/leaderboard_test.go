@@ -0,0 +1,208 @@
+package rank
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRedis(t *testing.T) (redis.UniversalClient, *miniredis.Miniredis) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	t.Cleanup(mr.Close)
+
+	return redis.NewClient(&redis.Options{Addr: mr.Addr()}), mr
+}
+
+func newTestLeaderboard(t *testing.T, opts ...SetLeaderboardOptions) (*Leaderboard, *miniredis.Miniredis) {
+	rds, mr := newTestRedis(t)
+	opts = append([]SetLeaderboardOptions{func(o *LeaderboardOptions) {
+		o.Redis = rds
+		o.Location = time.UTC
+	}}, opts...)
+
+	return NewLeaderboard("test", opts...), mr
+}
+
+func names(members []Member) []string {
+	var ns []string
+	for _, m := range members {
+		ns = append(ns, m.Member)
+	}
+	return ns
+}
+
+func ranks(members []Member) []int64 {
+	var rs []int64
+	for _, m := range members {
+		rs = append(rs, m.Rank)
+	}
+	return rs
+}
+
+func TestLeaderboardRank(t *testing.T) {
+	l, _ := newTestLeaderboard(t)
+	ctx := context.Background()
+
+	for member, score := range map[string]float64{"a": 10, "b": 8, "c": 8, "d": 5, "e": 1} {
+		assert.NoError(t, l.SetScore(ctx, member, score))
+	}
+	score, err := l.Incr(ctx, "e", 4)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(5), score)
+
+	m, err := l.Rank(ctx, "c")
+	assert.NoError(t, err)
+	assert.Equal(t, Member{Member: "c", Score: 8, Rank: 2}, m)
+	m, err = l.Rank(ctx, "e")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), m.Rank)
+
+	_, err = l.Rank(ctx, "x")
+	assert.ErrorIs(t, err, ErrMemberNotFound)
+	_, err = l.Score(ctx, "x")
+	assert.ErrorIs(t, err, ErrMemberNotFound)
+
+	n, err := l.Len(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+	assert.NoError(t, l.Remove(ctx, "a"))
+	m, err = l.Rank(ctx, "b")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), m.Rank)
+}
+
+func TestLeaderboardTop(t *testing.T) {
+	l, _ := newTestLeaderboard(t)
+	ctx := context.Background()
+
+	for member, score := range map[string]float64{"a": 10, "b": 8, "c": 8, "d": 8, "e": 1} {
+		assert.NoError(t, l.SetScore(ctx, member, score))
+	}
+
+	// * 第 2 名同分的成员都返回
+	top, err := l.Top(ctx, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "d", "c", "b"}, names(top))
+	assert.Equal(t, []int64{1, 2, 2, 2}, ranks(top))
+
+	top, err = l.Top(ctx, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1, 2, 2, 2, 5}, ranks(top))
+
+	top, err = l.Top(ctx, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, top)
+}
+
+func TestLeaderboardAround(t *testing.T) {
+	l, _ := newTestLeaderboard(t)
+	ctx := context.Background()
+
+	for member, score := range map[string]float64{"a": 10, "b": 8, "c": 8, "d": 5, "e": 1} {
+		assert.NoError(t, l.SetScore(ctx, member, score))
+	}
+
+	around, err := l.Around(ctx, "d", 1, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b", "d", "e"}, names(around))
+	assert.Equal(t, []int64{2, 4, 5}, ranks(around))
+
+	around, err = l.Around(ctx, "a", 2, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "c"}, names(around))
+
+	page, err := l.Range(ctx, 2, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b", "d"}, names(page))
+	assert.Equal(t, []int64{2, 4}, ranks(page))
+
+	_, err = l.Around(ctx, "x", 1, 1)
+	assert.ErrorIs(t, err, ErrMemberNotFound)
+}
+
+func TestLeaderboardAsc(t *testing.T) {
+	l, _ := newTestLeaderboard(t, func(o *LeaderboardOptions) {
+		o.Asc = true
+	})
+	ctx := context.Background()
+
+	for member, score := range map[string]float64{"a": 30, "b": 10, "c": 20} {
+		assert.NoError(t, l.SetScore(ctx, member, score))
+	}
+
+	top, err := l.Top(ctx, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b", "c"}, names(top))
+	m, err := l.Rank(ctx, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), m.Rank)
+}
+
+func TestLeaderboardBuckets(t *testing.T) {
+	l, mr := newTestLeaderboard(t, func(o *LeaderboardOptions) {
+		o.Period = Daily
+		o.Retention = 2
+	})
+	ctx := context.Background()
+	now := time.Date(2023, 7, 10, 15, 0, 0, 0, time.UTC)
+	mr.SetTime(now)
+
+	for i := 0; i < 3; i++ {
+		day := l.At(now.AddDate(0, 0, -i))
+		_, err := day.Incr(ctx, "a", 1)
+		assert.NoError(t, err)
+		_, err = day.Incr(ctx, "b", float64(i))
+		assert.NoError(t, err)
+	}
+
+	today := l.At(now)
+	assert.Equal(t, "RANK:{test}:20230710", today.Key())
+	// * 当天结束后再保留 2 天
+	assert.Equal(t, 57*time.Hour, mr.TTL(today.Key()))
+
+	merged, err := today.Merge(ctx, "last2days", 2, time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, "RANK:{test}:merge:last2days", merged.Key())
+	top, err := merged.Top(ctx, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []Member{{"a", 2, 1}, {"b", 1, 2}}, top)
+
+	merged, err = today.Merge(ctx, "last3days", 3, time.Hour)
+	assert.NoError(t, err)
+	m, err := merged.Rank(ctx, "b")
+	assert.NoError(t, err)
+	assert.Equal(t, Member{"b", 3, 1}, m)
+
+	_, err = merged.Merge(ctx, "again", 2, time.Hour)
+	assert.Error(t, err)
+
+	// * 合并的名称和分桶相同时不覆盖分桶
+	_, err = today.Merge(ctx, "20230709", 3, time.Hour)
+	assert.NoError(t, err)
+	score, err := l.At(now.AddDate(0, 0, -1)).Score(ctx, "b")
+	assert.NoError(t, err)
+	assert.Equal(t, float64(1), score)
+}
+
+func TestLeaderboardWeekly(t *testing.T) {
+	l, mr := newTestLeaderboard(t, func(o *LeaderboardOptions) {
+		o.Period = Weekly
+		o.Retention = 1
+	})
+	ctx := context.Background()
+	// * 2023-07-12 是周三, 属于第 28 周
+	now := time.Date(2023, 7, 12, 0, 0, 0, 0, time.UTC)
+	mr.SetTime(now)
+
+	week := l.At(now)
+	_, err := week.Incr(ctx, "a", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "RANK:{test}:2023W28", week.Key())
+	// * 周日结束后再保留 1 周
+	assert.Equal(t, (5+7)*24*time.Hour, mr.TTL(week.Key()))
+}
@@ -18,13 +18,13 @@ type (
 	BrowseHistoryOptions struct {
 		PrefixKey string
 		Expire    time.Duration
-		Redis     *redis.Client
+		Redis     redis.UniversalClient
 		Max       int
 	}
 
 	BrowseHistory struct {
 		options BrowseHistoryOptions
-		rds     *redis.Client
+		rds     redis.UniversalClient
 	}
 
 	SetBrowseHistoryOptions func(*BrowseHistoryOptions)
@@ -0,0 +1,342 @@
+package redisx
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/uc1024/f90/core/mathx"
+	"github.com/uc1024/f90/core/slogx"
+	"github.com/uc1024/f90/core/stringx"
+	"github.com/uc1024/f90/core/threadingx"
+)
+
+const (
+	defaultLockTTL           = 10 * time.Second
+	defaultLockRetryDelay    = 50 * time.Millisecond
+	defaultLockMaxRetryDelay = time.Second
+	// * 围栏令牌计数器的后缀
+	fenceSuffix = ":fence"
+	// * 相同 token 的持有次数的后缀, 多个实例共用
+	holdsSuffix = ":holds"
+)
+
+var (
+	// ErrLockNotHeld means the lock is not held by the owner, it's released or expired.
+	ErrLockNotHeld = errors.New("lock not held")
+
+	// * 未被持有时设置锁和持有次数并递增围栏令牌, 被同一个 token 持有时增加持有次数、续期并返回当前的令牌
+	// KEYS[1] 锁, KEYS[2] 围栏令牌, KEYS[3] 持有次数
+	acquireLockScript = redis.NewScript(`
+local owner = redis.call("GET", KEYS[1])
+if not owner then
+    redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+    redis.call("SET", KEYS[3], 1, "PX", ARGV[2])
+    return redis.call("INCR", KEYS[2])
+end
+if owner == ARGV[1] then
+    redis.call("PEXPIRE", KEYS[1], ARGV[2])
+    redis.call("INCR", KEYS[3])
+    redis.call("PEXPIRE", KEYS[3], ARGV[2])
+    local fence = redis.call("GET", KEYS[2])
+    if not fence then
+        fence = redis.call("INCR", KEYS[2])
+    end
+    return tonumber(fence)
+end
+return 0`)
+	// * 只释放自己持有的锁, 所有持有都释放后删除
+	// KEYS[1] 锁, KEYS[2] 持有次数
+	releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) ~= ARGV[1] then
+    return 0
+end
+if redis.call("DECR", KEYS[2]) <= 0 then
+    redis.call("DEL", KEYS[1], KEYS[2])
+end
+return 1`)
+	renewLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+    redis.call("PEXPIRE", KEYS[2], ARGV[2])
+    return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+)
+
+type (
+	// LockOption customizes a Lock.
+	LockOption func(l *Lock)
+
+	// A Lock is a distributed mutex on a redis key, it's owned by a token.
+	// The lock is renewed in background while it's held, so it's only lost if the owner crashes
+	// or can't reach redis within the ttl. Locks with the same token share the ownership,
+	// the holds are counted in redis, the key is deleted after all the holds of all the instances are released.
+	Lock struct {
+		rds           redis.UniversalClient
+		key           string
+		fenceKey      string
+		holdsKey      string
+		token         string
+		ttl           time.Duration
+		retryDelay    time.Duration
+		maxRetryDelay time.Duration
+		unstable      mathx.Unstable
+
+		opLock sync.Mutex // * 串行执行获取和释放, 访问 redis 时不持有 lock
+		lock   sync.Mutex
+		holds  int   // * 重入次数
+		fence  int64 // * 获取锁时的围栏令牌
+		done   chan struct{}
+	}
+)
+
+// WithLockTTL sets the ttl of the lock, it's renewed every ttl/3 while held.
+func WithLockTTL(ttl time.Duration) LockOption {
+	return func(l *Lock) {
+		if ttl > 0 {
+			l.ttl = ttl
+		}
+	}
+}
+
+// WithLockToken sets the owner token, a lock held with the same token can be acquired again.
+func WithLockToken(token string) LockOption {
+	return func(l *Lock) {
+		if len(token) > 0 {
+			l.token = token
+		}
+	}
+}
+
+// WithLockRetry sets the backoff of Acquire, it starts with delay and doubles up to maxDelay.
+func WithLockRetry(delay, maxDelay time.Duration) LockOption {
+	return func(l *Lock) {
+		if delay > 0 {
+			l.retryDelay = delay
+		}
+		if maxDelay >= l.retryDelay {
+			l.maxRetryDelay = maxDelay
+		}
+	}
+}
+
+// NewLock returns a Lock on key with a random token.
+// The key is wrapped in {} if it has no hash tag and rds is not a single node client,
+// so the keys of the lock are in the same slot.
+func NewLock(rds redis.UniversalClient, key string, opts ...LockOption) *Lock {
+	if _, ok := rds.(*redis.Client); !ok && !hasHashTag(key) {
+		key = "{" + key + "}"
+	}
+
+	l := &Lock{
+		rds:           rds,
+		key:           key,
+		fenceKey:      key + fenceSuffix,
+		holdsKey:      key + holdsSuffix,
+		token:         stringx.Rand(),
+		ttl:           defaultLockTTL,
+		retryDelay:    defaultLockRetryDelay,
+		maxRetryDelay: defaultLockMaxRetryDelay,
+		unstable:      mathx.NewUnstable(0.2),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	if l.maxRetryDelay < l.retryDelay {
+		l.maxRetryDelay = l.retryDelay
+	}
+
+	return l
+}
+
+// Key returns the redis key of the lock.
+func (l *Lock) Key() string {
+	return l.key
+}
+
+// Token returns the owner token of the lock.
+func (l *Lock) Token() string {
+	return l.token
+}
+
+// Fence returns the fencing token of the current hold, it increases every time the lock
+// changes its owner, so the downstream storage can reject the writes with an older token.
+func (l *Lock) Fence() int64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.fence
+}
+
+// Held checks if the lock is held by l, as far as l knows.
+func (l *Lock) Held() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.holds > 0
+}
+
+// Done returns a channel that's closed when the lock is released or lost,
+// nil is returned if the lock is not held.
+func (l *Lock) Done() <-chan struct{} {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.done
+}
+
+// TryAcquire acquires the lock once, false is returned if it's held by another owner.
+// Acquiring a held lock again increases the holds, it must be released as many times.
+func (l *Lock) TryAcquire(ctx context.Context) (bool, error) {
+	l.opLock.Lock()
+	defer l.opLock.Unlock()
+
+	fence, err := acquireLockScript.Run(ctx, l.rds, []string{l.key, l.fenceKey, l.holdsKey},
+		l.token, l.ttl.Milliseconds()).Int64()
+	if err != nil {
+		return false, err
+	}
+	if fence == 0 {
+		return false, nil
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.holds++
+	if l.holds == 1 {
+		l.fence = fence
+		l.done = make(chan struct{})
+		l.keepAlive(l.done)
+	}
+
+	return true, nil
+}
+
+// Acquire acquires the lock, it retries with backoff until the lock is acquired or ctx is done.
+func (l *Lock) Acquire(ctx context.Context) error {
+	delay := l.retryDelay
+	for {
+		ok, err := l.TryAcquire(ctx)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+
+		timer := time.NewTimer(l.unstable.AroundDuration(delay))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		if delay *= 2; delay > l.maxRetryDelay {
+			delay = l.maxRetryDelay
+		}
+	}
+}
+
+// Release releases a hold of the lock, the redis key is deleted after the last hold
+// of the token is released. ErrLockNotHeld is returned if the lock is not held by l.
+// The lock is still held and renewed if an error of redis is returned, Release can be retried.
+func (l *Lock) Release(ctx context.Context) error {
+	l.opLock.Lock()
+	defer l.opLock.Unlock()
+
+	if !l.Held() {
+		return ErrLockNotHeld
+	}
+
+	n, err := releaseLockScript.Run(ctx, l.rds, []string{l.key, l.holdsKey}, l.token).Int()
+	if err != nil {
+		return err
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	// * 锁已经过期或被其他 token 持有, 不再持有
+	if n == 0 {
+		l.holds = 0
+	} else if l.holds > 0 {
+		l.holds--
+	}
+	if l.holds == 0 && l.done != nil {
+		close(l.done)
+		l.done = nil
+	}
+	if n == 0 {
+		return ErrLockNotHeld
+	}
+
+	return nil
+}
+
+// * 持有期间每 ttl/3 续期一次, 丢失锁时关闭 done
+func (l *Lock) keepAlive(done chan struct{}) {
+	threadingx.GoSafe(func() {
+		interval := l.ttl / 3
+		if interval <= 0 {
+			interval = l.ttl
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		expireAt := time.Now().Add(l.ttl)
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			ok, err := renewLockScript.Run(context.Background(), l.rds, []string{l.key, l.holdsKey},
+				l.token, l.ttl.Milliseconds()).Int()
+			if err == nil && ok == 1 {
+				expireAt = time.Now().Add(l.ttl)
+				continue
+			}
+			if err != nil {
+				slogx.Default.Error(context.Background(), "failed to renew lock",
+					"key", l.key, "error", err.Error())
+				// * redis 暂时不可用时, 在锁过期前继续重试
+				if time.Now().Before(expireAt) {
+					continue
+				}
+			}
+
+			l.lost(done)
+			return
+		}
+	})
+}
+
+func (l *Lock) lost(done chan struct{}) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	// * 已经释放或重新获取
+	if l.done != done {
+		return
+	}
+
+	l.holds = 0
+	close(l.done)
+	l.done = nil
+}
+
+// * key 中是否有非空的 {hash tag}
+func hasHashTag(key string) bool {
+	start := strings.IndexByte(key, '{')
+	if start < 0 {
+		return false
+	}
+	end := strings.IndexByte(key[start+1:], '}')
+	return end > 0
+}
@@ -0,0 +1,172 @@
+package redisx
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLock(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	rds := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	ctx := context.Background()
+
+	a := NewLock(rds, "job")
+	b := NewLock(rds, "job")
+	assert.NoError(t, a.Acquire(ctx))
+	assert.True(t, a.Held())
+	assert.Equal(t, int64(1), a.Fence())
+
+	ok, err := b.TryAcquire(ctx)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.ErrorIs(t, b.Release(ctx), ErrLockNotHeld)
+
+	// * 重入需要释放相同的次数
+	assert.NoError(t, a.Acquire(ctx))
+	assert.Equal(t, int64(1), a.Fence())
+	assert.NoError(t, a.Release(ctx))
+	assert.True(t, mr.Exists("job"))
+	done := a.Done()
+	assert.NoError(t, a.Release(ctx))
+	assert.False(t, mr.Exists("job"))
+	assert.False(t, a.Held())
+	<-done
+
+	assert.NoError(t, b.Acquire(ctx))
+	assert.Equal(t, int64(2), b.Fence())
+	assert.NoError(t, b.Release(ctx))
+}
+
+func TestLockToken(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	rds := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	ctx := context.Background()
+
+	a := NewLock(rds, "job", WithLockToken("owner"))
+	assert.NoError(t, a.Acquire(ctx))
+	defer a.Release(ctx)
+
+	// * 相同 token 的锁共享所有权
+	same := NewLock(rds, "job", WithLockToken("owner"))
+	ok, err := same.TryAcquire(ctx)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, a.Fence(), same.Fence())
+
+	// * 其他实例释放后, 锁仍然被持有
+	assert.NoError(t, same.Release(ctx))
+	assert.True(t, mr.Exists("job"))
+	ok, err = NewLock(rds, "job").TryAcquire(ctx)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestLockCluster(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	rds := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{mr.Addr()}})
+	ctx := context.Background()
+
+	// * 锁的 key 在同一个 slot
+	a := NewLock(rds, "job")
+	assert.Equal(t, "{job}", a.Key())
+	assert.NoError(t, a.Acquire(ctx))
+	assert.True(t, mr.Exists("{job}"))
+	assert.True(t, mr.Exists("{job}:fence"))
+	assert.True(t, mr.Exists("{job}:holds"))
+	assert.NoError(t, a.Release(ctx))
+	assert.False(t, mr.Exists("{job}"))
+
+	assert.Equal(t, "user:{1}:job", NewLock(rds, "user:{1}:job").Key())
+}
+
+func TestLockAcquireTimeout(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	rds := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	a := NewLock(rds, "job")
+	assert.NoError(t, a.Acquire(context.Background()))
+	defer a.Release(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	b := NewLock(rds, "job", WithLockRetry(10*time.Millisecond, 20*time.Millisecond))
+	assert.ErrorIs(t, b.Acquire(ctx), context.DeadlineExceeded)
+}
+
+func TestLockKeepAlive(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	rds := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	ctx := context.Background()
+
+	a := NewLock(rds, "job", WithLockTTL(300*time.Millisecond))
+	assert.NoError(t, a.Acquire(ctx))
+	assert.Eventually(t, func() bool {
+		// * 续期后过期时间被重置
+		return mr.TTL("job") > 0 && mr.TTL("job") <= 300*time.Millisecond
+	}, time.Second, 10*time.Millisecond)
+
+	// * 锁被其他实例抢占后视为丢失
+	mr.Set("job", "other")
+	select {
+	case <-a.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("lost lock not detected")
+	}
+	assert.False(t, a.Held())
+	assert.ErrorIs(t, a.Release(ctx), ErrLockNotHeld)
+}
+
+func TestLockReleaseError(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	rds := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	ctx := context.Background()
+
+	l := NewLock(rds, "job")
+	assert.NoError(t, l.Acquire(ctx))
+	done := l.Done()
+
+	// * 释放失败时仍然持有锁, 可以再次释放
+	mr.SetError("down")
+	assert.Error(t, l.Release(ctx))
+	assert.True(t, l.Held())
+	select {
+	case <-done:
+		t.Fatal("lock is closed after a failed release")
+	default:
+	}
+
+	mr.SetError("")
+	assert.NoError(t, l.Release(ctx))
+	assert.False(t, l.Held())
+	assert.False(t, mr.Exists("job"))
+	<-done
+
+	// * redis 中已经不再持有时清除本地状态
+	assert.NoError(t, l.Acquire(ctx))
+	assert.NoError(t, l.Acquire(ctx))
+	mr.Del("job")
+	assert.ErrorIs(t, l.Release(ctx), ErrLockNotHeld)
+	assert.False(t, l.Held())
+}
@@ -1,19 +1,213 @@
 package redisx
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
 	"github.com/redis/go-redis/v9"
 )
 
+const (
+	// NodeMode is a standalone redis, it's the default mode.
+	NodeMode = "node"
+	// SentinelMode is a master of redis sentinel, Addrs are the sentinels.
+	SentinelMode = "sentinel"
+	// ClusterMode is a redis cluster, Addrs are the seed nodes.
+	ClusterMode = "cluster"
+
+	defaultPingTimeout = 3 * time.Second
+)
+
+var (
+	// ErrEmptyAddrs means no address is configured.
+	ErrEmptyAddrs = errors.New("redisx: empty addrs")
+	// ErrEmptyMasterName means the master name of sentinel mode is not configured.
+	ErrEmptyMasterName = errors.New("redisx: empty master name in sentinel mode")
+	// ErrUnknownMode means the mode is not one of node, sentinel and cluster.
+	ErrUnknownMode = errors.New("redisx: unknown mode")
+	// ErrClusterIndex means a non-zero Index is configured in cluster mode, which only has db 0.
+	ErrClusterIndex = errors.New("redisx: index is not supported in cluster mode")
+
+	// * sentinel 客户端无法得到 master 名称, 由 NewUniversalRds 记录 Name 的返回值,
+	// * 以客户端 Options 的地址为 key, 不阻止客户端被回收, 回收时删除
+	failoverNames sync.Map
+)
+
 type Config struct {
-	Addrs    string
+	Addrs    string // * 多个地址用逗号分隔
 	Password string
-	Index    int
+	Index    int // * 集群模式不支持
+	Username string
+
+	Mode             string // * node / sentinel / cluster, 默认 node
+	MasterName       string // * sentinel 模式的 master 名称
+	SentinelUsername string
+	SentinelPassword string
+
+	TLS                bool
+	InsecureSkipVerify bool // * 跳过证书校验, 仅用于测试环境
+
+	PoolSize     int // * 0 使用 go-redis 的默认值
+	MinIdleConns int
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	PingTimeout  time.Duration // * NewRds 启动时 PING 的超时, 默认 3s
+}
+
+// Validate checks if the config is complete.
+func (cfg Config) Validate() error {
+	if len(cfg.addrs()) == 0 {
+		return ErrEmptyAddrs
+	}
+
+	switch cfg.mode() {
+	case NodeMode:
+		return nil
+	case ClusterMode:
+		if cfg.Index != 0 {
+			return ErrClusterIndex
+		}
+		return nil
+	case SentinelMode:
+		if len(cfg.MasterName) == 0 {
+			return ErrEmptyMasterName
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownMode, cfg.Mode)
+	}
 }
 
+// NewNodeRds returns a standalone client of the first address in cfg.
 func NewNodeRds(cfg Config) *redis.Client {
-	return redis.NewClient(&redis.Options{
-		Addr:     cfg.Addrs,
-		Password: cfg.Password,
-		DB:       cfg.Index,
-	})
+	o := cfg.universalOptions()
+	o.Addrs = o.Addrs[:1]
+	return redis.NewClient(o.Simple())
+}
+
+// NewUniversalRds returns a client of cfg.Mode without checking the connection.
+func NewUniversalRds(cfg Config) redis.UniversalClient {
+	o := cfg.universalOptions()
+	switch cfg.mode() {
+	case SentinelMode:
+		fo := o.Failover()
+		rds := redis.NewFailoverClient(fo)
+		// * 客户端的对象之间有循环引用, 设置 finalizer 后不会被回收,
+		// * 改为设置在只由客户端引用的 FailoverOptions 上
+		addr := uintptr(unsafe.Pointer(rds.Options()))
+		failoverNames.Store(addr, fmt.Sprintf("%s@%s/%d", cfg.MasterName, strings.Join(o.Addrs, ","), cfg.Index))
+		runtime.SetFinalizer(fo, func(*redis.FailoverOptions) {
+			failoverNames.Delete(addr)
+		})
+		return rds
+	case ClusterMode:
+		return redis.NewClusterClient(o.Cluster())
+	default:
+		o.Addrs = o.Addrs[:1]
+		return redis.NewClient(o.Simple())
+	}
+}
+
+// NewRds returns a client of cfg.Mode after it answers a PING,
+// the error tells the mode and the addresses if redis is not reachable.
+func NewRds(cfg Config) (redis.UniversalClient, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	rds := NewUniversalRds(cfg)
+	timeout := cfg.PingTimeout
+	if timeout <= 0 {
+		timeout = defaultPingTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := rds.Ping(ctx).Err(); err != nil {
+		rds.Close()
+		return nil, fmt.Errorf("redisx: ping %s redis %s failed: %w", cfg.mode(), cfg.Addrs, err)
+	}
+
+	return rds, nil
+}
+
+// Name returns the address and db of rds, e.g. 127.0.0.1:6379/0,
+// the master name and the sentinels of the sentinel clients created by NewUniversalRds, e.g. mymaster@127.0.0.1:26379/0,
+// and the sorted shard addresses of a ring, e.g. 127.0.0.1:6379,127.0.0.1:6380.
+// The other clients are named by their pointers, which change after restarts.
+func Name(rds redis.UniversalClient) string {
+	switch c := rds.(type) {
+	case *redis.Client:
+		if name, ok := failoverNames.Load(uintptr(unsafe.Pointer(c.Options()))); ok {
+			return name.(string)
+		}
+		return fmt.Sprintf("%s/%d", c.Options().Addr, c.Options().DB)
+	case *redis.ClusterClient:
+		return strings.Join(c.Options().Addrs, ",")
+	case *redis.Ring:
+		addrs := make([]string, 0, len(c.Options().Addrs))
+		for _, addr := range c.Options().Addrs {
+			addrs = append(addrs, addr)
+		}
+		sort.Strings(addrs)
+		return strings.Join(addrs, ",")
+	default:
+		return fmt.Sprintf("%p", rds)
+	}
+}
+
+func (cfg Config) mode() string {
+	if len(cfg.Mode) == 0 {
+		return NodeMode
+	}
+
+	return strings.ToLower(cfg.Mode)
+}
+
+func (cfg Config) addrs() []string {
+	var addrs []string
+	for _, addr := range strings.Split(cfg.Addrs, ",") {
+		if addr = strings.TrimSpace(addr); len(addr) > 0 {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	return addrs
+}
+
+func (cfg Config) universalOptions() *redis.UniversalOptions {
+	o := &redis.UniversalOptions{
+		Addrs:            cfg.addrs(),
+		DB:               cfg.Index,
+		Username:         cfg.Username,
+		Password:         cfg.Password,
+		MasterName:       cfg.MasterName,
+		SentinelUsername: cfg.SentinelUsername,
+		SentinelPassword: cfg.SentinelPassword,
+		PoolSize:         cfg.PoolSize,
+		MinIdleConns:     cfg.MinIdleConns,
+		DialTimeout:      cfg.DialTimeout,
+		ReadTimeout:      cfg.ReadTimeout,
+		WriteTimeout:     cfg.WriteTimeout,
+	}
+	if len(o.Addrs) == 0 {
+		o.Addrs = []string{""}
+	}
+	if cfg.TLS {
+		o.TLSConfig = &tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			InsecureSkipVerify: cfg.InsecureSkipVerify,
+		}
+	}
+
+	return o
 }
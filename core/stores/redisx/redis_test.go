@@ -0,0 +1,106 @@
+package redisx
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigValidate(t *testing.T) {
+	assert.ErrorIs(t, Config{}.Validate(), ErrEmptyAddrs)
+	assert.ErrorIs(t, Config{Addrs: " , "}.Validate(), ErrEmptyAddrs)
+	assert.NoError(t, Config{Addrs: "127.0.0.1:6379"}.Validate())
+	assert.NoError(t, Config{Addrs: "a:7000,b:7001", Mode: "Cluster"}.Validate())
+	assert.ErrorIs(t, Config{Addrs: "a:7000,b:7001", Mode: ClusterMode, Index: 1}.Validate(), ErrClusterIndex)
+	assert.ErrorIs(t, Config{Addrs: "a:26379", Mode: SentinelMode}.Validate(), ErrEmptyMasterName)
+	assert.NoError(t, Config{Addrs: "a:26379", Mode: SentinelMode, MasterName: "mymaster"}.Validate())
+	assert.ErrorIs(t, Config{Addrs: "a:6379", Mode: "ring"}.Validate(), ErrUnknownMode)
+}
+
+func TestNewUniversalRds(t *testing.T) {
+	cfg := Config{
+		Addrs:        "a:6379, b:6379",
+		Username:     "user",
+		Password:     "pwd",
+		Index:        2,
+		PoolSize:     20,
+		MinIdleConns: 5,
+		ReadTimeout:  time.Second,
+		TLS:          true,
+	}
+
+	node, ok := NewUniversalRds(cfg).(*redis.Client)
+	assert.True(t, ok)
+	assert.Equal(t, "a:6379", node.Options().Addr)
+	assert.Equal(t, "user", node.Options().Username)
+	assert.Equal(t, 2, node.Options().DB)
+	assert.Equal(t, 20, node.Options().PoolSize)
+	assert.Equal(t, 5, node.Options().MinIdleConns)
+	assert.Equal(t, time.Second, node.Options().ReadTimeout)
+	assert.NotNil(t, node.Options().TLSConfig)
+	assert.Equal(t, "a:6379/2", Name(node))
+
+	cfg.Mode = ClusterMode
+	cluster, ok := NewUniversalRds(cfg).(*redis.ClusterClient)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"a:6379", "b:6379"}, cluster.Options().Addrs)
+	assert.Equal(t, "a:6379,b:6379", Name(cluster))
+
+	cfg.Mode = SentinelMode
+	cfg.MasterName = "mymaster"
+	failover, ok := NewUniversalRds(cfg).(*redis.Client)
+	assert.True(t, ok)
+	assert.Equal(t, "mymaster@a:6379,b:6379/2", Name(failover))
+
+	ring := redis.NewRing(&redis.RingOptions{Addrs: map[string]string{"s2": "b:6379", "s1": "a:6379"}})
+	defer ring.Close()
+	assert.Equal(t, "a:6379,b:6379", Name(ring))
+}
+
+func TestNewRds(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	addr := mr.Addr()
+
+	rds, err := NewRds(Config{Addrs: addr})
+	assert.NoError(t, err)
+	assert.NoError(t, rds.Set(context.Background(), "k", "v", 0).Err())
+	assert.NoError(t, rds.Close())
+
+	mr.Close()
+	_, err = NewRds(Config{Addrs: addr, PingTimeout: 100 * time.Millisecond})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "ping node redis "+addr)
+
+	_, err = NewRds(Config{})
+	assert.ErrorIs(t, err, ErrEmptyAddrs)
+}
+
+func TestFailoverNameCollected(t *testing.T) {
+	count := func() int {
+		var n int
+		failoverNames.Range(func(_, _ interface{}) bool {
+			n++
+			return true
+		})
+		return n
+	}
+
+	before := count()
+	rds := NewUniversalRds(Config{Addrs: "a:26379", Mode: SentinelMode, MasterName: "mymaster"})
+	assert.Equal(t, "mymaster@a:26379/0", Name(rds))
+	assert.Equal(t, before+1, count())
+	assert.NoError(t, rds.Close())
+
+	// * 客户端被回收后删除记录的名称
+	rds = nil
+	assert.Eventually(t, func() bool {
+		runtime.GC()
+		return count() == before
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,335 @@
+package redisx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/uc1024/f90/core/rescue"
+	"github.com/uc1024/f90/core/slogx"
+	"github.com/uc1024/f90/core/threadingx"
+)
+
+const (
+	defaultStreamBlock         = 2 * time.Second
+	defaultStreamCount         = 10
+	defaultStreamMinIdle       = time.Minute
+	defaultStreamClaimInterval = 30 * time.Second
+	// * 读取失败后的等待时间
+	streamRetryInterval = time.Second
+)
+
+// ErrConsumerStarted means Start is called on a running StreamConsumer.
+var ErrConsumerStarted = errors.New("redisx: stream consumer already started")
+
+type (
+	// A StreamHandler processes a message, the message is acked if nil is returned,
+	// otherwise it stays pending and is claimed again after the min idle time.
+	StreamHandler func(ctx context.Context, msg redis.XMessage) error
+
+	// StreamConsumerOption customizes a StreamConsumer.
+	StreamConsumerOption func(c *StreamConsumer)
+
+	// A StreamConsumer consumes a redis stream as a member of a consumer group.
+	StreamConsumer struct {
+		rds           redis.UniversalClient
+		stream        string
+		group         string
+		consumer      string
+		startID       string
+		block         time.Duration
+		count         int64
+		minIdle       time.Duration
+		claimInterval time.Duration
+
+		lock   sync.Mutex
+		cancel context.CancelFunc
+		done   chan struct{}
+	}
+
+	// StreamProducerOption customizes a StreamProducer.
+	StreamProducerOption func(p *StreamProducer)
+
+	// A StreamProducer adds messages to a redis stream.
+	StreamProducer struct {
+		rds    redis.UniversalClient
+		stream string
+		maxLen int64
+		approx bool
+	}
+)
+
+// WithStreamStartID sets the id the group starts from if it's created by the consumer,
+// $ by default which means only the new messages, 0 means all the messages.
+func WithStreamStartID(id string) StreamConsumerOption {
+	return func(c *StreamConsumer) {
+		if len(id) > 0 {
+			c.startID = id
+		}
+	}
+}
+
+// WithStreamBlock sets how long a read blocks and how many messages it reads at most.
+func WithStreamBlock(block time.Duration, count int64) StreamConsumerOption {
+	return func(c *StreamConsumer) {
+		if block > 0 {
+			c.block = block
+		}
+		if count > 0 {
+			c.count = count
+		}
+	}
+}
+
+// WithStreamClaim sets the idle time after which a pending message of other consumers is claimed,
+// and the interval of checking the pending messages.
+func WithStreamClaim(minIdle, interval time.Duration) StreamConsumerOption {
+	return func(c *StreamConsumer) {
+		if minIdle > 0 {
+			c.minIdle = minIdle
+		}
+		if interval > 0 {
+			c.claimInterval = interval
+		}
+	}
+}
+
+// NewStreamConsumer returns a StreamConsumer named consumer in group of stream.
+func NewStreamConsumer(rds redis.UniversalClient, stream, group, consumer string,
+	opts ...StreamConsumerOption) *StreamConsumer {
+	c := &StreamConsumer{
+		rds:           rds,
+		stream:        stream,
+		group:         group,
+		consumer:      consumer,
+		startID:       "$",
+		block:         defaultStreamBlock,
+		count:         defaultStreamCount,
+		minIdle:       defaultStreamMinIdle,
+		claimInterval: defaultStreamClaimInterval,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// Start creates the group if it doesn't exist and consumes the stream with handler in background.
+func (c *StreamConsumer) Start(handler StreamHandler) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.done != nil {
+		return ErrConsumerStarted
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := c.createGroup(ctx); err != nil {
+		cancel()
+		return err
+	}
+
+	c.cancel = cancel
+	c.done = make(chan struct{})
+	done := c.done
+	threadingx.GoSafe(func() {
+		defer close(done)
+		c.loop(ctx, handler)
+	})
+
+	return nil
+}
+
+// Stop stops reading and waits for the running handler to finish,
+// the messages read but not handled stay pending and are handled after restart.
+// It may wait the block time of the running read.
+func (c *StreamConsumer) Stop() {
+	c.lock.Lock()
+	cancel, done := c.cancel, c.done
+	c.cancel, c.done = nil, nil
+	c.lock.Unlock()
+
+	if cancel == nil {
+		return
+	}
+
+	cancel()
+	<-done
+}
+
+func (c *StreamConsumer) createGroup(ctx context.Context) error {
+	err := c.rds.XGroupCreateMkStream(ctx, c.stream, c.group, c.startID).Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+
+	return nil
+}
+
+func (c *StreamConsumer) loop(ctx context.Context, handler StreamHandler) {
+	// * 先处理上次退出时未确认的消息
+	c.read(ctx, "0", handler)
+
+	var lastClaim time.Time
+	for ctx.Err() == nil {
+		if time.Since(lastClaim) >= c.claimInterval {
+			c.claim(ctx, handler)
+			lastClaim = time.Now()
+		}
+
+		c.read(ctx, ">", handler)
+	}
+}
+
+// * id 为 0 时读取自己未确认的消息直到读完, 为 > 时读取一次新消息
+func (c *StreamConsumer) read(ctx context.Context, id string, handler StreamHandler) {
+	for ctx.Err() == nil {
+		args := &redis.XReadGroupArgs{
+			Group:    c.group,
+			Consumer: c.consumer,
+			Streams:  []string{c.stream, id},
+			Count:    c.count,
+			Block:    c.block,
+		}
+		if id != ">" {
+			args.Block = -1
+		}
+
+		streams, err := c.rds.XReadGroup(ctx, args).Result()
+		if errors.Is(err, redis.Nil) {
+			return
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			slogx.Default.Error(ctx, "failed to read stream", "stream", c.stream,
+				"group", c.group, "error", err.Error())
+			// * 消费组被删除时重新创建
+			if strings.HasPrefix(err.Error(), "NOGROUP") {
+				c.createGroup(ctx)
+			}
+			c.sleep(ctx, streamRetryInterval)
+			return
+		}
+
+		var n int
+		for _, stream := range streams {
+			n += len(stream.Messages)
+			for _, msg := range stream.Messages {
+				if ctx.Err() != nil {
+					return
+				}
+				c.handle(handler, msg)
+			}
+		}
+		if id == ">" || n == 0 {
+			return
+		}
+
+		id = streams[0].Messages[n-1].ID
+	}
+}
+
+// * 认领其他消费者超时未确认的消息
+func (c *StreamConsumer) claim(ctx context.Context, handler StreamHandler) {
+	start := "0-0"
+	for ctx.Err() == nil {
+		msgs, next, err := c.rds.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   c.stream,
+			Group:    c.group,
+			Consumer: c.consumer,
+			MinIdle:  c.minIdle,
+			Start:    start,
+			Count:    c.count,
+		}).Result()
+		if err != nil {
+			if ctx.Err() == nil {
+				slogx.Default.Error(ctx, "failed to claim stream", "stream", c.stream,
+					"group", c.group, "error", err.Error())
+			}
+			return
+		}
+
+		for _, msg := range msgs {
+			if ctx.Err() != nil {
+				return
+			}
+			c.handle(handler, msg)
+		}
+		if next == "0-0" || len(next) == 0 {
+			return
+		}
+		start = next
+	}
+}
+
+// * 处理器不受 Stop 的影响, 保证处理完当前消息
+func (c *StreamConsumer) handle(handler StreamHandler, msg redis.XMessage) {
+	ctx := context.Background()
+	if err := c.call(ctx, handler, msg); err != nil {
+		slogx.Default.Error(ctx, "failed to handle stream message", "stream", c.stream,
+			"group", c.group, "id", msg.ID, "error", err.Error())
+		return
+	}
+
+	if err := c.rds.XAck(ctx, c.stream, c.group, msg.ID).Err(); err != nil {
+		slogx.Default.Error(ctx, "failed to ack stream message", "stream", c.stream,
+			"group", c.group, "id", msg.ID, "error", err.Error())
+	}
+}
+
+func (c *StreamConsumer) call(ctx context.Context, handler StreamHandler, msg redis.XMessage) (err error) {
+	defer rescue.CatchError(func() {}, func(p interface{}) {
+		err = fmt.Errorf("stream handler panic: %v", p)
+	})
+
+	return handler(ctx, msg)
+}
+
+func (c *StreamConsumer) sleep(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+// WithStreamMaxLen trims the stream to about maxLen messages on every add,
+// approx uses ~ which is much cheaper but may keep a few more messages.
+func WithStreamMaxLen(maxLen int64, approx bool) StreamProducerOption {
+	return func(p *StreamProducer) {
+		p.maxLen = maxLen
+		p.approx = approx
+	}
+}
+
+// NewStreamProducer returns a StreamProducer of stream.
+func NewStreamProducer(rds redis.UniversalClient, stream string, opts ...StreamProducerOption) *StreamProducer {
+	p := &StreamProducer{
+		rds:    rds,
+		stream: stream,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// Publish adds a message with values to the stream, it returns the id of the message.
+func (p *StreamProducer) Publish(ctx context.Context, values map[string]interface{}) (string, error) {
+	return p.rds.XAdd(ctx, &redis.XAddArgs{
+		Stream: p.stream,
+		MaxLen: p.maxLen,
+		Approx: p.approx,
+		Values: values,
+	}).Result()
+}
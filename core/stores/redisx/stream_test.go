@@ -0,0 +1,117 @@
+package redisx
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamProducer(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	rds := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	p := NewStreamProducer(rds, "events", WithStreamMaxLen(2, false))
+	for i := 0; i < 5; i++ {
+		_, err := p.Publish(context.Background(), map[string]interface{}{"n": i})
+		assert.NoError(t, err)
+	}
+
+	n, err := rds.XLen(context.Background(), "events").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+}
+
+func TestStreamConsumer(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	rds := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	ctx := context.Background()
+	p := NewStreamProducer(rds, "events")
+
+	var (
+		lock sync.Mutex
+		got  []string
+	)
+	c := NewStreamConsumer(rds, "events", "group", "c1", WithStreamBlock(50*time.Millisecond, 10))
+	assert.NoError(t, c.Start(func(ctx context.Context, msg redis.XMessage) error {
+		lock.Lock()
+		defer lock.Unlock()
+		got = append(got, msg.Values["name"].(string))
+		return nil
+	}))
+	assert.ErrorIs(t, c.Start(nil), ErrConsumerStarted)
+
+	for _, name := range []string{"a", "b", "c"} {
+		_, err := p.Publish(ctx, map[string]interface{}{"name": name})
+		assert.NoError(t, err)
+	}
+	assert.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(got) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"a", "b", "c"}, got)
+
+	c.Stop()
+	c.Stop()
+	pending, err := rds.XPending(ctx, "events", "group").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), pending.Count)
+}
+
+func TestStreamConsumerClaim(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	rds := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	ctx := context.Background()
+
+	// * 处理时 panic 的消息保持未确认
+	failed := make(chan string, 1)
+	c1 := NewStreamConsumer(rds, "events", "group", "c1", WithStreamBlock(50*time.Millisecond, 10),
+		WithStreamClaim(time.Hour, time.Hour))
+	assert.NoError(t, c1.Start(func(ctx context.Context, msg redis.XMessage) error {
+		failed <- msg.ID
+		panic("boom")
+	}))
+
+	id, err := NewStreamProducer(rds, "events").Publish(ctx, map[string]interface{}{"name": "a"})
+	assert.NoError(t, err)
+	select {
+	case got := <-failed:
+		assert.Equal(t, id, got)
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not delivered")
+	}
+	c1.Stop()
+
+	claimed := make(chan string, 1)
+	c2 := NewStreamConsumer(rds, "events", "group", "c2", WithStreamBlock(50*time.Millisecond, 10),
+		WithStreamClaim(10*time.Millisecond, 20*time.Millisecond))
+	assert.NoError(t, c2.Start(func(ctx context.Context, msg redis.XMessage) error {
+		claimed <- msg.ID
+		return nil
+	}))
+	defer c2.Stop()
+
+	select {
+	case got := <-claimed:
+		assert.Equal(t, id, got)
+	case <-time.After(5 * time.Second):
+		t.Fatal("pending message not claimed")
+	}
+	assert.Eventually(t, func() bool {
+		pending, err := rds.XPending(ctx, "events", "group").Result()
+		return err == nil && pending.Count == 0
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,195 @@
+package throttlex
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/uc1024/f90/core/collection"
+	"github.com/uc1024/f90/core/slogx"
+	"github.com/uc1024/f90/core/threadingx"
+)
+
+const (
+	defaultProbeInterval = time.Millisecond * 100
+	defaultFallbackLimit = 100000 // * 本地最多保存的 key 数量
+	fallbackPingTimeout  = time.Second
+)
+
+// * 脚本自身的错误, 其他错误都视为 redis 不可用
+var scriptErrorPrefixes = []string{
+	"NOSCRIPT",
+	"WRONGTYPE",
+	"ERR Error running script",
+	"ERR Error compiling script",
+	"ERR user_script",
+	"ERR user_function",
+}
+
+type (
+	// FallbackConfig enables the in-process limiter when redis is unavailable.
+	FallbackConfig struct {
+		ProbeInterval time.Duration       // * redis 探活间隔
+		Limit         int                 // * 本地最多保存的 key 数量
+		Notify        func(degraded bool) // * 切换模式时回调, true 表示切换到本地限流
+	}
+
+	// fallback tracks the health of redis and holds the local state used while degraded.
+	fallback struct {
+		store   redis.UniversalClient
+		config  FallbackConfig
+		alive   uint32
+		probing uint32
+		lock    sync.Mutex
+		local   *collection.Cache
+	}
+
+	// localEntry is the state of a key saved in the local cache.
+	localEntry interface {
+		expired(now time.Time) bool
+	}
+)
+
+// newFallback returns a fallback of the limiter, name is used to tell the stats of the local caches apart.
+func newFallback(name string, store redis.UniversalClient, config FallbackConfig, expire time.Duration) *fallback {
+	if config.ProbeInterval <= 0 {
+		config.ProbeInterval = defaultProbeInterval
+	}
+	if config.Limit <= 0 {
+		config.Limit = defaultFallbackLimit
+	}
+
+	local, err := collection.NewCache(expire,
+		collection.SetCacheLimit(config.Limit),
+		collection.SetCacheName("throttlex:"+name))
+	slogx.Default.MustSucc(nil, err)
+
+	return &fallback{
+		store:  store,
+		config: config,
+		alive:  1,
+		local:  local,
+	}
+}
+
+// degraded reports whether the limiter should use the local state.
+func (f *fallback) degraded() bool {
+	return atomic.LoadUint32(&f.alive) == 0
+}
+
+// failed reports whether err should switch the limiter to the local state,
+// the health probe is started on the first failure.
+func (f *fallback) failed(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	// * 脚本执行错误不是 redis 不可用
+	if isScriptError(err) {
+		return false
+	}
+
+	if atomic.CompareAndSwapUint32(&f.alive, 1, 0) {
+		slogx.Default.Error(context.Background(), "redis is unavailable, limiter falls back to local",
+			"error", err.Error())
+		f.notify(true)
+	}
+	f.startProbe()
+
+	return true
+}
+
+func (f *fallback) startProbe() {
+	if !atomic.CompareAndSwapUint32(&f.probing, 0, 1) {
+		return
+	}
+
+	threadingx.GoSafe(f.probe)
+}
+
+func (f *fallback) probe() {
+	ticker := time.NewTicker(f.config.ProbeInterval)
+	defer func() {
+		ticker.Stop()
+		atomic.StoreUint32(&f.probing, 0)
+	}()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), fallbackPingTimeout)
+		err := f.store.Ping(ctx).Err()
+		cancel()
+		if err != nil {
+			continue
+		}
+
+		if atomic.CompareAndSwapUint32(&f.alive, 0, 1) {
+			slogx.Default.Info(context.Background(), "redis recovered, limiter switches back to redis")
+			f.notify(false)
+		}
+		return
+	}
+}
+
+func (f *fallback) notify(degraded bool) {
+	if f.config.Notify == nil {
+		return
+	}
+
+	threadingx.RunSafe(func() {
+		f.config.Notify(degraded)
+	})
+}
+
+// update calls fn with the local entry of key under lock,
+// create is used when the entry doesn't exist or is expired.
+func (f *fallback) update(key string, now time.Time,
+	create func() (localEntry, time.Duration), fn func(v localEntry)) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	v, ok := f.local.Get(key)
+	entry, _ := v.(localEntry)
+	if !ok || entry == nil || entry.expired(now) {
+		var expire time.Duration
+		entry, expire = create()
+		f.local.SetWithExpire(key, entry, expire)
+	}
+	fn(entry)
+}
+
+// get returns the local entry of key if it's not expired.
+func (f *fallback) get(key string, now time.Time) (localEntry, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	v, ok := f.local.Get(key)
+	entry, _ := v.(localEntry)
+	if !ok || entry == nil || entry.expired(now) {
+		return nil, false
+	}
+
+	return entry, true
+}
+
+// isScriptError reports whether err is returned by a lua script rather than the connection,
+// the errors like LOADING, READONLY, CLUSTERDOWN and MASTERDOWN mean redis is unavailable.
+func isScriptError(err error) bool {
+	var redisErr redis.Error
+	if !errors.As(err, &redisErr) {
+		return false
+	}
+
+	msg := redisErr.Error()
+	for _, prefix := range scriptErrorPrefixes {
+		if strings.HasPrefix(msg, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,133 @@
+package throttlex
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeriodLimitFallback(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	client := redis.NewClient(&redis.Options{
+		Addr:       mr.Addr(),
+		MaxRetries: -1,
+	})
+
+	var degraded int32
+	l := NewPeriodLimit(60, 2, client, "period:", PeriodFallback(FallbackConfig{
+		ProbeInterval: 10 * time.Millisecond,
+		Notify: func(b bool) {
+			if b {
+				atomic.StoreInt32(&degraded, 1)
+			} else {
+				atomic.StoreInt32(&degraded, 0)
+			}
+		},
+	}))
+
+	state, err := l.Take("user")
+	assert.NoError(t, err)
+	assert.Equal(t, Allowed, state)
+
+	// * redis 不可用时使用本地限流
+	mr.Close()
+	var states []int
+	for i := 0; i < 3; i++ {
+		state, err := l.Take("user")
+		assert.NoError(t, err)
+		states = append(states, state)
+	}
+	assert.Equal(t, []int{Allowed, HitQuota, OverQuota}, states)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&degraded))
+
+	// * redis 恢复后切换回来
+	assert.NoError(t, mr.Restart())
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&degraded) == 0
+	}, time.Second, 10*time.Millisecond)
+
+	state, err = l.Take("user")
+	assert.NoError(t, err)
+	assert.Equal(t, HitQuota, state)
+}
+
+func TestRestrictionsFallback(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	client := redis.NewClient(&redis.Options{
+		Addr:       mr.Addr(),
+		MaxRetries: -1,
+	})
+	mr.Close()
+
+	r := NewRestrictions(client, SetRestrictionsInterval(50),
+		SetRestrictionsFallback(FallbackConfig{ProbeInterval: time.Minute}))
+
+	// * 与脚本一致, 第一次访问同样受访问间隔限制
+	status, err := r.Allow(context.Background(), "user")
+	assert.NoError(t, err)
+	assert.Equal(t, TimeFail, status)
+	time.Sleep(60 * time.Millisecond)
+	status, err = r.Allow(context.Background(), "user")
+	assert.NoError(t, err)
+	assert.Equal(t, Allow, status)
+	status, err = r.Allow(context.Background(), "user")
+	assert.NoError(t, err)
+	assert.Equal(t, TimeFail, status)
+}
+
+func TestLimitSenderFallbackOnStart(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	client := redis.NewClient(&redis.Options{
+		Addr:       mr.Addr(),
+		MaxRetries: -1,
+	})
+	mr.Close()
+
+	_, err = NewLimitSender(client)
+	assert.Error(t, err)
+
+	// * 开启降级时 redis 不可用也可以创建
+	ls, err := NewLimitSender(client, func(o *SendLimitOptions) {
+		o.Count = 1
+		o.Period = time.Minute
+		o.Fallback = &FallbackConfig{}
+	})
+	assert.NoError(t, err)
+	res, err := ls.SendLimit("user")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), res.WaitTime)
+	res, err = ls.SendLimit("user")
+	assert.NoError(t, err)
+	assert.True(t, res.WaitTime > 0)
+}
+
+func TestIsScriptError(t *testing.T) {
+	for msg, want := range map[string]bool{
+		"NOSCRIPT No matching script":                       true,
+		"ERR user_script:1: Script attempted to access key": true,
+		"ERR Error running script (call to f_xxx)":          true,
+		"LOADING Redis is loading the dataset in memory":    false,
+		"READONLY You can't write against a read only":      false,
+		"CLUSTERDOWN The cluster is down":                   false,
+		"MASTERDOWN Link with MASTER is down":               false,
+	} {
+		assert.Equal(t, want, isScriptError(testRedisError(msg)), msg)
+	}
+	assert.False(t, isScriptError(context.DeadlineExceeded))
+}
+
+type testRedisError string
+
+func (e testRedisError) Error() string { return string(e) }
+
+func (testRedisError) RedisError() {}
@@ -3,37 +3,111 @@ package throttlex
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"strconv"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/spf13/cast"
+	"github.com/uc1024/f90/core/stringx"
 	"github.com/uc1024/f90/core/throttlex/script"
 )
 
+// SendDimensionKey is the dimension of the key passed to SendLimitCtx.
+const SendDimensionKey = "key"
+
+const defaultReservationTTL = time.Minute * 10
+
+var (
+	// ErrNoSendRule means none of the rules applies to the targets.
+	ErrNoSendRule = errors.New("no send limit rule applies to the targets")
+	// ErrReservationNotFound means the reservation is expired or already refunded.
+	ErrReservationNotFound = errors.New("send reservation not found")
+)
+
 type LimitSender struct {
-	client  *redis.Client
-	options *SendLimitOptions
+	client   redis.UniversalClient
+	options  *SendLimitOptions
+	fallback *fallback
 }
 
 type SendLimitOptions struct {
-	Count     int64         `json:"count"`      // * 每天的次数
-	Period    time.Duration `json:"period"`     // * 每次的间隔秒
-	KeyPrefix string        `json:"key_prefix"` // * key前缀
+	Count     int64           `json:"count"`      // * 每个周期的次数
+	Period    time.Duration   `json:"period"`     // * 每次的间隔秒
+	KeyPrefix string          `json:"key_prefix"` // * key前缀, 非单机模式下没有 hash tag 时自动加上 {}
+	Reset     ResetPolicy     `json:"reset"`      // * 配额重置策略, 默认每天
+	Location  *time.Location  `json:"-"`          // * 计算重置时间的时区, 默认 UTC, 固定偏移使用 time.FixedZone
+	Rules     []SendLimitRule `json:"rules"`      // * 多级限制规则, 为空时使用 Count / Period / Reset
+	Fallback  *FallbackConfig `json:"-"`          // * redis 不可用时切换到本地限流
+
+	ReservationTTL time.Duration `json:"reservation_ttl"` // * 退还发送次数的有效期
+}
+
+// SendLimitRule is one tier of the quota, all the rules are checked atomically by one send.
+type SendLimitRule struct {
+	Name      string        `json:"name"`      // * 规则名称, 同时作为 key 的一部分, 不能重复
+	Dimension string        `json:"dimension"` // * 作用的维度, 如 phone / device / ip, 默认 SendDimensionKey
+	Count     int64         `json:"count"`     // * 每个周期的次数, 0 表示不限制次数
+	Period    time.Duration `json:"period"`    // * 每次的间隔
+	Reset     ResetPolicy   `json:"reset"`     // * 配额重置策略
+}
+
+// SendTargets are the values of the dimensions checked by one send,
+// e.g. SendTargets{"phone": "13800138000", "ip": "127.0.0.1"}.
+type SendTargets map[string]string
+
+// sendEntry is the local state of LimitSender used when redis is unavailable.
+type sendEntry struct {
+	count    int64
+	lastTime int64
+	resetAt  int64
+}
+
+// sendReservation is the local reservation used to refund a send.
+type sendReservation struct {
+	now      int64
+	keys     []string
+	lastTime []int64
+	resetAt  []int64
+	expireAt time.Time
 }
 
 type SendLimitResult struct {
-	Count       int64 `json:"count"`        // * 每天的次数
-	Period      int64 `json:"period"`       // * 每次的间隔秒
-	LastTime    int64 `json:"last_time"`    // * 最后一次发送时间
-	SendedCount int64 `json:"sended_count"` // * 当天已发送次数
-	WaitTime    int64 `json:"wait_time"`    // * 等待时间
+	Count       int64           `json:"count"`        // * 每个周期的次数
+	Period      int64           `json:"period"`       // * 每次的间隔秒
+	LastTime    int64           `json:"last_time"`    // * 最后一次发送时间
+	SendedCount int64           `json:"sended_count"` // * 当前周期已发送次数
+	WaitTime    int64           `json:"wait_time"`    // * 等待时间, 多个规则时为最长的等待时间
+	ResetAt     int64           `json:"reset_at"`     // * 当前周期结束的时间
+	Blocked     string          `json:"blocked"`      // * 等待时间最长的规则名称
+	Tiers       []SendLimitTier `json:"tiers"`        // * 每个规则的状态
+	Token       string          `json:"-"`            // * 允许发送时返回, 发送失败时用于退还次数
+}
+
+// SendLimitTier is the state of one rule after a send.
+type SendLimitTier struct {
+	Name        string `json:"name"`         // * 规则名称
+	Dimension   string `json:"dimension"`    // * 作用的维度
+	Target      string `json:"target"`       // * 维度的值
+	Count       int64  `json:"count"`        // * 每个周期的次数
+	Period      int64  `json:"period"`       // * 每次的间隔秒
+	LastTime    int64  `json:"last_time"`    // * 最后一次发送时间
+	SendedCount int64  `json:"sended_count"` // * 当前周期已发送次数
+	WaitTime    int64  `json:"wait_time"`    // * 等待时间
+	ResetAt     int64  `json:"reset_at"`     // * 当前周期结束的时间
 }
 
 type SendLimitOptionsFunc func(*SendLimitOptions)
 
-func NewLimitSender(client *redis.Client, opt ...SendLimitOptionsFunc) (*LimitSender, error) {
+// sendRuleKey is a rule applied to one of the targets.
+type sendRuleKey struct {
+	rule   SendLimitRule
+	target string
+	key    string
+}
+
+func NewLimitSender(client redis.UniversalClient, opt ...SendLimitOptionsFunc) (*LimitSender, error) {
 	options := &SendLimitOptions{
 		Count:     10,
 		Period:    time.Second * 60,
@@ -42,106 +116,488 @@ func NewLimitSender(client *redis.Client, opt ...SendLimitOptionsFunc) (*LimitSe
 	for _, o := range opt {
 		o(options)
 	}
+	// * 一次检查多个 key, 集群模式下所有 key 需要在同一个 slot
+	if _, ok := client.(*redis.Client); !ok && !hasHashTag(options.KeyPrefix) {
+		options.KeyPrefix = "{" + options.KeyPrefix + "}"
+	}
+	if options.ReservationTTL < time.Second {
+		options.ReservationTTL = defaultReservationTTL
+	}
+
+	if len(options.Rules) == 0 {
+		// * 兼容单一规则的配置
+		options.Rules = []SendLimitRule{{
+			Count:  options.Count,
+			Period: options.Period,
+			Reset:  options.Reset,
+		}}
+	}
+
+	names := make(map[string]struct{}, len(options.Rules))
+	for i, rule := range options.Rules {
+		if _, ok := names[rule.Name]; ok {
+			return nil, fmt.Errorf("duplicate send limit rule name: %q", rule.Name)
+		}
+		names[rule.Name] = struct{}{}
+		if rule.Dimension == "" {
+			options.Rules[i].Dimension = SendDimensionKey
+		}
+	}
+
+	ls := &LimitSender{client: client, options: options}
+	if options.Fallback != nil {
+		ls.fallback = newFallback("limit_send:"+options.KeyPrefix, client, *options.Fallback, 7*24*time.Hour)
+	}
 
-	// * 预加载
-	script.GetLimitSendScript(context.Background(), client)
+	// * 预加载, 开启降级时 redis 不可用也可以创建, 执行时会重新加载
+	ctx, cancel := context.WithTimeout(context.Background(), fallbackPingTimeout)
+	defer cancel()
+	if err := script.LoadLimitSendScript(ctx, client); err != nil {
+		if ls.fallback == nil {
+			return nil, fmt.Errorf("failed to load limit_send_script: %w", err)
+		}
+		ls.fallback.failed(err)
+	}
 
-	return &LimitSender{client, options}, nil
+	return ls, nil
 }
 
 func (ls *LimitSender) Key(s string) string {
 	return fmt.Sprintf("%s:%s", ls.options.KeyPrefix, s)
 }
 
+// * 规则对应的 key, 没有名称的规则兼容原来的 key
+func (ls *LimitSender) ruleKey(rule SendLimitRule, target string) string {
+	if rule.Name == "" {
+		return ls.Key(target)
+	}
+
+	return fmt.Sprintf("%s:%s:%s", ls.options.KeyPrefix, rule.Name, target)
+}
+
+// * 预约记录的 key
+func (ls *LimitSender) reservationKey(token string) string {
+	return fmt.Sprintf("%s:reservation:%s", ls.options.KeyPrefix, token)
+}
+
+// * 找出作用于 targets 的规则
+func (ls *LimitSender) applied(targets SendTargets) []sendRuleKey {
+	var keys []sendRuleKey
+	for _, rule := range ls.options.Rules {
+		target, ok := targets[rule.Dimension]
+		if !ok || target == "" {
+			continue
+		}
+		keys = append(keys, sendRuleKey{
+			rule:   rule,
+			target: target,
+			key:    ls.ruleKey(rule, target),
+		})
+	}
+
+	return keys
+}
+
 func (ls *LimitSender) SendLimit(key string) (*SendLimitResult, error) {
 	return ls.SendLimitCtx(context.Background(), key)
 }
 
 func (ls *LimitSender) SendLimitCtx(ctx context.Context, key string) (*SendLimitResult, error) {
+	return ls.SendLimitTargetsCtx(ctx, SendTargets{SendDimensionKey: key})
+}
+
+// SendLimitTargetsCtx checks all the rules of the targets in one lua evaluation,
+// the send is counted only if all of the rules allow it.
+func (ls *LimitSender) SendLimitTargetsCtx(ctx context.Context,
+	targets SendTargets) (*SendLimitResult, error) {
+	keys := ls.applied(targets)
+	if len(keys) == 0 {
+		return nil, ErrNoSendRule
+	}
+
+	token := stringx.RandId()
+	if ls.fallback != nil && ls.fallback.degraded() {
+		return ls.sendLimitLocal(keys, token), nil
+	}
+
+	now := time.Now()
+	redisKeys := make([]string, 0, len(keys)+1)
+	args := []interface{}{now.Unix(), int64(ls.options.ReservationTTL.Seconds())}
+	for _, k := range keys {
+		redisKeys = append(redisKeys, k.key)
+		args = append(args,
+			k.rule.Period.Seconds(),
+			k.rule.Count,
+			ls.nextReset(k.rule, now),
+			int64(k.rule.Reset.window().Seconds()),
+		)
+	}
+	redisKeys = append(redisKeys, ls.reservationKey(token))
 
-	res, err := script.LimitSendScript.EvalSha(ctx, ls.client, []string{ls.Key(key)},
-		ls.options.Period.Seconds(),
-		ls.options.Count,
-		time.Now().Add(24*time.Hour).Unix(), // * 默认的过期时间
-	).Result()
+	res, err := script.LimitSendScript.Run(ctx, ls.client, redisKeys, args...).Result()
 	if err != nil {
+		if ls.fallback != nil && ls.fallback.failed(err) {
+			return ls.sendLimitLocal(keys, token), nil
+		}
 		return nil, fmt.Errorf("failed to execute limit_send_script: %v", err)
 	}
 
 	values, ok := res.([]interface{})
-	if !ok || len(values) != 2 {
+	if !ok || len(values) != 3 {
 		return nil, fmt.Errorf("unexpected result format from limit_send_script")
-
 	}
 
-	count, ok := values[0].(int64)
+	json_str, ok := values[2].(string)
 	if !ok {
-		return nil, fmt.Errorf("unexpected count type from limit_send_script")
+		return nil, fmt.Errorf("unexpected json bytes type from limit_send_script %T", values[2])
 	}
 
-	json_str, ok := values[1].(string)
+	// * 每个规则的 {count, last_time, reset_at, wait}
+	var states [][]float64
+	if err := json.Unmarshal([]byte(json_str), &states); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal json from limit_send_script: %v", err)
+	}
+	if len(states) != len(keys) {
+		return nil, fmt.Errorf("unexpected states count from limit_send_script")
+	}
 
-	if !ok {
-		return nil, fmt.Errorf("unexpected json bytes type from limit_send_script %T", values[1])
+	tiers := make([]SendLimitTier, len(keys))
+	for i, k := range keys {
+		tiers[i] = newSendLimitTier(k)
+		if len(states[i]) == 4 {
+			tiers[i].SendedCount = int64(states[i][0])
+			tiers[i].LastTime = int64(states[i][1])
+			tiers[i].ResetAt = int64(states[i][2])
+			tiers[i].WaitTime = int64(states[i][3])
+		}
 	}
 
-	var limit_ary []string
-	if err := json.Unmarshal([]byte(json_str), &limit_ary); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal json from limit_send_script: %v", err)
+	result := newSendLimitResult(tiers, cast.ToInt64(values[0]), cast.ToInt(values[1]))
+	if result.WaitTime == 0 {
+		result.Token = token
+	}
+
+	return result, nil
+}
+
+func (ls *LimitSender) Refund(token string) error {
+	return ls.RefundCtx(context.Background(), token)
+}
+
+// RefundCtx reverses the send reserved by token, it's used when the downstream send failed.
+// The count is refunded only if the period of the rules is not reset yet.
+func (ls *LimitSender) RefundCtx(ctx context.Context, token string) error {
+	if token == "" {
+		return ErrReservationNotFound
 	}
 
-	var send_limit = make(map[string]string)
-	for i := 0; i < len(limit_ary)-1; i += 2 {
-		send_limit[limit_ary[i]] = limit_ary[i+1]
+	if ls.fallback != nil && ls.fallback.degraded() {
+		return ls.refundLocal(token)
 	}
-	// ( "count", "last_time"))
 
-	result := SendLimitResult{
-		Count:       ls.options.Count,
-		Period:      int64(ls.options.Period.Seconds()),
-		SendedCount: cast.ToInt64(send_limit["count"]),
-		LastTime:    cast.ToInt64(send_limit["last_time"]),
-		WaitTime:    count,
+	ok, err := ls.refund(ctx, token)
+	if err != nil {
+		if ls.fallback != nil && ls.fallback.failed(err) {
+			return ls.refundLocal(token)
+		}
+		return fmt.Errorf("failed to execute limit_send_refund_script: %v", err)
+	}
+	if !ok {
+		return ErrReservationNotFound
 	}
 
-	return &result, nil
+	return nil
 }
 
-func (ls *LimitSender) GetLimitWithCtx(ctx context.Context, key string) (*SendLimitResult, error) {
+func (ls *LimitSender) refund(ctx context.Context, token string) (bool, error) {
+	keys, err := ls.reservationKeys(ctx, token)
+	if errors.Is(err, ErrReservationNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
 
-	result, err := ls.client.HGetAll(ctx, ls.Key(key)).Result()
+	ok, err := script.LimitSendRefundScript.Run(ctx, ls.client, keys).Int()
+	return ok == 1, err
+}
 
+// * 预约记录的 key 和其中规则的 key, 脚本访问的 key 都需要通过 KEYS 传入
+func (ls *LimitSender) reservationKeys(ctx context.Context, token string) ([]string, error) {
+	key := ls.reservationKey(token)
+	record, err := ls.client.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrReservationNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	if len(result) == 0 {
-		return &SendLimitResult{
-			Count:       ls.options.Count,
-			Period:      int64(ls.options.Period.Seconds()),
-			SendedCount: 0,
-			LastTime:    0,
-			WaitTime:    0,
-		}, nil
+	// * {now, {key, 之前的发送时间, 周期结束时间}, ...}
+	var reservation []json.RawMessage
+	if err := json.Unmarshal([]byte(record), &reservation); err != nil || len(reservation) == 0 {
+		return nil, fmt.Errorf("invalid send reservation %s: %s", token, record)
 	}
-	var send_limit = make(map[string]string)
-	for i := 0; i < len(result)-1; i += 2 {
-		ii := strconv.Itoa(i)
-		iii := strconv.Itoa(i + 1)
-		send_limit[result[ii]] = result[iii]
 
+	keys := []string{key}
+	for _, raw := range reservation[1:] {
+		var rule []interface{}
+		if err := json.Unmarshal(raw, &rule); err != nil || len(rule) == 0 {
+			return nil, fmt.Errorf("invalid send reservation %s: %s", token, record)
+		}
+		ruleKey, ok := rule[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid send reservation %s: %s", token, record)
+		}
+		keys = append(keys, ruleKey)
 	}
 
-	info := &SendLimitResult{
-		Count:       ls.options.Count,
-		Period:      int64(ls.options.Period.Seconds()),
-		SendedCount: cast.ToInt64(send_limit["count"]),
-		LastTime:    cast.ToInt64(send_limit["last_time"]),
-		WaitTime:    0,
+	return keys, nil
+}
+
+func (ls *LimitSender) Reset(key string) error {
+	return ls.ResetCtx(context.Background(), key)
+}
+
+// ResetCtx clears the state of all the rules applied to key.
+func (ls *LimitSender) ResetCtx(ctx context.Context, key string) error {
+	return ls.ResetTargetsCtx(ctx, SendTargets{SendDimensionKey: key})
+}
+
+// ResetTargetsCtx clears the state of all the rules applied to the targets.
+func (ls *LimitSender) ResetTargetsCtx(ctx context.Context, targets SendTargets) error {
+	keys := ls.applied(targets)
+	if len(keys) == 0 {
+		return ErrNoSendRule
+	}
+
+	redisKeys := make([]string, 0, len(keys))
+	for _, k := range keys {
+		redisKeys = append(redisKeys, k.key)
+		if ls.fallback != nil {
+			ls.fallback.local.Del(k.key)
+		}
+	}
+
+	if ls.fallback != nil && ls.fallback.degraded() {
+		return nil
+	}
+
+	// * 逐个删除, 不依赖 key 在同一个 slot
+	pipe := ls.client.Pipeline()
+	for _, key := range redisKeys {
+		pipe.Del(ctx, key)
+	}
+	_, err := pipe.Exec(ctx)
+	if err != nil && ls.fallback != nil && ls.fallback.failed(err) {
+		return nil
+	}
+
+	return err
+}
+
+// * key 中是否有非空的 hash tag
+func hasHashTag(key string) bool {
+	start := strings.IndexByte(key, '{')
+	if start < 0 {
+		return false
+	}
+	end := strings.IndexByte(key[start+1:], '}')
+	return end > 0
+}
+
+// * 日历周期的结束时间, 滚动周期返回 0
+func (ls *LimitSender) nextReset(rule SendLimitRule, now time.Time) int64 {
+	next := rule.Reset.nextReset(now, ls.options.Location)
+	if next.IsZero() {
+		return 0
+	}
+
+	return next.Unix()
+}
+
+func newSendLimitTier(k sendRuleKey) SendLimitTier {
+	return SendLimitTier{
+		Name:      k.rule.Name,
+		Dimension: k.rule.Dimension,
+		Target:    k.target,
+		Count:     k.rule.Count,
+		Period:    int64(k.rule.Period.Seconds()),
+	}
+}
+
+// * 第一个规则作为主要的结果, blocked 是从 1 开始的规则序号
+func newSendLimitResult(tiers []SendLimitTier, wait int64, blocked int) *SendLimitResult {
+	primary := tiers[0]
+	result := &SendLimitResult{
+		Count:       primary.Count,
+		Period:      primary.Period,
+		LastTime:    primary.LastTime,
+		SendedCount: primary.SendedCount,
+		WaitTime:    wait,
+		ResetAt:     primary.ResetAt,
+		Tiers:       tiers,
+	}
+	if blocked > 0 && blocked <= len(tiers) {
+		result.Blocked = tiers[blocked-1].Name
+	}
+
+	return result
+}
+
+// * 使用本地状态判断是否允许发送
+func (ls *LimitSender) sendLimitLocal(keys []sendRuleKey, token string) *SendLimitResult {
+	now := time.Now()
+	unix := now.Unix()
+
+	tiers := make([]SendLimitTier, len(keys))
+	entries := make([]*sendEntry, len(keys))
+	var wait int64
+	var blocked int
+
+	ls.fallback.lock.Lock()
+	defer ls.fallback.lock.Unlock()
+
+	for i, k := range keys {
+		v, ok := ls.fallback.local.Get(k.key)
+		entry, _ := v.(*sendEntry)
+		if !ok || entry == nil || entry.expired(now) {
+			resetAt := ls.nextReset(k.rule, now)
+			if resetAt == 0 {
+				resetAt = unix + int64(k.rule.Reset.window().Seconds())
+			}
+			entry = &sendEntry{resetAt: resetAt}
+			ls.fallback.local.SetWithExpire(k.key, entry, time.Duration(resetAt-unix)*time.Second)
+		}
+		entries[i] = entry
+
+		tiers[i] = newSendLimitTier(k)
+		if k.rule.Count > 0 && entry.count >= k.rule.Count {
+			tiers[i].WaitTime = entry.resetAt - unix
+		} else if unix-entry.lastTime < tiers[i].Period {
+			tiers[i].WaitTime = tiers[i].Period - (unix - entry.lastTime)
+		}
+		if tiers[i].WaitTime > wait {
+			wait = tiers[i].WaitTime
+			blocked = i + 1
+		}
+	}
+
+	var reservation *sendReservation
+	if wait == 0 {
+		reservation = &sendReservation{
+			now:      unix,
+			expireAt: now.Add(ls.options.ReservationTTL),
+		}
+	}
+
+	for i, entry := range entries {
+		if wait == 0 {
+			reservation.keys = append(reservation.keys, keys[i].key)
+			reservation.lastTime = append(reservation.lastTime, entry.lastTime)
+			reservation.resetAt = append(reservation.resetAt, entry.resetAt)
+			entry.count++
+			entry.lastTime = unix
+		}
+		tiers[i].SendedCount = entry.count
+		tiers[i].LastTime = entry.lastTime
+		tiers[i].ResetAt = entry.resetAt
+	}
+
+	result := newSendLimitResult(tiers, wait, blocked)
+	if reservation != nil {
+		ls.fallback.local.SetWithExpire(ls.reservationKey(token), reservation, ls.options.ReservationTTL)
+		result.Token = token
+	}
+
+	return result
+}
+
+// * 使用本地状态退还发送次数
+func (ls *LimitSender) refundLocal(token string) error {
+	key := ls.reservationKey(token)
+	v, ok := ls.fallback.get(key, time.Now())
+	if !ok {
+		return ErrReservationNotFound
+	}
+	ls.fallback.local.Del(key)
+
+	ls.fallback.lock.Lock()
+	defer ls.fallback.lock.Unlock()
+
+	reservation := v.(*sendReservation)
+	for i, k := range reservation.keys {
+		v, ok := ls.fallback.local.Get(k)
+		entry, _ := v.(*sendEntry)
+		if !ok || entry == nil || entry.resetAt != reservation.resetAt[i] {
+			continue
+		}
+		if entry.count > 0 {
+			entry.count--
+		}
+		if entry.lastTime == reservation.now {
+			entry.lastTime = reservation.lastTime[i]
+		}
 	}
 
-	if ls.options.Count > cast.ToInt64(send_limit["count"]) {
+	return nil
+}
+
+func (e *sendEntry) expired(now time.Time) bool {
+	return now.Unix() >= e.resetAt
+}
+
+func (r *sendReservation) expired(now time.Time) bool {
+	return !now.Before(r.expireAt)
+}
+
+func (ls *LimitSender) GetLimitWithCtx(ctx context.Context, key string) (*SendLimitResult, error) {
+	keys := ls.applied(SendTargets{SendDimensionKey: key})
+	if len(keys) == 0 {
+		return nil, ErrNoSendRule
+	}
+
+	if ls.fallback != nil && ls.fallback.degraded() {
+		return ls.getLimitLocal(keys), nil
+	}
+
+	tiers := make([]SendLimitTier, len(keys))
+	now := time.Now().Unix()
+	for i, k := range keys {
+		result, err := ls.client.HGetAll(ctx, k.key).Result()
+		if err != nil {
+			if ls.fallback != nil && ls.fallback.failed(err) {
+				return ls.getLimitLocal(keys), nil
+			}
+			return nil, err
+		}
+
+		tiers[i] = newSendLimitTier(k)
+		// * 周期已结束的记录视为没有发送
+		resetAt := cast.ToInt64(result["reset_at"])
+		if resetAt > now {
+			tiers[i].SendedCount = cast.ToInt64(result["count"])
+			tiers[i].ResetAt = resetAt
+		}
+		tiers[i].LastTime = cast.ToInt64(result["last_time"])
+	}
+
+	return newSendLimitResult(tiers, 0, 0), nil
+}
+
+func (ls *LimitSender) getLimitLocal(keys []sendRuleKey) *SendLimitResult {
+	tiers := make([]SendLimitTier, len(keys))
+	for i, k := range keys {
+		tiers[i] = newSendLimitTier(k)
+		if v, ok := ls.fallback.get(k.key, time.Now()); ok {
+			entry := v.(*sendEntry)
+			ls.fallback.lock.Lock()
+			tiers[i].SendedCount = entry.count
+			tiers[i].LastTime = entry.lastTime
+			tiers[i].ResetAt = entry.resetAt
+			ls.fallback.lock.Unlock()
+		}
 	}
 
-	return info, nil
+	return newSendLimitResult(tiers, 0, 0)
 }
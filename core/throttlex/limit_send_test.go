@@ -2,6 +2,7 @@ package throttlex
 
 import (
 	"context"
+	"strings"
 	"testing"
 	"time"
 
@@ -40,3 +41,208 @@ func TestSmsLimiter(t *testing.T) {
 		t.Logf("%+v", a)
 	}
 }
+
+func TestSmsLimiterReset(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	client := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+
+	shanghai := time.FixedZone("CST", 8*3600)
+	ex, err := NewLimitSender(client, func(slo *SendLimitOptions) {
+		slo.Count = 1
+		slo.Period = time.Second
+		slo.Reset = ResetHourly
+		slo.Location = shanghai
+	})
+	assert.NoError(t, err)
+
+	first, err := ex.SendLimit("13800138000")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), first.WaitTime)
+	assert.Equal(t, int64(1), first.SendedCount)
+	assert.Equal(t, ResetHourly.nextReset(time.Now(), shanghai).Unix(), first.ResetAt)
+
+	// * 超出次数需要等待到下一个周期
+	second, err := ex.SendLimit("13800138000")
+	assert.NoError(t, err)
+	assert.InDelta(t, first.ResetAt-time.Now().Unix(), second.WaitTime, 1)
+
+	// * key 的过期时间对齐周期结束的时间
+	assert.InDelta(t, time.Until(time.Unix(first.ResetAt, 0)).Seconds(),
+		mr.TTL(ex.Key("13800138000")).Seconds(), 1)
+
+	info, err := ex.GetLimitWithCtx(context.Background(), "13800138000")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), info.SendedCount)
+}
+
+func TestSmsLimiterRules(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	client := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+
+	ex, err := NewLimitSender(client, func(slo *SendLimitOptions) {
+		slo.Rules = []SendLimitRule{
+			{Name: "phone_minute", Dimension: "phone", Count: 1, Reset: ResetMinutely},
+			{Name: "phone_day", Dimension: "phone", Count: 5},
+			{Name: "ip_day", Dimension: "ip", Count: 2},
+		}
+	})
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	send := func(phone, ip string) *SendLimitResult {
+		result, err := ex.SendLimitTargetsCtx(ctx, SendTargets{"phone": phone, "ip": ip})
+		assert.NoError(t, err)
+		return result
+	}
+
+	result := send("13800138000", "127.0.0.1")
+	assert.Equal(t, int64(0), result.WaitTime)
+	assert.Empty(t, result.Blocked)
+	assert.Len(t, result.Tiers, 3)
+
+	result = send("13800138000", "127.0.0.1")
+	assert.Equal(t, "phone_minute", result.Blocked)
+	assert.True(t, result.WaitTime > 0)
+
+	// * 被限制的发送不计入其他规则
+	result = send("13800138001", "127.0.0.1")
+	assert.Empty(t, result.Blocked)
+	assert.Equal(t, int64(2), result.Tiers[2].SendedCount)
+
+	result = send("13800138002", "127.0.0.1")
+	assert.Equal(t, "ip_day", result.Blocked)
+	assert.Equal(t, int64(0), result.Tiers[0].SendedCount)
+
+	result = send("13800138002", "127.0.0.2")
+	assert.Empty(t, result.Blocked)
+
+	_, err = ex.SendLimit("13800138000")
+	assert.Equal(t, ErrNoSendRule, err)
+}
+
+func TestSmsLimiterRefund(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	client := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+
+	ex, err := NewLimitSender(client, func(slo *SendLimitOptions) {
+		slo.Count = 1
+		slo.Period = time.Minute
+	})
+	assert.NoError(t, err)
+
+	key := "13800138000"
+	first, err := ex.SendLimit(key)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, first.Token)
+
+	blocked, err := ex.SendLimit(key)
+	assert.NoError(t, err)
+	assert.True(t, blocked.WaitTime > 0)
+	assert.Empty(t, blocked.Token)
+
+	// * 发送失败退还次数后可以重新发送
+	assert.NoError(t, ex.Refund(first.Token))
+	assert.Equal(t, ErrReservationNotFound, ex.Refund(first.Token))
+
+	second, err := ex.SendLimit(key)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), second.WaitTime)
+	assert.Equal(t, int64(1), second.SendedCount)
+
+	// * 重置后清空所有记录
+	assert.NoError(t, ex.Reset(key))
+	info, err := ex.GetLimitWithCtx(context.Background(), key)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), info.SendedCount)
+	assert.Equal(t, int64(0), info.LastTime)
+}
+
+func TestSmsLimiterCluster(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	client := redis.NewClusterClient(&redis.ClusterOptions{
+		Addrs: []string{mr.Addr()},
+	})
+	defer client.Close()
+
+	ex, err := NewLimitSender(client, func(slo *SendLimitOptions) {
+		slo.Rules = []SendLimitRule{
+			{Name: "phone_day", Dimension: "phone", Count: 1},
+			{Name: "ip_day", Dimension: "ip", Count: 5},
+		}
+	})
+	assert.NoError(t, err)
+
+	// * 所有 key 使用同一个 hash tag
+	ctx := context.Background()
+	targets := SendTargets{"phone": "13800138000", "ip": "127.0.0.1"}
+	result, err := ex.SendLimitTargetsCtx(ctx, targets)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), result.WaitTime)
+	assert.NotEmpty(t, mr.Keys())
+	for _, key := range mr.Keys() {
+		assert.True(t, strings.HasPrefix(key, "{limit_send}:"), key)
+	}
+
+	assert.NoError(t, ex.ResetTargetsCtx(ctx, targets))
+	result, err = ex.SendLimitTargetsCtx(ctx, targets)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), result.WaitTime)
+
+	// * 已有 hash tag 时不再添加
+	ex, err = NewLimitSender(client, func(slo *SendLimitOptions) {
+		slo.KeyPrefix = "sms:{limit}"
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "sms:{limit}:1", ex.Key("1"))
+}
+
+func TestSmsLimiterRefundRules(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	client := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+
+	ex, err := NewLimitSender(client, func(slo *SendLimitOptions) {
+		slo.Rules = []SendLimitRule{
+			{Name: "phone_day", Dimension: "phone", Count: 1},
+			{Name: "ip_day", Dimension: "ip", Count: 1},
+		}
+	})
+	assert.NoError(t, err)
+
+	// * 退还所有规则的次数
+	ctx := context.Background()
+	targets := SendTargets{"phone": "13800138000", "ip": "127.0.0.1"}
+	result, err := ex.SendLimitTargetsCtx(ctx, targets)
+	assert.NoError(t, err)
+	assert.NoError(t, ex.RefundCtx(ctx, result.Token))
+	result, err = ex.SendLimitTargetsCtx(ctx, targets)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), result.WaitTime)
+	for _, tier := range result.Tiers {
+		assert.Equal(t, int64(1), tier.SendedCount)
+	}
+
+	assert.Equal(t, ErrReservationNotFound, ex.RefundCtx(ctx, result.Token+"x"))
+}
@@ -0,0 +1,269 @@
+package throttlex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/uc1024/f90/core/authorizex"
+	"github.com/uc1024/f90/core/slogx"
+)
+
+// ErrEmptyKey means the key function returned an empty key.
+var ErrEmptyKey = errors.New("empty limit key")
+
+type (
+	// KeyFunc returns the key of the request to be limited.
+	KeyFunc func(r *http.Request) (string, error)
+
+	// RejectHandler writes the response when the request is rejected by a limiter.
+	RejectHandler func(w http.ResponseWriter, r *http.Request, result *LimitResult)
+
+	// ErrorHandler writes the response when a limiter failed.
+	ErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
+
+	// MiddlewareOption defines the method to customize the limit middleware.
+	MiddlewareOption func(m *middleware)
+
+	middleware struct {
+		key      KeyFunc
+		limiters []ResultLimiter
+		reject   RejectHandler
+		keyError ErrorHandler // * 获取 key 失败且 onError 为空时的响应
+		onError  ErrorHandler // * 为空时记录日志, 限流器失败时放行
+	}
+)
+
+// NewMiddleware returns a net/http middleware that requests a permit from every limiter
+// with the key of the request, the request is rejected if any of the limiters rejects it.
+// Requests without a key are passed to the error handler, or rejected with 400 by default,
+// use FirstKey to limit them by other keys instead.
+func NewMiddleware(key KeyFunc, limiters []ResultLimiter,
+	opts ...MiddlewareOption) func(http.Handler) http.Handler {
+	m := &middleware{
+		key:      key,
+		limiters: limiters,
+		reject:   DefaultRejectHandler,
+		keyError: DefaultKeyErrorHandler,
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			m.serve(next, w, r)
+		})
+	}
+}
+
+func (m *middleware) serve(next http.Handler, w http.ResponseWriter, r *http.Request) {
+	key, err := m.key(r)
+	if err == nil && key == "" {
+		err = ErrEmptyKey
+	}
+	if err != nil {
+		// * 无法识别的请求不放行, 否则客户端去掉 key 就可以绕过限流
+		if m.onError != nil {
+			m.onError(w, r, err)
+			return
+		}
+		slogx.Default.Error(r.Context(), "failed to get limit key, request is rejected",
+			"path", r.URL.Path, "error", err.Error())
+		m.keyError(w, r, err)
+		return
+	}
+
+	// * 记录剩余次数最少的结果用于响应头
+	var strictest *LimitResult
+	for _, limiter := range m.limiters {
+		result, err := limiter.TakeResultCtx(r.Context(), key)
+		if err != nil {
+			if m.onError != nil {
+				m.onError(w, r, err)
+				return
+			}
+			slogx.Default.Error(r.Context(), "failed to take permit, request is not limited",
+				"key", key, "error", err.Error())
+			continue
+		}
+
+		if !result.Allowed {
+			m.reject(w, r, result)
+			return
+		}
+		if strictest == nil || result.Remaining < strictest.Remaining {
+			strictest = result
+		}
+	}
+
+	WriteRateLimitHeaders(w, strictest)
+	next.ServeHTTP(w, r)
+}
+
+// DefaultRejectHandler writes the rate limit headers and responds 429.
+func DefaultRejectHandler(w http.ResponseWriter, r *http.Request, result *LimitResult) {
+	WriteRateLimitHeaders(w, result)
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+}
+
+// DefaultKeyErrorHandler responds 400 when the key of the request can't be got.
+func DefaultKeyErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+}
+
+// WithRejectHandler customizes the response of the rejected requests.
+func WithRejectHandler(handler RejectHandler) MiddlewareOption {
+	return func(m *middleware) {
+		m.reject = handler
+	}
+}
+
+// WithErrorHandler customizes the response when the key of the request can't be got
+// or a limiter failed. By default the error is logged, the request without a key is rejected
+// and the request is not limited if a limiter failed.
+func WithErrorHandler(handler ErrorHandler) MiddlewareOption {
+	return func(m *middleware) {
+		m.onError = handler
+	}
+}
+
+// ClientIPKey uses the remote address of the connection as the key,
+// use TrustedClientIPKey if the server is behind proxies.
+func ClientIPKey(r *http.Request) (string, error) {
+	return remoteIP(r), nil
+}
+
+// TrustedClientIPKey uses the client ip as the key, X-Forwarded-For and X-Real-IP are respected
+// only if the request comes from one of the trusted proxies, which are ips or CIDRs.
+// The client ip is the rightmost address of X-Forwarded-For that is not a trusted proxy.
+func TrustedClientIPKey(trustedProxies ...string) (KeyFunc, error) {
+	nets := make([]*net.IPNet, 0, len(trustedProxies))
+	for _, proxy := range trustedProxies {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+		nets = append(nets, ipNet)
+	}
+
+	trusted := func(addr string) bool {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return false
+		}
+		for _, ipNet := range nets {
+			if ipNet.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(r *http.Request) (string, error) {
+		ip := remoteIP(r)
+		if !trusted(ip) {
+			return ip, nil
+		}
+
+		// * 从右向左跳过可信代理, 左边的地址可以被客户端伪造
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			addrs := strings.Split(strings.Join(forwarded, ","), ",")
+			for i := len(addrs) - 1; i >= 0; i-- {
+				addr := strings.TrimSpace(addrs[i])
+				if addr == "" {
+					continue
+				}
+				if !trusted(addr) {
+					return addr, nil
+				}
+				ip = addr
+			}
+			return ip, nil
+		}
+		if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+			return realIP, nil
+		}
+
+		return ip, nil
+	}, nil
+}
+
+// FirstKey uses the first non-empty key returned by fns, e.g. the user id,
+// and the client ip for anonymous requests.
+func FirstKey(fns ...KeyFunc) KeyFunc {
+	return func(r *http.Request) (string, error) {
+		var lastErr error
+		for _, fn := range fns {
+			key, err := fn(r)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			if key != "" {
+				return key, nil
+			}
+		}
+
+		if lastErr == nil {
+			lastErr = ErrEmptyKey
+		}
+		return "", lastErr
+	}
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// HeaderKey uses the value of the given header as the key.
+func HeaderKey(name string) KeyFunc {
+	return func(r *http.Request) (string, error) {
+		return r.Header.Get(name), nil
+	}
+}
+
+// ExtractorKey uses the value extracted from the context of the request as the key,
+// e.g. the user id verified and saved by the auth middleware with authorizex.JwtUserIdExtractor.
+// The headers of the request are not used, they can be changed by the clients at will.
+func ExtractorKey(ex authorizex.Extractor) KeyFunc {
+	return func(r *http.Request) (string, error) {
+		return ex.Extract(r.Context())
+	}
+}
+
+// PrefixKey prefixes the key returned by fn, it's used to share a limiter between routes.
+func PrefixKey(prefix string, fn KeyFunc) KeyFunc {
+	return func(r *http.Request) (string, error) {
+		key, err := fn(r)
+		if err != nil || key == "" {
+			return key, err
+		}
+
+		return prefix + key, nil
+	}
+}
+
+// ResultLimiterFunc is an adapter to allow the use of ordinary functions as ResultLimiter.
+type ResultLimiterFunc func(ctx context.Context, key string) (*LimitResult, error)
+
+// TakeResultCtx calls f(ctx, key).
+func (f ResultLimiterFunc) TakeResultCtx(ctx context.Context, key string) (*LimitResult, error) {
+	return f(ctx, key)
+}
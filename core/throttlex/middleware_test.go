@@ -0,0 +1,159 @@
+package throttlex
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/uc1024/f90/core/authorizex"
+)
+
+func TestMiddleware(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	client := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+
+	handler := NewMiddleware(ExtractorKey(authorizex.JwtUserIdExtractor{}), []ResultLimiter{
+		NewPeriodLimit(60, 2, client, "period:"),
+		NewTokenLimiter(10, 5, client, "token:"),
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(user string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if user != "" {
+			r = withUser(r, user)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("1")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "1", w.Header().Get(HeaderRateLimitRemaining))
+	assert.Equal(t, http.StatusOK, serve("1").Code)
+
+	w = serve("1")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "2", w.Header().Get(HeaderRateLimitLimit))
+	assert.NotEmpty(t, w.Header().Get(HeaderRetryAfter))
+
+	// * 其他用户不受影响, 匿名请求被拒绝
+	assert.Equal(t, http.StatusOK, serve("2").Code)
+	assert.Equal(t, http.StatusBadRequest, serve("").Code)
+
+	// * 只使用认证中间件写入 context 的用户, 不使用客户端传入的请求头
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("user_id", "3")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// * 模拟认证中间件将校验过的用户写入 context
+func withUser(r *http.Request, user string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), "user_id", user))
+}
+
+func TestMiddlewareKeyError(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	client := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	serve := func(handler http.Handler) int {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	var keyErr error
+	handler := NewMiddleware(ExtractorKey(authorizex.JwtUserIdExtractor{}),
+		[]ResultLimiter{NewPeriodLimit(60, 1, client, "period:")},
+		WithErrorHandler(func(w http.ResponseWriter, r *http.Request, err error) {
+			keyErr = err
+			w.WriteHeader(http.StatusUnauthorized)
+		}))(ok)
+	assert.Equal(t, http.StatusUnauthorized, serve(handler))
+	assert.Error(t, keyErr)
+
+	// * 匿名请求按 ip 限流, 更换 user_id 请求头不能绕过
+	handler = NewMiddleware(FirstKey(ExtractorKey(authorizex.JwtUserIdExtractor{}), ClientIPKey),
+		[]ResultLimiter{NewPeriodLimit(60, 1, client, "period:")})(ok)
+	assert.Equal(t, http.StatusOK, serve(handler))
+	assert.Equal(t, http.StatusTooManyRequests, serve(handler))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("user_id", "spoofed")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	// * 认证过的用户按用户限流
+	r = withUser(httptest.NewRequest(http.MethodGet, "/", nil), "1")
+	r.RemoteAddr = "10.0.0.1:1234"
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestClientIPKey(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	key, err := ClientIPKey(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", key)
+
+	// * 默认不信任转发头
+	r.Header.Set("X-Forwarded-For", "1.1.1.1, 10.0.0.2")
+	r.Header.Set("X-Real-IP", "2.2.2.2")
+	key, err = ClientIPKey(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", key)
+}
+
+func TestTrustedClientIPKey(t *testing.T) {
+	_, err := TrustedClientIPKey("not an ip")
+	assert.Error(t, err)
+
+	fn, err := TrustedClientIPKey("10.0.0.0/24", "192.168.1.1")
+	assert.NoError(t, err)
+
+	key := func(remote, forwarded, realIP string) string {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remote + ":1234"
+		if forwarded != "" {
+			r.Header.Set("X-Forwarded-For", forwarded)
+		}
+		if realIP != "" {
+			r.Header.Set("X-Real-IP", realIP)
+		}
+		k, err := fn(r)
+		assert.NoError(t, err)
+		return k
+	}
+
+	// * 不是可信代理时忽略转发头
+	assert.Equal(t, "3.3.3.3", key("3.3.3.3", "1.1.1.1", "2.2.2.2"))
+	// * 跳过可信代理, 客户端伪造的地址在左边
+	assert.Equal(t, "1.1.1.1", key("10.0.0.1", "9.9.9.9, 1.1.1.1, 192.168.1.1", ""))
+	assert.Equal(t, "2.2.2.2", key("192.168.1.1", "", "2.2.2.2"))
+	assert.Equal(t, "10.0.0.2", key("10.0.0.1", "10.0.0.2", ""))
+}
@@ -0,0 +1,52 @@
+package throttlex
+
+import "time"
+
+// ResetPolicy defines when the quota of LimitSender is reset.
+type ResetPolicy int
+
+const (
+	// ResetDaily resets the quota at midnight of the location.
+	ResetDaily ResetPolicy = iota
+	// ResetHourly resets the quota at the beginning of every hour of the location.
+	ResetHourly
+	// ResetRolling24h resets the quota 24 hours after the first send of the period.
+	ResetRolling24h
+	// ResetWeekly resets the quota at midnight of every Monday of the location.
+	ResetWeekly
+	// ResetMinutely resets the quota at the beginning of every minute.
+	ResetMinutely
+)
+
+// nextReset returns the next reset boundary after now,
+// zero time is returned for the rolling policy which starts on the first send.
+func (p ResetPolicy) nextReset(now time.Time, loc *time.Location) time.Time {
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	t := now.In(loc)
+	switch p {
+	case ResetMinutely:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc).Add(time.Minute)
+	case ResetHourly:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc).Add(time.Hour)
+	case ResetRolling24h:
+		return time.Time{}
+	case ResetWeekly:
+		// * 以周一作为一周的开始
+		days := (int(t.Weekday()) + 6) % 7
+		return time.Date(t.Year(), t.Month(), t.Day()-days, 0, 0, 0, 0, loc).AddDate(0, 0, 7)
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc).AddDate(0, 0, 1)
+	}
+}
+
+// window returns the length of the rolling period.
+func (p ResetPolicy) window() time.Duration {
+	if p == ResetRolling24h {
+		return 24 * time.Hour
+	}
+
+	return 0
+}
@@ -0,0 +1,24 @@
+package throttlex
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResetPolicyNextReset(t *testing.T) {
+	shanghai := time.FixedZone("CST", 8*3600)
+	// * 2023-07-26 17:30 UTC 是上海时间 2023-07-27 01:30 周四
+	now := time.Date(2023, 7, 26, 17, 30, 0, 0, time.UTC)
+
+	assert.Equal(t, time.Date(2023, 7, 27, 0, 0, 0, 0, time.UTC),
+		ResetDaily.nextReset(now, nil))
+	assert.Equal(t, time.Date(2023, 7, 28, 0, 0, 0, 0, shanghai).Unix(),
+		ResetDaily.nextReset(now, shanghai).Unix())
+	assert.Equal(t, time.Date(2023, 7, 27, 2, 0, 0, 0, shanghai).Unix(),
+		ResetHourly.nextReset(now, shanghai).Unix())
+	assert.Equal(t, time.Date(2023, 7, 31, 0, 0, 0, 0, shanghai).Unix(),
+		ResetWeekly.nextReset(now, shanghai).Unix())
+	assert.True(t, ResetRolling24h.nextReset(now, shanghai).IsZero())
+}
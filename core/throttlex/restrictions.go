@@ -3,6 +3,7 @@ package throttlex
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -13,9 +14,15 @@ const (
 	/*
 		--   @Last visit            上次访问时间
 		--   @Overclock             被限制时调用的次数
+		--   @Overclock start       统计超频次数窗口的开始时间
+		--   @Banned until          封禁结束的时间
+		--   @Level                 已经封禁的次数
 	*/
-	FieldLastVisit = "last_visit"
-	FieldOverclock = "overclock"
+	FieldLastVisit      = "last_visit"
+	FieldOverclock      = "overclock"
+	FieldOverclockStart = "overclock_start"
+	FieldBannedUntil    = "banned_until"
+	FieldLevel          = "level"
 
 	// default
 	DefaultAction   = "default_action"
@@ -25,6 +32,7 @@ const (
 		status
 		-- 1 允许访问
 		-- 2 时间间隔限制中
+		-- 3 频繁访问被封禁中
 	*/
 	Allow        = 1
 	TimeFail     = 2
@@ -41,17 +49,40 @@ type (
 		name     string
 		action   string // * 执行的动作
 		interval int64  // * 访问key的间隔(毫秒)
+		fallback *FallbackConfig
+		penalty  *penaltyOptions
+	}
 
+	// * 超频封禁的配置
+	penaltyOptions struct {
+		threshold int           // * 窗口内超频多少次后封禁
+		window    time.Duration // * 统计超频次数的窗口
+		ban       time.Duration // * 第一次封禁的时长, 之后每次翻倍
+		maxBan    time.Duration // * 最长封禁时长
 	}
 
 	Restrictions struct {
-		options *restrictionsOptions
-		store   *redis.Client
+		options  *restrictionsOptions
+		store    redis.UniversalClient
+		fallback *fallback
+	}
+
+	// restrictionEntry is the local state of Restrictions used when redis is unavailable.
+	restrictionEntry struct {
+		lastVisit      time.Time
+		overclock      int
+		overclockStart time.Time
+		bannedUntil    time.Time
+		level          int
+		expireAt       time.Time
 	}
 
+	// A RestrictionLimit is the state of a key of Restrictions.
 	RestrictionLimit struct {
-		LastVisit time.Time
-		Overclock int
+		LastVisit   time.Time
+		Overclock   int
+		BannedUntil time.Time // * 封禁结束的时间, 零值表示未被封禁过
+		Level       int       // * 已经封禁的次数
 	}
 )
 
@@ -73,7 +104,35 @@ func SetRestrictionsName(value string) RestrictionsOptions {
 	}
 }
 
-func NewRestrictions(cli *redis.Client,
+// * redis 不可用时切换到本地限流
+func SetRestrictionsFallback(config FallbackConfig) RestrictionsOptions {
+	return func(ro *restrictionsOptions) {
+		ro.fallback = &config
+	}
+}
+
+// SetRestrictionsPenalty bans the key after threshold overclocks within window,
+// the first ban lasts ban and doubles on every following ban up to maxBan.
+// The ban level is kept until the key stays quiet for a whole window after the ban.
+func SetRestrictionsPenalty(threshold int, window, ban, maxBan time.Duration) RestrictionsOptions {
+	return func(ro *restrictionsOptions) {
+		if threshold <= 0 {
+			ro.penalty = nil
+			return
+		}
+		if maxBan < ban {
+			maxBan = ban
+		}
+		ro.penalty = &penaltyOptions{
+			threshold: threshold,
+			window:    window,
+			ban:       ban,
+			maxBan:    maxBan,
+		}
+	}
+}
+
+func NewRestrictions(cli redis.UniversalClient,
 	opts ...RestrictionsOptions) *Restrictions {
 
 	options := &restrictionsOptions{
@@ -86,10 +145,15 @@ func NewRestrictions(cli *redis.Client,
 		v(options)
 	}
 
-	return &Restrictions{
+	r := &Restrictions{
 		store:   cli,
 		options: options,
 	}
+	if options.fallback != nil {
+		r.fallback = newFallback("restrictions", cli, *options.fallback, r.localExpire())
+	}
+
+	return r
 }
 
 func (r *Restrictions) Key(key string) string {
@@ -98,17 +162,241 @@ func (r *Restrictions) Key(key string) string {
 
 // * 判断是否允许
 func (r *Restrictions) Allow(ctx context.Context, key string) (status int, err error) {
+	result, err := r.TakeResultCtx(ctx, key)
+	if err != nil {
+		return
+	}
+
+	return result.State, nil
+}
+
+// TakeResultCtx requests a permit with context, the State of the result is Allow or TimeFail,
+// or FrequentFail if the key is banned by the penalty.
+func (r *Restrictions) TakeResultCtx(ctx context.Context, key string) (*LimitResult, error) {
+	if r.fallback != nil && r.fallback.degraded() {
+		return r.allowLocal(key), nil
+	}
 
 	// * 当前访问时间
 	ARGV_1 := time.Now().UnixMilli()
 	ARGV_2 := r.options.interval
+	var threshold, window, ban, maxBan int64
+	if p := r.options.penalty; p != nil {
+		threshold = int64(p.threshold)
+		window = p.window.Milliseconds()
+		ban = p.ban.Milliseconds()
+		maxBan = p.maxBan.Milliseconds()
+	}
 
-	status, err = script.RestrictionsAllowScript.Run(ctx, r.store,
-		[]string{r.Key(key)}, ARGV_1, ARGV_2).Int()
+	res, err := script.RestrictionsAllowScript.Run(ctx, r.store,
+		[]string{r.Key(key)}, ARGV_1, ARGV_2, threshold, window, ban, maxBan).Int64Slice()
 
 	if err != nil {
-		return
+		if r.fallback != nil && r.fallback.failed(err) {
+			return r.allowLocal(key), nil
+		}
+		return nil, err
+	}
+	if len(res) != 2 {
+		return nil, ErrUnknownCode
+	}
+
+	return r.newResult(int(res[0]), time.Duration(res[1])*time.Millisecond), nil
+}
+
+// * 使用本地状态判断是否允许
+func (r *Restrictions) allowLocal(key string) *LimitResult {
+	var status int
+	var wait time.Duration
+	now := time.Now()
+	interval := time.Duration(r.options.interval) * time.Millisecond
+	penalty := r.options.penalty
+	r.fallback.update(r.Key(key), now, func() (localEntry, time.Duration) {
+		return &restrictionEntry{}, r.localExpire()
+	}, func(v localEntry) {
+		entry := v.(*restrictionEntry)
+		if entry.bannedUntil.After(now) {
+			status = FrequentFail
+			wait = entry.bannedUntil.Sub(now)
+			return
+		}
+
+		if entry.lastVisit.IsZero() {
+			// * 与脚本一致, 第一次访问同样受访问间隔限制, 但不计入封禁的超频次数
+			entry.lastVisit = now
+			status = Allow
+			if interval > 0 {
+				status = TimeFail
+				wait = interval
+				if penalty == nil {
+					entry.overclock++
+				}
+			}
+			return
+		}
+
+		if entry.lastVisit.Add(interval).After(now) {
+			// * 访问间隔限制中
+			status = TimeFail
+			wait = entry.lastVisit.Add(interval).Sub(now)
+			if penalty == nil {
+				entry.overclock++
+				return
+			}
+
+			if !now.Before(entry.overclockStart.Add(penalty.window)) {
+				entry.overclock = 0
+				entry.overclockStart = now
+			}
+			entry.overclock++
+			if entry.overclock < penalty.threshold {
+				return
+			}
+
+			wait = penalty.duration(entry.level)
+			entry.overclock = 0
+			entry.overclockStart = now
+			entry.bannedUntil = now.Add(wait)
+			entry.level++
+			entry.expireAt = entry.bannedUntil.Add(penalty.window)
+			status = FrequentFail
+			return
+		}
+
+		entry.lastVisit = now
+		keep := interval
+		if penalty != nil && penalty.window > keep {
+			keep = penalty.window
+		}
+		if entry.expireAt.Before(now.Add(keep)) {
+			entry.expireAt = now.Add(keep)
+		}
+		status = Allow
+	})
+
+	return r.newResult(status, wait)
+}
+
+// Get returns the state of the key, a zero RestrictionLimit is returned if the key is not visited.
+func (r *Restrictions) Get(ctx context.Context, key string) (*RestrictionLimit, error) {
+	if r.fallback != nil && r.fallback.degraded() {
+		return r.getLocal(key), nil
 	}
 
-	return
+	values, err := r.store.HGetAll(ctx, r.Key(key)).Result()
+	if err != nil {
+		if r.fallback != nil && r.fallback.failed(err) {
+			return r.getLocal(key), nil
+		}
+		return nil, err
+	}
+
+	limit := &RestrictionLimit{
+		LastVisit:   parseMilli(values[FieldLastVisit]),
+		BannedUntil: parseMilli(values[FieldBannedUntil]),
+	}
+	// * 未开启封禁时 overclock 通过 HINCRBYFLOAT 累加
+	if v, err := strconv.ParseFloat(values[FieldOverclock], 64); err == nil {
+		limit.Overclock = int(v)
+	}
+	if v, err := strconv.Atoi(values[FieldLevel]); err == nil {
+		limit.Level = v
+	}
+
+	return limit, nil
+}
+
+func (r *Restrictions) getLocal(key string) *RestrictionLimit {
+	limit := &RestrictionLimit{}
+	r.fallback.update(r.Key(key), time.Now(), func() (localEntry, time.Duration) {
+		return &restrictionEntry{}, r.localExpire()
+	}, func(v localEntry) {
+		entry := v.(*restrictionEntry)
+		limit.LastVisit = entry.lastVisit
+		limit.Overclock = entry.overclock
+		limit.BannedUntil = entry.bannedUntil
+		limit.Level = entry.level
+	})
+
+	return limit
+}
+
+// Unban lifts the ban of the key and resets its ban level and overclock counter.
+func (r *Restrictions) Unban(ctx context.Context, key string) error {
+	if r.fallback != nil {
+		r.fallback.update(r.Key(key), time.Now(), func() (localEntry, time.Duration) {
+			return &restrictionEntry{}, r.localExpire()
+		}, func(v localEntry) {
+			entry := v.(*restrictionEntry)
+			entry.bannedUntil = time.Time{}
+			entry.level = 0
+			entry.overclock = 0
+			entry.overclockStart = time.Time{}
+		})
+		if r.fallback.degraded() {
+			return nil
+		}
+	}
+
+	err := r.store.HDel(ctx, r.Key(key),
+		FieldOverclock, FieldOverclockStart, FieldBannedUntil, FieldLevel).Err()
+	if err != nil && r.fallback != nil && r.fallback.failed(err) {
+		return nil
+	}
+
+	return err
+}
+
+// * 间隔限制每个间隔只允许访问一次
+func (r *Restrictions) newResult(status int, wait time.Duration) *LimitResult {
+	result := &LimitResult{
+		State:   status,
+		Allowed: status == Allow,
+		Limit:   1,
+	}
+	if result.Allowed {
+		result.ResetAt = time.Now().Add(time.Duration(r.options.interval) * time.Millisecond)
+	} else {
+		result.ResetAt = time.Now().Add(wait)
+		result.RetryAfter = wait
+	}
+
+	return result
+}
+
+// * 本地缓存的过期时间需要覆盖最长的封禁, 条目是否有效由 expireAt 判断
+func (r *Restrictions) localExpire() time.Duration {
+	expire := time.Duration(r.options.interval) * time.Millisecond
+	if p := r.options.penalty; p != nil && p.maxBan+p.window > expire {
+		expire = p.maxBan + p.window
+	}
+
+	return expire
+}
+
+// * 第 level+1 次封禁的时长
+func (p *penaltyOptions) duration(level int) time.Duration {
+	ban := p.ban
+	for i := 0; i < level && ban < p.maxBan; i++ {
+		ban *= 2
+	}
+	if ban > p.maxBan {
+		ban = p.maxBan
+	}
+
+	return ban
+}
+
+// * 毫秒时间戳转换为时间, 空值返回零值
+func parseMilli(value string) time.Time {
+	v, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || v <= 0 {
+		return time.Time{}
+	}
+
+	return time.UnixMilli(v)
+}
+
+func (e *restrictionEntry) expired(now time.Time) bool {
+	return !e.expireAt.IsZero() && !now.Before(e.expireAt)
 }
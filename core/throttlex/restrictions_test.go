@@ -0,0 +1,132 @@
+package throttlex
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestrictionsFirstVisit(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	ctx := context.Background()
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	// * 第一次访问同样受访问间隔限制
+	r := NewRestrictions(client, SetRestrictionsInterval(50))
+	result, err := r.TakeResultCtx(ctx, "user")
+	assert.NoError(t, err)
+	assert.Equal(t, TimeFail, result.State)
+	assert.Equal(t, 50*time.Millisecond, result.RetryAfter)
+	limit, err := r.Get(ctx, "user")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, limit.Overclock)
+	assert.False(t, limit.LastVisit.IsZero())
+
+	time.Sleep(60 * time.Millisecond)
+	status, err := r.Allow(ctx, "user")
+	assert.NoError(t, err)
+	assert.Equal(t, Allow, status)
+
+	// * 没有访问间隔时直接允许
+	r = NewRestrictions(client, SetRestrictionsAction("none"), SetRestrictionsInterval(0))
+	status, err = r.Allow(ctx, "user")
+	assert.NoError(t, err)
+	assert.Equal(t, Allow, status)
+}
+
+func TestRestrictionsPenalty(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	ctx := context.Background()
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	r := NewRestrictions(client,
+		SetRestrictionsInterval(60000),
+		SetRestrictionsPenalty(2, time.Minute, 50*time.Millisecond, 80*time.Millisecond))
+
+	// * 第一次访问受间隔限制, 但不计入超频次数
+	status, err := r.Allow(ctx, "user")
+	assert.NoError(t, err)
+	assert.Equal(t, TimeFail, status)
+
+	status, err = r.Allow(ctx, "user")
+	assert.NoError(t, err)
+	assert.Equal(t, TimeFail, status)
+
+	// * 窗口内第二次超频被封禁
+	result, err := r.TakeResultCtx(ctx, "user")
+	assert.NoError(t, err)
+	assert.Equal(t, FrequentFail, result.State)
+	assert.Equal(t, 50*time.Millisecond, result.RetryAfter)
+
+	limit, err := r.Get(ctx, "user")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, limit.Level)
+	assert.Equal(t, 0, limit.Overclock)
+	assert.True(t, limit.BannedUntil.After(time.Now()))
+	assert.False(t, limit.LastVisit.IsZero())
+
+	// * 封禁结束后再次超频, 封禁时长翻倍但不超过上限
+	time.Sleep(60 * time.Millisecond)
+	status, err = r.Allow(ctx, "user")
+	assert.NoError(t, err)
+	assert.Equal(t, TimeFail, status)
+	result, err = r.TakeResultCtx(ctx, "user")
+	assert.NoError(t, err)
+	assert.Equal(t, FrequentFail, result.State)
+	assert.Equal(t, 80*time.Millisecond, result.RetryAfter)
+
+	assert.NoError(t, r.Unban(ctx, "user"))
+	limit, err = r.Get(ctx, "user")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, limit.Level)
+	assert.True(t, limit.BannedUntil.IsZero())
+
+	status, err = r.Allow(ctx, "user")
+	assert.NoError(t, err)
+	assert.Equal(t, TimeFail, status)
+}
+
+func TestRestrictionsPenaltyLocal(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	client := redis.NewClient(&redis.Options{
+		Addr:       mr.Addr(),
+		MaxRetries: -1,
+	})
+	mr.Close()
+
+	r := NewRestrictions(client,
+		SetRestrictionsInterval(60000),
+		SetRestrictionsPenalty(1, time.Minute, time.Minute, time.Hour),
+		SetRestrictionsFallback(FallbackConfig{ProbeInterval: time.Minute}))
+
+	var states []int
+	for i := 0; i < 3; i++ {
+		status, err := r.Allow(ctx, "user")
+		assert.NoError(t, err)
+		states = append(states, status)
+	}
+	assert.Equal(t, []int{TimeFail, FrequentFail, FrequentFail}, states)
+
+	limit, err := r.Get(ctx, "user")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, limit.Level)
+
+	// * 解封后封禁等级从头开始
+	assert.NoError(t, r.Unban(ctx, "user"))
+	result, err := r.TakeResultCtx(ctx, "user")
+	assert.NoError(t, err)
+	assert.Equal(t, FrequentFail, result.State)
+	assert.Equal(t, time.Minute, result.RetryAfter)
+}
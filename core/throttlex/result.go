@@ -0,0 +1,69 @@
+package throttlex
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	HeaderRateLimitLimit     = "RateLimit-Limit"
+	HeaderRateLimitRemaining = "RateLimit-Remaining"
+	HeaderRateLimitReset     = "RateLimit-Reset"
+	HeaderRetryAfter         = "Retry-After"
+)
+
+type (
+	// A LimitResult describes the state of a key after a permit was requested.
+	LimitResult struct {
+		State      int           // * 限流器的状态码, 如 Allowed / HitQuota / OverQuota
+		Allowed    bool          // * 本次请求是否被允许
+		Limit      int           // * 窗口内允许的次数
+		Remaining  int           // * 窗口内剩余的次数
+		ResetAt    time.Time     // * 配额恢复的时间
+		RetryAfter time.Duration // * 被拒绝时需要等待的时间
+	}
+
+	// A ResultLimiter requests a permit and reports the detailed result.
+	ResultLimiter interface {
+		TakeResultCtx(ctx context.Context, key string) (*LimitResult, error)
+	}
+)
+
+// ResetAfter returns the duration from now until the quota is reset.
+func (r *LimitResult) ResetAfter() time.Duration {
+	d := time.Until(r.ResetAt)
+	if d < 0 {
+		return 0
+	}
+
+	return d
+}
+
+// WriteRateLimitHeaders writes the RateLimit-* headers of result to w,
+// Retry-After is written only if the request is rejected.
+func WriteRateLimitHeaders(w http.ResponseWriter, result *LimitResult) {
+	if result == nil {
+		return
+	}
+
+	header := w.Header()
+	header.Set(HeaderRateLimitLimit, strconv.Itoa(result.Limit))
+	header.Set(HeaderRateLimitRemaining, strconv.Itoa(result.Remaining))
+	header.Set(HeaderRateLimitReset, strconv.FormatInt(ceilSeconds(result.ResetAfter()), 10))
+
+	if !result.Allowed {
+		retry := ceilSeconds(result.RetryAfter)
+		if retry < 1 {
+			retry = 1
+		}
+		header.Set(HeaderRetryAfter, strconv.FormatInt(retry, 10))
+	}
+}
+
+// * 向上取整到秒
+func ceilSeconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,82 @@
+package throttlex
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteRateLimitHeaders(t *testing.T) {
+	w := httptest.NewRecorder()
+	WriteRateLimitHeaders(w, &LimitResult{
+		State:     Allowed,
+		Allowed:   true,
+		Limit:     10,
+		Remaining: 9,
+		ResetAt:   time.Now().Add(30 * time.Second),
+	})
+	assert.Equal(t, "10", w.Header().Get(HeaderRateLimitLimit))
+	assert.Equal(t, "9", w.Header().Get(HeaderRateLimitRemaining))
+	assert.Equal(t, "30", w.Header().Get(HeaderRateLimitReset))
+	assert.Empty(t, w.Header().Get(HeaderRetryAfter))
+
+	w = httptest.NewRecorder()
+	WriteRateLimitHeaders(w, &LimitResult{
+		State:      OverQuota,
+		Limit:      10,
+		ResetAt:    time.Now().Add(1500 * time.Millisecond),
+		RetryAfter: 1500 * time.Millisecond,
+	})
+	assert.Equal(t, "0", w.Header().Get(HeaderRateLimitRemaining))
+	assert.Equal(t, "2", w.Header().Get(HeaderRetryAfter))
+}
+
+func TestTakeResult(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	client := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+
+	ctx := context.Background()
+	p := NewPeriodLimit(60, 1, client, "period:")
+	result, err := p.TakeResultCtx(ctx, "user")
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+	result, err = p.TakeResultCtx(ctx, "user")
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, OverQuota, result.State)
+	assert.InDelta(t, time.Minute, result.RetryAfter, float64(time.Second))
+
+	r := NewRestrictions(client, SetRestrictionsInterval(50))
+	result, err = r.TakeResultCtx(ctx, "user")
+	assert.NoError(t, err)
+	assert.Equal(t, TimeFail, result.State)
+	assert.Equal(t, 50*time.Millisecond, result.RetryAfter)
+	time.Sleep(60 * time.Millisecond)
+	result, err = r.TakeResultCtx(ctx, "user")
+	assert.NoError(t, err)
+	assert.Equal(t, Allow, result.State)
+	result, err = r.TakeResultCtx(ctx, "user")
+	assert.NoError(t, err)
+	assert.Equal(t, TimeFail, result.State)
+	assert.InDelta(t, 50*time.Millisecond, result.RetryAfter, float64(10*time.Millisecond))
+
+	l := NewTokenLimiter(1, 1, client, "token:")
+	result, err = l.TakeResultCtx(ctx, "user")
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+	result, err = l.TakeResultCtx(ctx, "user")
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.True(t, result.RetryAfter > 0)
+}
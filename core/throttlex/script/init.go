@@ -15,19 +15,58 @@ var RestrictionsAllowScript *redis.Script
 var limit_send_script string
 var LimitSendScript *redis.Script
 
+//go:embed limit_send_refund.lua
+var limit_send_refund_script string
+var LimitSendRefundScript *redis.Script
+
+//go:embed token_limit.lua
+var token_limit_script string
+var TokenLimitScript *redis.Script
+
+//go:embed sliding_log.lua
+var sliding_log_script string
+var SlidingLogScript *redis.Script
+
+//go:embed sliding_window.lua
+var sliding_window_script string
+var SlidingWindowScript *redis.Script
+
+//go:embed semaphore_acquire.lua
+var semaphore_acquire_script string
+var SemaphoreAcquireScript *redis.Script
+
+//go:embed semaphore_renew.lua
+var semaphore_renew_script string
+var SemaphoreRenewScript *redis.Script
+
 func init() {
 	// * restrictionsScript
 	RestrictionsAllowScript = redis.NewScript(restrictions_allow_script)
 	// * limitSendScript
 	LimitSendScript = redis.NewScript(limit_send_script)
+	// * limitSendRefundScript
+	LimitSendRefundScript = redis.NewScript(limit_send_refund_script)
+	// * tokenLimitScript
+	TokenLimitScript = redis.NewScript(token_limit_script)
+	// * slidingLogScript
+	SlidingLogScript = redis.NewScript(sliding_log_script)
+	// * slidingWindowScript
+	SlidingWindowScript = redis.NewScript(sliding_window_script)
+	// * semaphoreAcquireScript
+	SemaphoreAcquireScript = redis.NewScript(semaphore_acquire_script)
+	// * semaphoreRenewScript
+	SemaphoreRenewScript = redis.NewScript(semaphore_renew_script)
 }
 
-func GetLimitSendScript(ctx context.Context, rds *redis.Client) *redis.Script {
-	result := redis.NewScript(limit_send_script).Load(ctx, rds)
-	str, err := result.Result()
-	if err != nil {
+// GetLimitSendScript loads LimitSendScript into rds, it panics if the script can't be loaded.
+func GetLimitSendScript(ctx context.Context, rds redis.UniversalClient) *redis.Script {
+	if err := LoadLimitSendScript(ctx, rds); err != nil {
 		panic(err)
 	}
-	_ = str
 	return LimitSendScript
 }
+
+// LoadLimitSendScript loads LimitSendScript into rds.
+func LoadLimitSendScript(ctx context.Context, rds redis.UniversalClient) error {
+	return LimitSendScript.Load(ctx, rds).Err()
+}
@@ -0,0 +1,47 @@
+-- LimitSendRefund 发送失败时退还预约的发送次数
+
+-- KEYS[1]    预约记录的key
+-- KEYS[2..n] 预约记录中规则的key, 调用方读取预约记录后按顺序传入, 与 KEYS[1] 使用同一个 hash tag
+--
+-- @ return
+-- 1 退还成功
+-- 0 预约记录不存在(已过期或者已退还)
+
+local record = redis.call("GET", KEYS[1])
+if not record then
+    return 0
+end
+
+local reservation = cjson.decode(record)
+-- 只操作声明过的key
+if #reservation ~= #KEYS then
+    return redis.error_reply("ERR limit_send_refund: reservation keys mismatch")
+end
+for i = 2, #reservation do
+    if reservation[i][1] ~= KEYS[i] then
+        return redis.error_reply("ERR limit_send_refund: reservation keys mismatch")
+    end
+end
+redis.call("DEL", KEYS[1])
+
+local now = tonumber(reservation[1])
+
+for i = 2, #reservation do
+    local key = KEYS[i]
+    local lastTime = tonumber(reservation[i][2])
+    local resetAt = tonumber(reservation[i][3])
+
+    local count, currentLast, currentReset = unpack(redis.call("HMGET", key, "count", "last_time", "reset_at"))
+    -- 只退还同一个周期内的次数
+    if count and tonumber(currentReset) == resetAt then
+        if tonumber(count) > 0 then
+            redis.call("HINCRBY", key, "count", -1)
+        end
+        -- 之后没有新的发送才恢复发送时间
+        if tonumber(currentLast) == now then
+            redis.call("HSET", key, "last_time", lastTime)
+        end
+    end
+end
+
+return 1
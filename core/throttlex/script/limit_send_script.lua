@@ -1,45 +1,94 @@
--- 获取当前时间戳
-local now = tonumber(redis.call("TIME")[1])
--- 如果时区是 UTC+8，那么 UTC+0 时间戳转换成UTC+8，就需要加上 8 小时的偏移量，即 (now - (now % 86400)) + 8*3600。这样获取的时间戳就是UTC+8 0 点整的时间戳了。
--- 获取今天零点时间戳如果不转换就是八点
-local timezone_offset = 8 * 3600 -- 时区偏移量，单位是秒
-local today = now - (now % 86400)
-
--- 获取已发送次数和最后发送时间
-local count, lastTime, expireTime = unpack(redis.call("HMGET", KEYS[1], "count", "last_time"))
-
-if not count then
-    -- 如果没有记录则初始化
-    count = 0
-    lastTime = 0
-    expireTime = tonumber(ARGV[3])
-    redis.call("HMSET", KEYS[1], "count", count, "last_time", lastTime)
-    redis.call("EXPIREAT", KEYS[1], expireTime)
-else
-    count = tonumber(count)
-    lastTime = tonumber(lastTime)
-    expireTime = tonumber(expireTime)
+-- LimitSend 发送次数限制, 一次检查多个规则和多个key
+
+-- KEYS[i]  第i个规则对应的key
+-- KEYS[n]  预约记录的key, 仅在 ARGV[2] > 0 时传入, 用于发送失败时退还次数
+-- 集群模式下所有的key使用同一个 hash tag
+-- ARGV[1]  now 当前时间戳(秒)
+-- ARGV[2]  预约记录的过期时间(秒), 0 表示不保存
+-- 每个规则依次传入4个参数:
+--   interval 每次发送的间隔(秒)
+--   limit    每个周期的限制次数, 0 表示不限制次数
+--   reset_at 周期结束的时间戳(秒), 0 表示滚动周期从第一次发送开始计算
+--   window   滚动周期的长度(秒)
+--
+--   @count                 当前周期已发送次数
+--   @last_time             最后发送时间
+--   @reset_at              当前周期结束的时间
+--
+-- @ return {wait, blocked, json}
+-- wait    需要等待的最长时间
+-- blocked 等待时间最长的规则序号, 0 表示允许发送
+-- json    每个规则的 {count, last_time, reset_at, wait}
+
+local now = tonumber(ARGV[1])
+local reservationTTL = tonumber(ARGV[2])
+local rules = #KEYS
+if reservationTTL > 0 then
+    rules = rules - 1
 end
 
-local interval = tonumber(ARGV[1]) -- 间隔时间
-local limit = tonumber(ARGV[2]) -- 每天限制次数
+local states = {}
+local maxWait = 0
+local blocked = 0
+
+for i = 1, rules do
+    local base = 2 + (i - 1) * 4
+    local interval = tonumber(ARGV[base + 1]) -- 间隔时间
+    local limit = tonumber(ARGV[base + 2]) -- 每个周期限制次数
+
+    -- 获取已发送次数,最后发送时间和周期结束时间
+    local count, lastTime, resetAt = unpack(redis.call("HMGET", KEYS[i], "count", "last_time", "reset_at"))
+    count = tonumber(count) or 0
+    lastTime = tonumber(lastTime) or 0
+    resetAt = tonumber(resetAt)
+
+    if not resetAt or now >= resetAt then
+        -- 没有记录或者周期已结束则开始新的周期
+        count = 0
+        resetAt = tonumber(ARGV[base + 3])
+        if resetAt == 0 then
+            resetAt = now + tonumber(ARGV[base + 4])
+        end
+        redis.call("HMSET", KEYS[i], "count", count, "last_time", lastTime, "reset_at", resetAt)
+        redis.call("EXPIREAT", KEYS[i], math.ceil(math.max(resetAt, lastTime + interval)))
+    end
+
+    -- 计算距离下次发送需要等待的时间
+    local waitTime = 0
+    if limit > 0 and count >= limit then
+        waitTime = resetAt - now
+    elseif now - lastTime < interval then
+        waitTime = math.ceil(interval - (now - lastTime))
+    end
+
+    if waitTime > maxWait then
+        maxWait = waitTime
+        blocked = i
+    end
+    states[i] = {count, lastTime, resetAt, waitTime, interval}
+end
 
--- 计算距离下次发送需要等待的时间
-local waitTime = 0
-if count >= limit then
-    -- waitTime = today
-    waitTime = 86400 - (now - today)
-elseif now - lastTime < interval then
-    waitTime = interval - (now - lastTime)
+-- 所有规则都允许时才更新发送次数和最后发送时间
+if maxWait == 0 then
+    -- 预约记录: {now, {key, 之前的发送时间, 周期结束时间}, ...}
+    local reservation = {now}
+    for i = 1, rules do
+        local state = states[i]
+        reservation[i + 1] = {KEYS[i], state[2], state[3]}
+        state[1] = state[1] + 1
+        state[2] = now
+        redis.call("HMSET", KEYS[i], "count", state[1], "last_time", state[2])
+        -- 过期时间对齐周期结束的时间,同时保证发送间隔有效
+        redis.call("EXPIREAT", KEYS[i], math.ceil(math.max(state[3], state[2] + state[5])))
+    end
+    if reservationTTL > 0 then
+        redis.call("SET", KEYS[#KEYS], cjson.encode(reservation), "EX", reservationTTL)
+    end
 end
 
--- 更新发送次数和最后发送时间
-if waitTime == 0 then
-    count = count + 1
-    lastTime = now
-    redis.call("HMSET", KEYS[1], "count", count, "last_time", lastTime)
+for i = 1, rules do
+    states[i][5] = nil
 end
 
 -- 返回结果
-local result = {waitTime, cjson.encode(redis.call("HGETALL", KEYS[1]))}
-return result
+return {maxWait, blocked, cjson.encode(states)}
@@ -3,36 +3,108 @@
 -- KEYS[1] key 主key
 -- ARGV[1] uinx_now 当前时间
 -- ARGV[2] limit_tiem 允许的访问间隔
+-- ARGV[3] threshold  窗口内超频多少次后封禁, 0 表示不封禁
+-- ARGV[4] window     统计超频次数的窗口(毫秒)
+-- ARGV[5] ban        第一次封禁的时长(毫秒), 之后每次翻倍
+-- ARGV[6] max_ban    最长封禁时长(毫秒)
 -- redis-cli --eval ./test.lua 1
 --
 --   @Last visit            上次访问时间
 --   @Overclock             被限制时调用的次数
+--   @Overclock start       统计超频次数窗口的开始时间
+--   @Banned until          封禁结束的时间
+--   @Level                 已经封禁的次数
 --
--- @ return
--- 1 允许访问
--- 2 间隔限制
+-- @ return {status, wait}
+-- status 1 允许访问 2 间隔限制 3 频繁访问被封禁
+-- wait   距离下次允许访问的毫秒数
 
 local unix_now = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local threshold = tonumber(ARGV[3])
+local window = tonumber(ARGV[4])
 
 local last_visit       = "last_visit"
 local overclock        = "overclock"
+local overclock_start  = "overclock_start"
+local banned_until     = "banned_until"
+local level            = "level"
 
-local if_have = redis.call("HEXISTS", KEYS[1], last_visit)
-if if_have == 0
+local values = redis.call("HMGET", KEYS[1], last_visit, overclock, overclock_start, banned_until, level)
+local time_last_visit = tonumber(values[1])
+local time_banned_until = tonumber(values[4]) or 0
+
+if not time_last_visit
 then
-    -- init
+    -- init, 第一次访问同样受访问间隔限制, 但不计入封禁的超频次数
     redis.call("HSET", KEYS[1], last_visit, unix_now)
     redis.call("HSETNX", KEYS[1], overclock, 0)
+    if threshold > 0
+    then
+        redis.call("PEXPIRE", KEYS[1], math.max(interval, window))
+    end
+    if interval <= 0
+    then
+        return {1, 0}
+    end
+    if threshold <= 0
+    then
+        redis.call("HINCRBYFLOAT", KEYS[1], overclock, 1)
+    end
+    return {2, interval}
+end
+
+-- 封禁中
+if time_banned_until > unix_now
+then
+    return {3, time_banned_until - unix_now}
 end
 
 -- 访问间隔限制
-local time_last_visit = tonumber(redis.call("HGET", KEYS[1], last_visit))
-if time_last_visit + tonumber(ARGV[2]) > unix_now
+if time_last_visit and time_last_visit + interval > unix_now
 then
     -- 访问间隔限制中
-    redis.call("HINCRBYFLOAT", KEYS[1], overclock, 1)
-    return 2
+    if threshold <= 0
+    then
+        redis.call("HINCRBYFLOAT", KEYS[1], overclock, 1)
+        return {2, time_last_visit + interval - unix_now}
+    end
+
+    local count = tonumber(values[2]) or 0
+    local start = tonumber(values[3]) or 0
+    if unix_now - start >= window
+    then
+        -- 新的统计窗口
+        count = 0
+        start = unix_now
+    end
+    count = count + 1
+
+    if count < threshold
+    then
+        redis.call("HSET", KEYS[1], overclock, count, overclock_start, start)
+        return {2, time_last_visit + interval - unix_now}
+    end
+
+    -- 超频次数达到阈值, 封禁时长随封禁次数递增
+    local times = tonumber(values[5]) or 0
+    local ban = math.min(tonumber(ARGV[5]) * math.pow(2, times), tonumber(ARGV[6]))
+    redis.call("HSET", KEYS[1], overclock, 0, overclock_start, unix_now,
+        banned_until, unix_now + ban, level, times + 1)
+    -- 封禁结束后再保留一个窗口的封禁次数
+    redis.call("PEXPIRE", KEYS[1], math.ceil(ban + window))
+    return {3, ban}
 end
 
 redis.call("HSET", KEYS[1], last_visit, ARGV[1])
-return 1
+redis.call("HSETNX", KEYS[1], overclock, 0)
+if threshold > 0
+then
+    local ttl = redis.call("PTTL", KEYS[1])
+    local keep = math.max(interval, window)
+    if ttl < keep
+    then
+        redis.call("PEXPIRE", KEYS[1], keep)
+    end
+end
+return {1, 0}
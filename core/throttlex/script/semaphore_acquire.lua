@@ -0,0 +1,34 @@
+-- Semaphore 获取并发许可
+
+-- KEYS[1] 持有者key(zset), score 为租约过期时间
+-- ARGV[1] limit 最多同时持有的数量
+-- ARGV[2] now   当前时间(毫秒)
+-- ARGV[3] ttl   租约时长(毫秒)
+-- ARGV[4] id    租约的唯一标识
+--
+-- @ return {ok, current}
+-- ok      1 获取成功 0 已达到上限
+-- current 当前持有的数量
+
+local limit = tonumber(ARGV[1])
+local now = tonumber(ARGV[2])
+local expire_at = now + tonumber(ARGV[3])
+
+-- 清理崩溃后未释放的过期租约
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now)
+
+local ok = 0
+local current = redis.call("ZCARD", KEYS[1])
+if current < limit then
+    redis.call("ZADD", KEYS[1], expire_at, ARGV[4])
+    current = current + 1
+    ok = 1
+end
+
+-- key 的过期时间与最晚的租约保持一致
+local latest = redis.call("ZRANGE", KEYS[1], -1, -1, "WITHSCORES")
+if latest[2] then
+    redis.call("PEXPIREAT", KEYS[1], latest[2])
+end
+
+return {ok, current}
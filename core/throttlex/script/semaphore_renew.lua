@@ -0,0 +1,24 @@
+-- Semaphore 续约
+
+-- KEYS[1] 持有者key(zset)
+-- ARGV[1] now 当前时间(毫秒)
+-- ARGV[2] ttl 租约时长(毫秒)
+-- ARGV[3] id  租约的唯一标识
+--
+-- @ return 1 续约成功 0 租约已过期或被释放
+
+local now = tonumber(ARGV[1])
+local expire_at = now + tonumber(ARGV[2])
+
+local score = redis.call("ZSCORE", KEYS[1], ARGV[3])
+if not score or tonumber(score) <= now then
+    redis.call("ZREM", KEYS[1], ARGV[3])
+    return 0
+end
+
+redis.call("ZADD", KEYS[1], expire_at, ARGV[3])
+if redis.call("PTTL", KEYS[1]) < expire_at - now then
+    redis.call("PEXPIREAT", KEYS[1], expire_at)
+end
+
+return 1
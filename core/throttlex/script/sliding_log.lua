@@ -0,0 +1,40 @@
+-- SlidingLog 滑动日志限流
+
+-- KEYS[1] 请求日志key(zset)
+-- ARGV[1] limit  窗口内最多请求次数
+-- ARGV[2] window 窗口长度(毫秒)
+-- ARGV[3] now    当前时间(毫秒)
+-- ARGV[4] member 本次请求的唯一标识
+--
+-- @ return {code, current, reset}
+-- code    0 超出配额 1 允许 2 刚好达到配额
+-- current 窗口内的请求次数
+-- reset   最早的请求移出窗口的时间(毫秒)
+
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+-- 移除窗口外的请求
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now - window)
+
+local current = redis.call("ZCARD", KEYS[1])
+local code = 0
+if current < limit then
+    redis.call("ZADD", KEYS[1], now, ARGV[4])
+    redis.call("PEXPIRE", KEYS[1], window)
+    current = current + 1
+    if current < limit then
+        code = 1
+    else
+        code = 2
+    end
+end
+
+local reset = now + window
+local oldest = redis.call("ZRANGE", KEYS[1], 0, 0, "WITHSCORES")
+if oldest[2] then
+    reset = tonumber(oldest[2]) + window
+end
+
+return {code, current, reset}
@@ -0,0 +1,39 @@
+-- SlidingWindow 滑动窗口计数限流(按时间加权前一个窗口的计数)
+
+-- KEYS[1] 当前窗口计数key
+-- KEYS[2] 上一个窗口计数key
+-- ARGV[1] limit  窗口内最多请求次数
+-- ARGV[2] window 窗口长度(毫秒)
+-- ARGV[3] now    当前时间(毫秒)
+--
+-- @ return {code, current, reset}
+-- code    0 超出配额 1 允许 2 刚好达到配额
+-- current 估算的窗口内请求次数
+-- reset   当前窗口结束的时间(毫秒)
+
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local start = now - now % window
+
+local current = tonumber(redis.call("GET", KEYS[1]) or "0")
+local previous = tonumber(redis.call("GET", KEYS[2]) or "0")
+
+-- 上一个窗口在滑动窗口内所占的比例
+local weight = (window - (now - start)) / window
+local estimated = math.floor(previous * weight) + current
+
+local code = 0
+if estimated < limit then
+    redis.call("INCR", KEYS[1])
+    -- 当前窗口在下一个窗口中还会作为上一个窗口使用
+    redis.call("PEXPIRE", KEYS[1], window * 2)
+    estimated = estimated + 1
+    if estimated < limit then
+        code = 1
+    else
+        code = 2
+    end
+end
+
+return {code, estimated, start + window}
@@ -0,0 +1,65 @@
+-- TokenLimit 令牌桶限流
+
+-- KEYS[1] 令牌桶key(hash)
+-- ARGV[1] rate      每秒生成的令牌数
+-- ARGV[2] burst     桶容量
+-- ARGV[3] now       当前时间(毫秒)
+-- ARGV[4] requested 本次需要的令牌数
+-- ARGV[5] reserve   1 允许预支令牌(返回需要等待的时间)
+--
+--   @tokens                桶内剩余令牌(预支时可能为负数)
+--   @refreshed             上次刷新令牌的时间(毫秒)
+--
+-- @ return {ok, tokens, wait}
+-- ok     1 获取成功 0 获取失败
+-- tokens 剩余令牌
+-- wait   需要等待的毫秒数
+
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local requested = tonumber(ARGV[4])
+local reserve = tonumber(ARGV[5])
+
+-- 令牌桶填满所需时间的两倍作为过期时间
+local ttl = math.ceil(burst / rate * 2)
+if ttl < 1 then
+    ttl = 1
+end
+
+local values = redis.call("HMGET", KEYS[1], "tokens", "refreshed")
+local last_tokens = tonumber(values[1])
+if last_tokens == nil then
+    last_tokens = burst
+end
+local last_refreshed = tonumber(values[2])
+if last_refreshed == nil then
+    last_refreshed = 0
+end
+
+local delta = math.max(0, now - last_refreshed)
+local filled = math.min(burst, last_tokens + delta * rate / 1000)
+
+local ok = 0
+local wait = 0
+local tokens = filled
+if filled >= requested then
+    ok = 1
+    tokens = filled - requested
+elseif reserve == 1 and requested <= burst then
+    -- 预支令牌,需要等待令牌补足
+    ok = 1
+    tokens = filled - requested
+    wait = math.ceil((requested - filled) * 1000 / rate)
+end
+
+if ok == 1 then
+    redis.call("HSET", KEYS[1], "tokens", tokens, "refreshed", math.max(now, last_refreshed))
+    redis.call("EXPIRE", KEYS[1], ttl)
+elseif requested <= burst then
+    wait = math.ceil((requested - filled) * 1000 / rate)
+else
+    wait = -1
+end
+
+return {ok, math.floor(tokens), wait}
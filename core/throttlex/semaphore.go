@@ -0,0 +1,236 @@
+package throttlex
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/uc1024/f90/core/slogx"
+	"github.com/uc1024/f90/core/stringx"
+	"github.com/uc1024/f90/core/threadingx"
+	"github.com/uc1024/f90/core/throttlex/script"
+)
+
+// * AcquireWait 未指定间隔时的重试间隔
+const defaultSemaphoreRetryInterval = 100 * time.Millisecond
+
+var (
+	// ErrSemaphoreFull means the key already holds limit leases.
+	ErrSemaphoreFull = errors.New("semaphore is full")
+	// ErrLeaseExpired means the lease is expired or released, it must be acquired again.
+	ErrLeaseExpired = errors.New("semaphore lease expired")
+)
+
+type (
+	// A Semaphore limits how many operations of a key run concurrently across instances,
+	// every holder owns a lease which expires after its ttl unless it's renewed,
+	// so the permits held by a crashed instance are reclaimed automatically.
+	Semaphore struct {
+		store     redis.UniversalClient
+		keyPrefix string // * 存储 Redis key 的前缀
+	}
+
+	// A Lease is a permit acquired from a Semaphore.
+	Lease struct {
+		sem      *Semaphore
+		key      string
+		id       string
+		ttl      time.Duration
+		current  int // * 获取时已被持有的数量, 包括当前租约
+		lock     sync.Mutex
+		expireAt time.Time
+		done     chan struct{} // * 租约释放或丢失时关闭
+		once     sync.Once
+	}
+)
+
+// NewSemaphore returns a Semaphore which saves the leases in store.
+func NewSemaphore(store redis.UniversalClient, keyPrefix string) *Semaphore {
+	return &Semaphore{
+		store:     store,
+		keyPrefix: keyPrefix,
+	}
+}
+
+// Acquire acquires a lease of key which expires after ttl,
+// ErrSemaphoreFull is returned if limit leases are already held.
+func (s *Semaphore) Acquire(ctx context.Context, key string, limit int, ttl time.Duration) (*Lease, error) {
+	now := time.Now()
+	id := stringx.Rand()
+
+	res, err := script.SemaphoreAcquireScript.Run(ctx, s.store, []string{s.keyPrefix + key},
+		limit,
+		now.UnixMilli(),
+		ttl.Milliseconds(),
+		id,
+	).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+	if len(res) != 2 {
+		return nil, ErrUnknownCode
+	}
+	if res[0] != 1 {
+		return nil, ErrSemaphoreFull
+	}
+
+	return &Lease{
+		sem:      s,
+		key:      key,
+		id:       id,
+		ttl:      ttl,
+		current:  int(res[1]),
+		expireAt: now.Add(ttl),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// AcquireWait acquires a lease of key like Acquire,
+// it retries every interval until a lease is acquired or ctx is done,
+// a non-positive interval means 100ms.
+func (s *Semaphore) AcquireWait(ctx context.Context, key string, limit int, ttl,
+	interval time.Duration) (*Lease, error) {
+	if interval <= 0 {
+		interval = defaultSemaphoreRetryInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		lease, err := s.Acquire(ctx, key, limit, ttl)
+		if !errors.Is(err, ErrSemaphoreFull) {
+			return lease, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Count returns how many leases of key are held.
+func (s *Semaphore) Count(ctx context.Context, key string) (int, error) {
+	n, err := s.store.ZCount(ctx, s.keyPrefix+key,
+		"("+formatMilli(time.Now()), "+inf").Result()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n), nil
+}
+
+// Key returns the key of the lease.
+func (l *Lease) Key() string {
+	return l.key
+}
+
+// ID returns the unique id of the lease.
+func (l *Lease) ID() string {
+	return l.id
+}
+
+// Current returns how many leases were held right after the lease was acquired.
+func (l *Lease) Current() int {
+	return l.current
+}
+
+// ExpireAt returns the time the lease expires unless it's renewed.
+func (l *Lease) ExpireAt() time.Time {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.expireAt
+}
+
+// Done returns a channel that's closed when the lease is released or lost.
+func (l *Lease) Done() <-chan struct{} {
+	return l.done
+}
+
+// Renew extends the lease by its ttl from now,
+// ErrLeaseExpired is returned if the lease is already expired or released.
+func (l *Lease) Renew(ctx context.Context) error {
+	now := time.Now()
+	ok, err := script.SemaphoreRenewScript.Run(ctx, l.sem.store, []string{l.sem.keyPrefix + l.key},
+		now.UnixMilli(),
+		l.ttl.Milliseconds(),
+		l.id,
+	).Int()
+	if err != nil {
+		return err
+	}
+	if ok != 1 {
+		l.close()
+		return ErrLeaseExpired
+	}
+
+	l.lock.Lock()
+	l.expireAt = now.Add(l.ttl)
+	l.lock.Unlock()
+
+	return nil
+}
+
+// KeepAlive renews the lease every interval in background until ctx is done,
+// the lease is released or the lease is lost, a non-positive interval means a third of the ttl.
+func (l *Lease) KeepAlive(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = l.ttl / 3
+	}
+	// * ttl 过小时也不能传 0 给 NewTicker
+	if interval <= 0 {
+		interval = time.Millisecond
+	}
+
+	threadingx.GoSafe(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-l.done:
+				return
+			case <-ticker.C:
+			}
+
+			err := l.Renew(ctx)
+			if err == nil || ctx.Err() != nil {
+				continue
+			}
+			if errors.Is(err, ErrLeaseExpired) {
+				return
+			}
+			// * redis 暂时不可用时, 在租约过期前继续重试
+			slogx.Default.Error(ctx, "failed to renew semaphore lease",
+				"key", l.key, "error", err.Error())
+			if !time.Now().Before(l.ExpireAt()) {
+				l.close()
+				return
+			}
+		}
+	})
+}
+
+// Release releases the lease, it's safe to call Release more than once.
+func (l *Lease) Release(ctx context.Context) error {
+	l.close()
+	return l.sem.store.ZRem(ctx, l.sem.keyPrefix+l.key, l.id).Err()
+}
+
+func (l *Lease) close() {
+	l.once.Do(func() {
+		close(l.done)
+	})
+}
+
+// * 毫秒时间戳
+func formatMilli(t time.Time) string {
+	return strconv.FormatInt(t.UnixMilli(), 10)
+}
@@ -0,0 +1,113 @@
+package throttlex
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSemaphore(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	ctx := context.Background()
+	s := NewSemaphore(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "sem:")
+
+	first, err := s.Acquire(ctx, "export", 2, time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, first.Current())
+	second, err := s.Acquire(ctx, "export", 2, time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, second.Current())
+
+	_, err = s.Acquire(ctx, "export", 2, time.Minute)
+	assert.ErrorIs(t, err, ErrSemaphoreFull)
+
+	// * 释放后可以再次获取
+	assert.NoError(t, first.Release(ctx))
+	assert.NoError(t, first.Release(ctx))
+	<-first.Done()
+	n, err := s.Count(ctx, "export")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	third, err := s.Acquire(ctx, "export", 2, time.Minute)
+	assert.NoError(t, err)
+	assert.NoError(t, third.Renew(ctx))
+	assert.NoError(t, second.Release(ctx))
+	assert.NoError(t, third.Release(ctx))
+	assert.ErrorIs(t, third.Renew(ctx), ErrLeaseExpired)
+}
+
+func TestSemaphoreExpire(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	ctx := context.Background()
+	s := NewSemaphore(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "sem:")
+
+	// * 持有者崩溃后租约过期, 许可被回收
+	crashed, err := s.Acquire(ctx, "export", 1, 30*time.Millisecond)
+	assert.NoError(t, err)
+	alive, err := s.AcquireWait(ctx, "export", 1, time.Minute, 10*time.Millisecond)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, crashed.Renew(ctx), ErrLeaseExpired)
+	<-crashed.Done()
+
+	ctx2, cancel := context.WithTimeout(ctx, 30*time.Millisecond)
+	defer cancel()
+	_, err = s.AcquireWait(ctx2, "export", 1, time.Minute, 10*time.Millisecond)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.NoError(t, alive.Release(ctx))
+}
+
+func TestSemaphoreKeepAlive(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := NewSemaphore(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "sem:")
+
+	lease, err := s.Acquire(ctx, "export", 1, 40*time.Millisecond)
+	assert.NoError(t, err)
+	lease.KeepAlive(ctx, 10*time.Millisecond)
+
+	time.Sleep(100 * time.Millisecond)
+	_, err = s.Acquire(ctx, "export", 1, time.Minute)
+	assert.ErrorIs(t, err, ErrSemaphoreFull)
+
+	// * 租约被删除后停止续约
+	mr.Del("sem:export")
+	select {
+	case <-lease.Done():
+	case <-time.After(time.Second):
+		t.Fatal("lease is not closed")
+	}
+}
+
+func TestSemaphoreDefaultInterval(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := NewSemaphore(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "sem:")
+
+	// * 不合法的间隔使用默认值, 不会 panic
+	lease, err := s.AcquireWait(ctx, "export", 1, 40*time.Millisecond, 0)
+	assert.NoError(t, err)
+	lease.KeepAlive(ctx, -time.Second)
+
+	time.Sleep(100 * time.Millisecond)
+	_, err = s.Acquire(ctx, "export", 1, time.Minute)
+	assert.ErrorIs(t, err, ErrSemaphoreFull)
+}
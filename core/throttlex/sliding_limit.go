@@ -0,0 +1,140 @@
+package throttlex
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/uc1024/f90/core/stringx"
+	"github.com/uc1024/f90/core/throttlex/script"
+)
+
+type (
+	// A SlidingLogLimit is used to limit requests during a sliding period of time,
+	// every request is logged in a sorted set so the quota is never exceeded in any window.
+	SlidingLogLimit struct {
+		period     int // * 时间段长度，单位为秒
+		quota      int // * 时间段内最多请求次数
+		limitStore redis.UniversalClient
+		keyPrefix  string // * 存储 Redis key 的前缀
+	}
+
+	// A SlidingWindowLimit is used to limit requests during a sliding period of time,
+	// the count of the previous window is weighted by its overlap with the sliding window.
+	SlidingWindowLimit struct {
+		period     int // * 时间段长度，单位为秒
+		quota      int // * 时间段内最多请求次数
+		limitStore redis.UniversalClient
+		keyPrefix  string // * 存储 Redis key 的前缀
+	}
+)
+
+// NewSlidingLogLimit returns a SlidingLogLimit with the same arguments as NewPeriodLimit.
+func NewSlidingLogLimit(period, quota int, limitStore redis.UniversalClient,
+	keyPrefix string) *SlidingLogLimit {
+	return &SlidingLogLimit{
+		period:     period,
+		quota:      quota,
+		limitStore: limitStore,
+		keyPrefix:  keyPrefix,
+	}
+}
+
+// Take requests a permit, it returns the permit state.
+func (h *SlidingLogLimit) Take(key string) (int, error) {
+	return h.TakeCtx(context.Background(), key)
+}
+
+// TakeCtx requests a permit with context, it returns the permit state.
+func (h *SlidingLogLimit) TakeCtx(ctx context.Context, key string) (int, error) {
+	result, err := h.TakeResultCtx(ctx, key)
+	if err != nil {
+		return Unknown, err
+	}
+
+	return result.State, nil
+}
+
+// TakeResultCtx requests a permit with context, it returns the permit state
+// along with the remaining quota and the reset time.
+func (h *SlidingLogLimit) TakeResultCtx(ctx context.Context, key string) (*LimitResult, error) {
+	now := time.Now().UnixMilli()
+	member := fmt.Sprintf("%d-%s", now, stringx.Rand())
+
+	res, err := script.SlidingLogScript.Run(ctx, h.limitStore, []string{h.keyPrefix + key},
+		h.quota,
+		h.period*1000,
+		now,
+		member,
+	).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+
+	return slidingResult(h.quota, res)
+}
+
+// NewSlidingWindowLimit returns a SlidingWindowLimit with the same arguments as NewPeriodLimit.
+func NewSlidingWindowLimit(period, quota int, limitStore redis.UniversalClient,
+	keyPrefix string) *SlidingWindowLimit {
+	return &SlidingWindowLimit{
+		period:     period,
+		quota:      quota,
+		limitStore: limitStore,
+		keyPrefix:  keyPrefix,
+	}
+}
+
+// Take requests a permit, it returns the permit state.
+func (h *SlidingWindowLimit) Take(key string) (int, error) {
+	return h.TakeCtx(context.Background(), key)
+}
+
+// TakeCtx requests a permit with context, it returns the permit state.
+func (h *SlidingWindowLimit) TakeCtx(ctx context.Context, key string) (int, error) {
+	result, err := h.TakeResultCtx(ctx, key)
+	if err != nil {
+		return Unknown, err
+	}
+
+	return result.State, nil
+}
+
+// TakeResultCtx requests a permit with context, it returns the permit state
+// along with the remaining quota and the reset time.
+func (h *SlidingWindowLimit) TakeResultCtx(ctx context.Context, key string) (*LimitResult, error) {
+	now := time.Now().UnixMilli()
+	window := int64(h.period) * 1000
+	index := now / window
+
+	// * 使用 hash tag 保证两个窗口的 key 在集群中落在同一个节点
+	keys := []string{
+		fmt.Sprintf("{%s%s}:%d", h.keyPrefix, key, index),
+		fmt.Sprintf("{%s%s}:%d", h.keyPrefix, key, index-1),
+	}
+	res, err := script.SlidingWindowScript.Run(ctx, h.limitStore, keys,
+		h.quota,
+		window,
+		now,
+	).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+
+	return slidingResult(h.quota, res)
+}
+
+// slidingResult converts {code, current, reset} returned by the sliding scripts.
+func slidingResult(quota int, res []int64) (*LimitResult, error) {
+	if len(res) != 3 {
+		return nil, ErrUnknownCode
+	}
+
+	state, err := periodState(int(res[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	return newQuotaResult(state, quota, int(res[1]), time.UnixMilli(res[2])), nil
+}
@@ -0,0 +1,59 @@
+package throttlex
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlidingLimit(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	client := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+
+	limiters := map[string]PeriodLimiter{
+		"period": NewPeriodLimit(3600, 3, client, "period:"),
+		"log":    NewSlidingLogLimit(3600, 3, client, "log:"),
+		"window": NewSlidingWindowLimit(3600, 3, client, "window:"),
+	}
+
+	for name, l := range limiters {
+		var states []int
+		for i := 0; i < 4; i++ {
+			state, err := l.Take("user")
+			assert.NoError(t, err, name)
+			states = append(states, state)
+		}
+		assert.Equal(t, []int{Allowed, Allowed, HitQuota, OverQuota}, states, name)
+	}
+}
+
+func TestSlidingLogLimitResult(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	client := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+
+	l := NewSlidingLogLimit(60, 2, client, "log:")
+	first, err := l.TakeResultCtx(context.Background(), "user")
+	assert.NoError(t, err)
+	assert.Equal(t, Allowed, first.State)
+	assert.Equal(t, 1, first.Remaining)
+
+	second, err := l.TakeResultCtx(context.Background(), "user")
+	assert.NoError(t, err)
+	assert.Equal(t, HitQuota, second.State)
+	assert.Equal(t, 0, second.Remaining)
+	// * 最早的请求移出窗口后恢复配额
+	assert.Equal(t, first.ResetAt, second.ResetAt)
+}
@@ -0,0 +1,188 @@
+package throttlex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/uc1024/f90/core/throttlex/script"
+)
+
+var (
+	// ErrTokenExceedsBurst means the requested tokens can never be satisfied by the bucket.
+	ErrTokenExceedsBurst = errors.New("requested tokens exceed burst")
+)
+
+type (
+	// A TokenLimiter controls how frequently events are allowed to happen,
+	// it allows bursts of at most burst events while keeping the average rate.
+	TokenLimiter struct {
+		rate      int // * 每秒生成的令牌数
+		burst     int // * 桶容量
+		store     redis.UniversalClient
+		keyPrefix string // * 存储 Redis key 的前缀
+	}
+
+	// A TokenReservation holds information about events that are permitted by a TokenLimiter.
+	TokenReservation struct {
+		ok        bool
+		tokens    int64         // * 预支后剩余的令牌
+		timeToAct time.Time     // * 可以执行的时间
+		wait      time.Duration // * 获取失败时需要等待的时间
+	}
+)
+
+// NewTokenLimiter returns a new TokenLimiter that allows events up to rate and permits
+// bursts of at most burst tokens. A non-positive rate is clamped to 1,
+// a non-positive burst is clamped to rate.
+func NewTokenLimiter(rate, burst int, store redis.UniversalClient, keyPrefix string) *TokenLimiter {
+	// * rate 为 0 时脚本中会除以 0
+	if rate <= 0 {
+		rate = 1
+	}
+	if burst <= 0 {
+		burst = rate
+	}
+
+	return &TokenLimiter{
+		rate:      rate,
+		burst:     burst,
+		store:     store,
+		keyPrefix: keyPrefix,
+	}
+}
+
+// Allow is shorthand for AllowN(context.Background(), key, 1, time.Now()).
+func (l *TokenLimiter) Allow(key string) (bool, error) {
+	return l.AllowN(context.Background(), key, 1, time.Now())
+}
+
+// AllowCtx is shorthand for AllowN(ctx, key, 1, time.Now()).
+func (l *TokenLimiter) AllowCtx(ctx context.Context, key string) (bool, error) {
+	return l.AllowN(ctx, key, 1, time.Now())
+}
+
+// AllowN reports whether n events may happen at time now.
+// Use this method if you intend to drop / skip events that exceed the rate.
+func (l *TokenLimiter) AllowN(ctx context.Context, key string, n int, now time.Time) (bool, error) {
+	r, err := l.reserveN(ctx, key, n, now, false)
+	if err != nil {
+		return false, err
+	}
+
+	return r.ok, nil
+}
+
+// TakeResultCtx requests a token with context, the State of the result is Allowed or OverQuota.
+func (l *TokenLimiter) TakeResultCtx(ctx context.Context, key string) (*LimitResult, error) {
+	now := time.Now()
+	r, err := l.reserveN(ctx, key, 1, now, false)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &LimitResult{
+		State:   OverQuota,
+		Allowed: r.ok,
+		Limit:   l.burst,
+		// * 桶被填满的时间
+		ResetAt: now.Add(time.Duration(float64(int64(l.burst)-r.tokens) /
+			float64(l.rate) * float64(time.Second))),
+	}
+	if r.tokens > 0 {
+		result.Remaining = int(r.tokens)
+	}
+	if r.ok {
+		result.State = Allowed
+	} else {
+		result.RetryAfter = r.wait
+	}
+
+	return result, nil
+}
+
+// Reserve is shorthand for ReserveN(ctx, key, 1, time.Now()).
+func (l *TokenLimiter) Reserve(ctx context.Context, key string) (*TokenReservation, error) {
+	return l.ReserveN(ctx, key, 1, time.Now())
+}
+
+// ReserveN returns a TokenReservation that indicates how long the caller must wait
+// before n events happen. The tokens are consumed even if the caller has to wait.
+// Use this method if you wish to wait and slow down in accordance with the rate limit.
+func (l *TokenLimiter) ReserveN(ctx context.Context, key string, n int,
+	now time.Time) (*TokenReservation, error) {
+	if n > l.burst {
+		return nil, ErrTokenExceedsBurst
+	}
+
+	return l.reserveN(ctx, key, n, now, true)
+}
+
+func (l *TokenLimiter) reserveN(ctx context.Context, key string, n int, now time.Time,
+	reserve bool) (*TokenReservation, error) {
+	var reserveArg int
+	if reserve {
+		reserveArg = 1
+	}
+
+	res, err := script.TokenLimitScript.Run(ctx, l.store, []string{l.keyPrefix + key},
+		l.rate,
+		l.burst,
+		now.UnixMilli(),
+		n,
+		reserveArg,
+	).Int64Slice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute token_limit_script: %w", err)
+	}
+
+	if len(res) != 3 {
+		return nil, fmt.Errorf("unexpected result format from token_limit_script")
+	}
+
+	r := &TokenReservation{
+		ok:        res[0] == 1,
+		tokens:    res[1],
+		timeToAct: now,
+	}
+	if r.ok && res[2] > 0 {
+		r.timeToAct = now.Add(time.Duration(res[2]) * time.Millisecond)
+	} else if !r.ok && res[2] > 0 {
+		r.wait = time.Duration(res[2]) * time.Millisecond
+	}
+
+	return r, nil
+}
+
+// OK returns whether the limiter can provide the requested number of tokens.
+func (r *TokenReservation) OK() bool {
+	return r.ok
+}
+
+// Tokens returns the tokens left in the bucket after the reservation,
+// a negative value means the tokens are borrowed from the future.
+func (r *TokenReservation) Tokens() int64 {
+	return r.tokens
+}
+
+// Delay is shorthand for DelayFrom(time.Now()).
+func (r *TokenReservation) Delay() time.Duration {
+	return r.DelayFrom(time.Now())
+}
+
+// DelayFrom returns the duration for which the reservation holder must wait
+// before taking the reserved action. Zero duration means act immediately.
+func (r *TokenReservation) DelayFrom(now time.Time) time.Duration {
+	if !r.ok {
+		return 0
+	}
+
+	delay := r.timeToAct.Sub(now)
+	if delay < 0 {
+		return 0
+	}
+
+	return delay
+}
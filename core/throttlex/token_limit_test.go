@@ -0,0 +1,122 @@
+package throttlex
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenLimiter(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	client := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+
+	ctx := context.Background()
+	l := NewTokenLimiter(10, 5, client, "token:")
+	now := time.Now()
+
+	// * 桶容量内允许突发
+	for i := 0; i < 5; i++ {
+		ok, err := l.AllowN(ctx, "user", 1, now)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	}
+	ok, err := l.AllowN(ctx, "user", 1, now)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// * 100ms 生成一个令牌
+	ok, err = l.AllowN(ctx, "user", 1, now.Add(100*time.Millisecond))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// * 不同的key互不影响
+	ok, err = l.AllowN(ctx, "other", 5, now)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestTokenLimiterReserve(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	client := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+
+	ctx := context.Background()
+	l := NewTokenLimiter(10, 5, client, "token:")
+	now := time.Now()
+
+	r, err := l.ReserveN(ctx, "user", 5, now)
+	assert.NoError(t, err)
+	assert.True(t, r.OK())
+	assert.Equal(t, time.Duration(0), r.DelayFrom(now))
+
+	// * 预支令牌需要等待
+	r, err = l.ReserveN(ctx, "user", 2, now)
+	assert.NoError(t, err)
+	assert.True(t, r.OK())
+	assert.Equal(t, int64(-2), r.Tokens())
+	assert.Equal(t, 200*time.Millisecond, r.DelayFrom(now))
+
+	// * 预支的令牌还没有补足
+	ok, err := l.AllowN(ctx, "user", 1, now.Add(200*time.Millisecond))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = l.AllowN(ctx, "user", 1, now.Add(300*time.Millisecond))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	_, err = l.ReserveN(ctx, "user", 6, now)
+	assert.Equal(t, ErrTokenExceedsBurst, err)
+}
+
+func TestTokenLimiterInvalidRate(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	client := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+
+	ctx := context.Background()
+	// * rate 修正为 1, burst 修正为 rate
+	l := NewTokenLimiter(0, -1, client, "token:")
+	now := time.Now()
+
+	ok, err := l.AllowN(ctx, "user", 1, now)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = l.AllowN(ctx, "user", 1, now)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = l.AllowN(ctx, "user", 1, now.Add(time.Second))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, mr.TTL("token:user") > 0)
+}
+
+func TestTokenLimiterWrapsError(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	l := NewTokenLimiter(1, 1, redis.NewClient(&redis.Options{Addr: mr.Addr()}), "token:")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// * 调用方可以通过 errors.Is 判断原始错误
+	_, err = l.AllowN(ctx, "user", 1, time.Now())
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -35,16 +35,24 @@ local current = redis.call("INCRBY", KEYS[1], 1)
 if current == 1 then
     redis.call("expire", KEYS[1], window)
 end
+local ttl = redis.call("ttl", KEYS[1])
 if current < limit then
-    return 1
+    return {1, current, ttl}
 elseif current == limit then
-    return 2
+    return {2, current, ttl}
 else
-    return 0
+    return {0, current, ttl}
 end`)
 )
 
 type (
+	// PeriodLimiter is implemented by PeriodLimit and the sliding window limiters,
+	// so that they can replace each other without changing the calling code.
+	PeriodLimiter interface {
+		Take(key string) (int, error)
+		TakeCtx(ctx context.Context, key string) (int, error)
+	}
+
 	// PeriodOption defines the method to customize a PeriodLimit.
 	PeriodOption func(l *PeriodLimit)
 
@@ -52,14 +60,21 @@ type (
 	PeriodLimit struct {
 		period     int // * 时间段长度，单位为秒
 		quota      int // * 时间段内最多请求次数
-		limitStore *redis.Client
+		limitStore redis.UniversalClient
 		keyPrefix  string // * 存储 Redis key 的前缀
 		align      bool   // * 是否对齐时间段开始时间
+		fallback   *fallback
+	}
+
+	// periodEntry is the local state of PeriodLimit used when redis is unavailable.
+	periodEntry struct {
+		count    int
+		expireAt time.Time
 	}
 )
 
 // NewPeriodLimit 返回一个配置好的 PeriodLimit 实例
-func NewPeriodLimit(period, quota int, limitStore *redis.Client, keyPrefix string,
+func NewPeriodLimit(period, quota int, limitStore redis.UniversalClient, keyPrefix string,
 	opts ...PeriodOption) *PeriodLimit {
 	limiter := &PeriodLimit{
 		period:     period,
@@ -82,24 +97,72 @@ func (h *PeriodLimit) Take(key string) (int, error) {
 
 // TakeCtx requests a permit with context, it returns the permit state.
 func (h *PeriodLimit) TakeCtx(ctx context.Context, key string) (int, error) {
-	code, err := periodScript.Run(ctx, h.limitStore, []string{h.keyPrefix + key}, []string{
+	result, err := h.TakeResultCtx(ctx, key)
+	if err != nil {
+		return Unknown, err
+	}
+
+	return result.State, nil
+}
+
+// TakeResultCtx requests a permit with context, it returns the permit state
+// along with the remaining quota and the reset time.
+func (h *PeriodLimit) TakeResultCtx(ctx context.Context, key string) (*LimitResult, error) {
+	if h.fallback != nil && h.fallback.degraded() {
+		return h.takeLocal(key), nil
+	}
+
+	res, err := periodScript.Run(ctx, h.limitStore, []string{h.keyPrefix + key}, []string{
 		strconv.Itoa(h.quota),
 		strconv.Itoa(h.calcExpireSeconds()),
-	}).Int()
+	}).Int64Slice()
 	if err != nil {
-		return Unknown, err
+		if h.fallback != nil && h.fallback.failed(err) {
+			return h.takeLocal(key), nil
+		}
+		return nil, err
+	}
+	if len(res) != 3 {
+		return nil, ErrUnknownCode
 	}
 
-	switch code {
-	case internalOverQuota:
-		return OverQuota, nil
-	case internalAllowed:
-		return Allowed, nil
-	case internalHitQuota:
-		return HitQuota, nil
-	default:
-		return Unknown, ErrUnknownCode
+	state, err := periodState(int(res[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := time.Duration(res[2]) * time.Second
+	if ttl < 0 {
+		ttl = 0
+	}
+
+	return newQuotaResult(state, h.quota, int(res[1]), time.Now().Add(ttl)), nil
+}
+
+// takeLocal requests a permit from the in-process state.
+func (h *PeriodLimit) takeLocal(key string) *LimitResult {
+	var current int
+	var resetAt time.Time
+	now := time.Now()
+	h.fallback.update(key, now, func() (localEntry, time.Duration) {
+		expire := time.Duration(h.calcExpireSeconds()) * time.Second
+		return &periodEntry{expireAt: now.Add(expire)}, expire
+	}, func(v localEntry) {
+		entry := v.(*periodEntry)
+		entry.count++
+		current = entry.count
+		resetAt = entry.expireAt
+	})
+
+	state := OverQuota
+	switch {
+	case current < h.quota:
+		state = Allowed
+	case current == h.quota:
+		state = HitQuota
 	}
+
+	return newQuotaResult(state, h.quota, current, resetAt)
 }
 
 func (h *PeriodLimit) calcExpireSeconds() int {
@@ -115,9 +178,53 @@ func (h *PeriodLimit) calcExpireSeconds() int {
 
 }
 
+// newQuotaResult returns the LimitResult of the quota based limiters.
+func newQuotaResult(state, quota, current int, resetAt time.Time) *LimitResult {
+	result := &LimitResult{
+		State:   state,
+		Allowed: state == Allowed || state == HitQuota,
+		Limit:   quota,
+		ResetAt: resetAt,
+	}
+
+	if current < quota {
+		result.Remaining = quota - current
+	}
+	if !result.Allowed {
+		result.RetryAfter = result.ResetAfter()
+	}
+
+	return result
+}
+
+// periodState converts the code returned by the scripts to the permit state.
+func periodState(code int) (int, error) {
+	switch code {
+	case internalOverQuota:
+		return OverQuota, nil
+	case internalAllowed:
+		return Allowed, nil
+	case internalHitQuota:
+		return HitQuota, nil
+	default:
+		return Unknown, ErrUnknownCode
+	}
+}
+
+func (e *periodEntry) expired(now time.Time) bool {
+	return !now.Before(e.expireAt)
+}
+
 // Align 返回一个 PeriodOption，用于对 limiter 进行对齐配置
 func Align() PeriodOption {
 	return func(l *PeriodLimit) {
 		l.align = true
 	}
 }
+
+// PeriodFallback 返回一个 PeriodOption，redis 不可用时切换到本地限流
+func PeriodFallback(config FallbackConfig) PeriodOption {
+	return func(l *PeriodLimit) {
+		l.fallback = newFallback("period_limit:"+l.keyPrefix, l.limitStore, config, time.Duration(l.period)*time.Second)
+	}
+}